@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+// cleanAlias is the executable name Git runs for 'git ignoregrets-clean'
+const cleanAlias = "git-ignoregrets-clean"
+
+var cleanCmd = &cobra.Command{
+	Use:   "clean [git-clean-args]",
+	Short: "Snapshot ignored files, then run git clean",
+	Long: `Create a safety snapshot of Git-ignored files, then run 'git clean' with
+the given arguments. Git has no hook for 'git clean', so this wrapper is the
+way to protect files that 'git clean -fdx' would delete.
+
+The snapshot is marked as a pre-clean safety copy in its manifest.
+The .ignoregrets directory is always kept. Nothing is snapshotted for
+dry runs (-n, --dry-run).
+
+The ignoregrets flags --profile, --set and --wait are applied before the
+first '--' and not passed on to git clean.
+
+Install a copy or symlink of ignoregrets named git-ignoregrets-clean on
+your PATH to run it as 'git ignoregrets-clean -fdx'.`,
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		args, err := parseCleanArgs(cmd, args)
+		if err != nil {
+			return err
+		}
+		if isCleanDryRun(args) {
+			return git.Clean(cmd.Context(), args)
+		}
+
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+
+		head, err := git.GetCurrentCommit(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		var manifest *ignoregrets.Manifest
+		bar := newProgressBar()
+		snap, err := store.Create(cmd.Context(), ignoregrets.CreateOptions{
+			Reason:   ignoregrets.ReasonPreClean,
+			Progress: bar.Update,
+			OnSecret: func(path string, f ignoregrets.Finding) {
+				bar.Done()
+				reportSecret(path, f)
+			},
+		})
+		bar.Done()
+		if snap != nil {
+			manifest = snap.Manifest
+		}
+		switch {
+		case errors.Is(err, ignoregrets.ErrNoFiles):
+			fmt.Println("No ignored files to snapshot")
+		case errors.Is(err, ignoregrets.ErrUnchanged):
+			fmt.Printf("Files already saved in snapshot [%d] of commit %s\n", manifest.Index, manifest.CommitHash)
+		case err != nil:
+			return fmt.Errorf("pre-clean snapshot failed, not running git clean: %w", err)
+		default:
+			fmt.Printf("Created pre-clean snapshot for commit %s (%d files)\n",
+				manifest.CommitHash, len(manifest.Files))
+		}
+
+		if err := git.Clean(cmd.Context(), protectStore(args)); err != nil {
+			return err
+		}
+
+		switch {
+		case manifest == nil:
+		case manifest.CommitHash == head:
+			fmt.Println("Run 'ignoregrets restore --force' to restore cleaned files")
+		default:
+			fmt.Printf("Run 'ignoregrets restore --force --commit %s' to restore cleaned files\n", manifest.CommitHash)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cleanCmd)
+}
+
+// parseCleanArgs applies the ignoregrets flags in args, which cobra leaves
+// unparsed for clean, and returns the rest for git clean
+func parseCleanArgs(cmd *cobra.Command, args []string) ([]string, error) {
+	own, rest, err := splitCleanArgs(cmd, args)
+	if err != nil || len(own) == 0 {
+		return rest, err
+	}
+	if err := cmd.InheritedFlags().Parse(own); err != nil {
+		return nil, err
+	}
+	// The overrides were checked before the flags were parsed
+	return rest, config.CheckOverrides(overrides)
+}
+
+// splitCleanArgs separates the ignoregrets flags inherited by cmd, given
+// in their long form before any '--', from the git clean arguments
+func splitCleanArgs(cmd *cobra.Command, args []string) (own, rest []string, err error) {
+	flags := cmd.InheritedFlags()
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		name, _, hasValue := strings.Cut(arg, "=")
+		flag := flags.Lookup(strings.TrimPrefix(name, "--"))
+		if !strings.HasPrefix(name, "--") || flag == nil {
+			rest = append(rest, arg)
+			continue
+		}
+		if hasValue || flag.NoOptDefVal != "" {
+			own = append(own, arg)
+			continue
+		}
+		if i+1 == len(args) {
+			return nil, nil, fmt.Errorf("flag needs an argument: %s", arg)
+		}
+		own = append(own, arg, args[i+1])
+		i++
+	}
+	return own, rest, nil
+}
+
+// hasCleanOption reports whether git clean args contain the short option
+// (possibly combined, as in -fdx) or the long option
+func hasCleanOption(args []string, short rune, long string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if long != "" && arg == long {
+			return true
+		}
+		if len(arg) > 1 && arg[0] == '-' && arg[1] != '-' {
+			for _, c := range arg[1:] {
+				if c == short {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// isCleanDryRun reports whether git clean args request a dry run
+func isCleanDryRun(args []string) bool {
+	return hasCleanOption(args, 'n', "--dry-run")
+}
+
+// protectStore adds an exclude rule so git clean never deletes the
+// .ignoregrets store, including the snapshot just taken. With -X only
+// ignored files are removed, so the store must be un-ignored instead.
+func protectStore(args []string) []string {
+	pattern := ".ignoregrets"
+	if hasCleanOption(args, 'X', "") {
+		pattern = "!.ignoregrets"
+	}
+	return append([]string{"-e", pattern}, args...)
+}
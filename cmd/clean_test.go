@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProtectStore(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"-fdx"}, []string{"-e", ".ignoregrets", "-fdx"}},
+		{[]string{"-f", "-x"}, []string{"-e", ".ignoregrets", "-f", "-x"}},
+		// -X removes only ignored files, so the store is un-ignored
+		{[]string{"-fX"}, []string{"-e", "!.ignoregrets", "-fX"}},
+		{[]string{"-f", "-d", "-X"}, []string{"-e", "!.ignoregrets", "-f", "-d", "-X"}},
+		{[]string{"-nX"}, []string{"-e", "!.ignoregrets", "-nX"}},
+		// After -- come paths, not options
+		{[]string{"-f", "--", "-X"}, []string{"-e", ".ignoregrets", "-f", "--", "-X"}},
+		{[]string{"--force", "--exclude=*.log"}, []string{"-e", ".ignoregrets", "--force", "--exclude=*.log"}},
+	}
+	for _, tt := range tests {
+		if got := protectStore(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("protectStore(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestIsCleanDryRun(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"-n"}, true},
+		{[]string{"-fdxn"}, true},
+		{[]string{"--dry-run"}, true},
+		{[]string{"-fdx"}, false},
+		{[]string{"-f", "--", "-n"}, false},
+		{[]string{"--no-dry-run"}, false},
+	}
+	for _, tt := range tests {
+		if got := isCleanDryRun(tt.args); got != tt.want {
+			t.Errorf("isCleanDryRun(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestSplitCleanArgs(t *testing.T) {
+	tests := []struct {
+		args []string
+		own  []string
+		rest []string
+	}{
+		{[]string{"-fdx"}, nil, []string{"-fdx"}},
+		{[]string{"--profile", "build", "-fdx"}, []string{"--profile", "build"}, []string{"-fdx"}},
+		{[]string{"-fdx", "--wait=5s", "--set", "retention=3"}, []string{"--wait=5s", "--set", "retention=3"}, []string{"-fdx"}},
+		// Everything after -- belongs to git clean
+		{[]string{"-f", "--", "--profile", "x"}, nil, []string{"-f", "--", "--profile", "x"}},
+		// git clean's own options are left alone
+		{[]string{"-e", "*.log", "--exclude=tmp", "-fX"}, nil, []string{"-e", "*.log", "--exclude=tmp", "-fX"}},
+	}
+	for _, tt := range tests {
+		own, rest, err := splitCleanArgs(cleanCmd, tt.args)
+		if err != nil {
+			t.Errorf("splitCleanArgs(%q) failed: %v", tt.args, err)
+			continue
+		}
+		if !reflect.DeepEqual(own, tt.own) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("splitCleanArgs(%q) = %q, %q, want %q, %q", tt.args, own, rest, tt.own, tt.rest)
+		}
+	}
+
+	if _, _, err := splitCleanArgs(cleanCmd, []string{"-fdx", "--profile"}); err == nil {
+		t.Error("Expected an error for --profile without a value")
+	}
+}
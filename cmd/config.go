@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+var (
+	showOrigin bool
+	setGlobal  bool
+	setProject bool
+	migrateDry bool
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Get and set configuration values",
+	Long: `Get and set configuration values.
+
+Settings are merged from these layers, each overriding the ones before:
+  1. built-in defaults
+  2. the global config, $XDG_CONFIG_HOME/ignoregrets/config.yaml
+  3. .ignoregrets.yaml at the repository root, committed with the project
+  4. .ignoregrets/config.yaml, for this clone only
+  5. IGNOREGRETS_* environment variables, e.g. IGNOREGRETS_RETENTION or
+     IGNOREGRETS_REMOTE_URL
+  6. --set key=value on the command line
+
+A layer's value replaces the one below it, except for exclude and include,
+whose patterns are added to those of the layers below.
+
+Keys of nested settings are joined with dots, e.g. remote.url. Values are
+YAML; lists can also be given separated by commas.`,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a configuration key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, origins, err := config.Load(overrides...)
+		if err != nil {
+			return err
+		}
+		value, err := config.Get(cfg, args[0])
+		if err != nil {
+			return err
+		}
+		if showOrigin {
+			fmt.Printf("%s\t", origins[args[0]])
+		}
+		fmt.Println(value)
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Set a configuration key in a config file",
+	Long: `Set a configuration key in .ignoregrets/config.yaml, or with --project in
+.ignoregrets.yaml, or with --global in the global config. Comments and
+other settings in the file are kept. The file is not changed if the
+resulting configuration is invalid.`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.LocalPath
+		switch {
+		case setGlobal:
+			var err error
+			if path, err = config.GlobalPath(); err != nil {
+				return err
+			}
+		case setProject:
+			path = config.ProjectPath
+		}
+		return config.Set(path, args[0], args[1])
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "Print every configuration key and its value",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, origins, err := config.Load(overrides...)
+		if err != nil {
+			return err
+		}
+		for _, key := range config.Keys() {
+			value, err := config.Get(cfg, key)
+			if err != nil {
+				return err
+			}
+			if showOrigin {
+				fmt.Printf("%s\t", origins[key])
+			}
+			fmt.Printf("%s=%s\n", key, value)
+		}
+		return nil
+	},
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [file...]",
+	Short: "Check the configuration for errors",
+	Long: `Check the merged configuration for unknown keys, invalid values and bad
+patterns, reporting each with the file, line and column it comes from.
+With file arguments, check each file on its own instead, e.g. a
+.ignoregrets.yaml in CI.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			cfg, origins, err := config.Load(overrides...)
+			if err != nil {
+				return err
+			}
+			if err := config.Validate(cfg, origins); err != nil {
+				return err
+			}
+			fmt.Println("Configuration is valid")
+			return nil
+		}
+
+		invalid := 0
+		for _, path := range args {
+			if _, err := os.Stat(path); err != nil {
+				return err
+			}
+			if err := config.ValidateFile(path); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				invalid++
+				continue
+			}
+			fmt.Printf("%s is valid\n", path)
+		}
+		if invalid > 0 {
+			return fmt.Errorf("%d of %d config file(s) are invalid", invalid, len(args))
+		}
+		return nil
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the config files",
+	Long: `Print the JSON Schema of the config files, for editors that complete and
+check YAML against a schema. The same schema is in docs/config.schema.json.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schema, err := config.Schema()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(schema)
+		return err
+	},
+}
+
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Upgrade config files to the current format",
+	Long: fmt.Sprintf(`Upgrade the global, project and local config files to version %d of the
+config format, keeping their comments. Older files are also read without
+migrating them, but a file of a newer version than this ignoregrets
+supports is rejected.`, config.Version),
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		paths, err := config.Paths()
+		if err != nil {
+			return err
+		}
+		migrated := 0
+		for _, path := range paths {
+			from, err := config.Migrate(path, migrateDry)
+			if err != nil {
+				return err
+			}
+			if from == config.Version {
+				continue
+			}
+			migrated++
+			if migrateDry {
+				fmt.Printf("Would migrate %s from version %d to %d\n", path, from, config.Version)
+			} else {
+				fmt.Printf("Migrated %s from version %d to %d\n", path, from, config.Version)
+			}
+		}
+		if migrated == 0 {
+			fmt.Println("Config files are up to date")
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configGetCmd, configSetCmd, configListCmd, configValidateCmd, configSchemaCmd, configMigrateCmd)
+	configGetCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "Show the layer and file each value came from")
+	configListCmd.Flags().BoolVar(&showOrigin, "show-origin", false, "Show the layer and file each value came from")
+	configSetCmd.Flags().BoolVar(&setGlobal, "global", false, "Set the key in the global config")
+	configSetCmd.Flags().BoolVar(&setProject, "project", false, "Set the key in .ignoregrets.yaml")
+	configSetCmd.MarkFlagsMutuallyExclusive("global", "project")
+	configMigrateCmd.Flags().BoolVar(&migrateDry, "dry-run", false, "Show which files would be migrated without changing them")
+}
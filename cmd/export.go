@@ -0,0 +1,72 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var (
+	exportOutput string
+	signKey      string
+	allowSecrets bool
+)
+
+var exportCmd = &cobra.Command{
+	Use:   "export [<commit>[:<index>]] -o <bundle>",
+	Short: "Write a snapshot to a bundle file",
+	Long: `Write a snapshot to a bundle file that 'ignoregrets import' adds to the
+store of another clone, e.g. to hand a teammate the ignored files of a
+commit. By default, exports the latest snapshot for the current commit.
+
+The bundle holds the manifest and a full snapshot archive; incremental
+snapshots are flattened. Use --sign-key to sign it with an SSH key.
+
+Unencrypted snapshots with files recorded as secret are only exported
+with --allow-secrets.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ref := ignoregrets.SnapshotRef{Index: ignoregrets.Latest}
+		if len(args) == 1 {
+			var err error
+			if ref, err = ignoregrets.ParseRef(args[0]); err != nil {
+				return err
+			}
+		}
+
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+		snap, err := store.Get(ref)
+		if err != nil {
+			return err
+		}
+
+		err = store.Export(cmd.Context(), snap.Ref, exportOutput, ignoregrets.ExportOptions{
+			SignKey:      signKey,
+			AllowSecrets: allowSecrets,
+		})
+		if errors.Is(err, ignoregrets.ErrSecrets) {
+			return fmt.Errorf("%w; encrypt snapshots or pass --allow-secrets", err)
+		}
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Exported snapshot [%d] for commit %s to %s (%d files)\n",
+			snap.Ref.Index, snap.Ref.Commit, exportOutput, len(snap.Manifest.Files))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.Flags().StringVarP(&exportOutput, "output", "o", "", "Bundle file to write")
+	exportCmd.Flags().StringVar(&signKey, "sign-key", "", "SSH private key to sign the bundle with")
+	exportCmd.Flags().BoolVar(&allowSecrets, "allow-secrets", false, "Export an unencrypted snapshot with files recorded as secret")
+	exportCmd.MarkFlagRequired("output")
+}
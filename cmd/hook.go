@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+// hookLockWait is how long hooks wait for the store lock. Hooks can't be
+// given --wait, and failing a hook because 'status' was running is worse
+// than a short delay.
+const hookLockWait = 30 * time.Second
+
+var hookCmd = &cobra.Command{
+	Use:   "hook <git-hook> [args...]",
+	Short: "Run the ignoregrets actions for a Git hook",
+	Long: `Run the actions configured in snapshot_on and restore_on for a Git hook.
+This is called by the hook scripts installed with 'ignoregrets init --hooks'
+and receives the hook's own arguments and stdin.
+
+Restores triggered by restore_on use conflict_policy from config.yaml to
+decide what happens to existing files that differ from the snapshot.
+
+The actions run for the top-level settings and then for each profile,
+with the profile's own snapshot_on and restore_on.
+
+Errors are reported but never fail the hook, so a missing snapshot cannot
+block a commit, merge or push.`,
+	Hidden:             true,
+	Args:               cobra.MinimumNArgs(1),
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := ignoregrets.Open(overrides...)
+		if err == nil {
+			store.SetLockWait(hookLockWait)
+			store.SetPassphraseFunc(promptPassphrase)
+			err = runHook(cmd.Context(), store, args[0], args[1:], os.Stdin)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ignoregrets: %v\n", err)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hookCmd)
+}
+
+// hookPrompt asks whether a hook restore under the prompt conflict policy
+// may overwrite a file; tests replace it to answer
+var hookPrompt = promptOverwrite
+
+// errUnsupportedHook is returned for hooks ignoregrets has no actions for
+var errUnsupportedHook = errors.New("unsupported hook")
+
+// runHook performs the configured actions for a Git hook, for the
+// top-level settings and each profile
+func runHook(ctx context.Context, store *ignoregrets.Store, hookName string, args []string, stdin io.Reader) error {
+	// Every profile gets the commit mapping post-rewrite reads from stdin
+	var input []byte
+	if hookName == "post-rewrite" {
+		var err error
+		if input, err = io.ReadAll(stdin); err != nil {
+			return fmt.Errorf("failed to read hook input: %w", err)
+		}
+	}
+
+	var errs []error
+	for _, name := range append([]string{""}, store.Profiles()...) {
+		profiled, err := store.WithProfile(name)
+		if err != nil {
+			return err
+		}
+		err = runProfileHook(ctx, profiled, hookName, args, bytes.NewReader(input))
+		if errors.Is(err, errUnsupportedHook) {
+			return err
+		}
+		if err != nil && name != "" {
+			err = fmt.Errorf("profile %s: %w", name, err)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// runProfileHook performs the actions configured for a Git hook in the
+// settings of the store's profile
+func runProfileHook(ctx context.Context, store *ignoregrets.Store, hookName string, args []string, stdin io.Reader) error {
+	cfg := store.Config()
+	var errs []error
+	switch hookName {
+	case "pre-commit":
+		if config.HasEvent(cfg.SnapshotOn, "commit") {
+			errs = append(errs, hookSnapshot(ctx, store))
+		}
+	case "post-checkout":
+		// Arguments are <previous HEAD> <new HEAD> <branch checkout flag>;
+		// file checkouts (flag 0) don't touch ignored files
+		if len(args) < 3 || args[2] == "0" || args[0] == args[1] {
+			return nil
+		}
+		// Ignored files still hold the previous commit's state, so snapshot
+		// them for that commit before restoring the new one
+		if config.HasEvent(cfg.SnapshotOn, "checkout") && !isNullCommit(args[0]) {
+			errs = append(errs, hookSnapshotOf(ctx, store, args[0]))
+		}
+		if config.HasEvent(cfg.RestoreOn, "checkout") {
+			errs = append(errs, autoRestore(ctx, store, args[0]))
+		}
+	case "post-merge":
+		if config.HasEvent(cfg.SnapshotOn, "merge") {
+			errs = append(errs, hookSnapshot(ctx, store))
+		}
+		if config.HasEvent(cfg.RestoreOn, "merge") {
+			previous, _ := git.ResolveCommit(ctx, "ORIG_HEAD")
+			errs = append(errs, autoRestore(ctx, store, previous))
+		}
+	case "post-rewrite":
+		if config.HasEvent(cfg.SnapshotOn, "rewrite") {
+			errs = append(errs, carryRewrittenSnapshots(ctx, store, stdin))
+		}
+		if config.HasEvent(cfg.RestoreOn, "rewrite") {
+			errs = append(errs, autoRestore(ctx, store, ""))
+		}
+	case "pre-rebase":
+		if config.HasEvent(cfg.SnapshotOn, "rebase") {
+			errs = append(errs, hookSnapshot(ctx, store))
+		}
+	case "pre-push":
+		if config.HasEvent(cfg.SnapshotOn, "push") {
+			errs = append(errs, hookSnapshot(ctx, store))
+		}
+	default:
+		return fmt.Errorf("%w: %s", errUnsupportedHook, hookName)
+	}
+	return errors.Join(errs...)
+}
+
+// hookSnapshot creates a snapshot for HEAD from a hook
+func hookSnapshot(ctx context.Context, store *ignoregrets.Store) error {
+	return hookSnapshotOf(ctx, store, "")
+}
+
+// hookSnapshotOf snapshots the working files for commit, unless nothing
+// changed since the previous snapshot
+func hookSnapshotOf(ctx context.Context, store *ignoregrets.Store, commit string) error {
+	_, err := store.Create(ctx, ignoregrets.CreateOptions{Commit: commit, OnSecret: reportSecret})
+	if errors.Is(err, ignoregrets.ErrUnchanged) || errors.Is(err, ignoregrets.ErrNoFiles) {
+		return nil
+	}
+	return err
+}
+
+// isNullCommit reports whether commit is the all-zero hash Git passes when
+// there is no previous commit, as on clone
+func isNullCommit(commit string) bool {
+	return strings.Trim(commit, "0") == ""
+}
+
+// autoRestore restores the latest snapshot of HEAD using the configured
+// conflict policy. previous is the commit the working files belong to, whose
+// latest snapshot is the baseline for the overwrite-unchanged policy.
+func autoRestore(ctx context.Context, store *ignoregrets.Store, previous string) error {
+	commit, err := git.GetCurrentCommit(ctx)
+	if err != nil {
+		return err
+	}
+	if previous == "" {
+		previous = commit
+	}
+
+	err = store.Restore(ctx, ignoregrets.SnapshotRef{Commit: commit, Index: ignoregrets.Latest}, ignoregrets.RestoreOptions{
+		Policy:   store.Config().ConflictPolicy,
+		Baseline: baselineFor(store, previous),
+		Prompt:   hookPrompt,
+	})
+	if errors.Is(err, ignoregrets.ErrNoSnapshots) {
+		return nil
+	}
+	return err
+}
+
+// carryRewrittenSnapshots copies snapshots from rewritten commits to their
+// replacements, reading the old->new mapping Git passes to post-rewrite
+func carryRewrittenSnapshots(ctx context.Context, store *ignoregrets.Store, stdin io.Reader) error {
+	rewrites, err := git.ParseRewrites(stdin)
+	if err != nil {
+		return err
+	}
+
+	// A rebase that squashes commits maps several old commits to one new
+	// commit; carry only the last, which holds the newest state
+	latest := make(map[string]string)
+	var order []string
+	for _, r := range rewrites {
+		if _, ok := latest[r.New]; !ok {
+			order = append(order, r.New)
+		}
+		latest[r.New] = r.Old
+	}
+
+	var errs []error
+	for _, newCommit := range order {
+		if err := store.Carry(ctx, latest[newCommit], newCommit); err != nil {
+			errs = append(errs, fmt.Errorf("failed to carry snapshot to %s: %w", newCommit, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+// setupHookRepo creates a Git repository with one commit in a temporary
+// directory, changes into it and returns the commit
+func setupHookRepo(t *testing.T) string {
+	t.Helper()
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(oldDir) })
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	runGit(t, "init", "-q")
+	runGit(t, "config", "user.name", "Test User")
+	runGit(t, "config", "user.email", "test@example.com")
+	if err := os.MkdirAll(filepath.Join(".ignoregrets", "snapshots"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return commitEmpty(t, "initial")
+}
+
+// runGit runs git with args and returns its trimmed output
+func runGit(t *testing.T, args ...string) string {
+	t.Helper()
+	out, err := exec.Command("git", args...).Output()
+	if err != nil {
+		t.Fatalf("git %v failed: %v", args, err)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commitEmpty makes an empty commit and returns its hash
+func commitEmpty(t *testing.T, message string) string {
+	t.Helper()
+	runGit(t, "commit", "-q", "--allow-empty", "-m", message)
+	return runGit(t, "rev-parse", "HEAD")
+}
+
+// openHookStore opens the store with settings applied as overrides
+func openHookStore(t *testing.T, settings ...string) *ignoregrets.Store {
+	t.Helper()
+	store, err := ignoregrets.Open(settings...)
+	if err != nil {
+		t.Fatalf("Failed to open store: %v", err)
+	}
+	return store
+}
+
+// writeEnv writes content to the .env working file
+func writeEnv(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(".env", []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// snapshotEnv snapshots .env with content for commit
+func snapshotEnv(t *testing.T, store *ignoregrets.Store, commit, content string) *ignoregrets.Snapshot {
+	t.Helper()
+	writeEnv(t, content)
+	s, err := store.Create(context.Background(), ignoregrets.CreateOptions{Commit: commit})
+	if err != nil {
+		t.Fatalf("Failed to snapshot %s: %v", commit, err)
+	}
+	return s
+}
+
+// countSnapshots returns the number of snapshots of commit
+func countSnapshots(t *testing.T, store *ignoregrets.Store, commit string) int {
+	t.Helper()
+	snapshots, err := store.List(commit)
+	if err != nil {
+		t.Fatalf("Failed to list snapshots of %s: %v", commit, err)
+	}
+	return len(snapshots)
+}
+
+func TestRunHookDispatch(t *testing.T) {
+	tests := []struct {
+		hook, event string
+	}{
+		{"pre-commit", "commit"},
+		{"post-merge", "merge"},
+		{"pre-rebase", "rebase"},
+		{"pre-push", "push"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.hook, func(t *testing.T) {
+			head := setupHookRepo(t)
+			writeEnv(t, "A=1")
+
+			// Hooks for events not in snapshot_on do nothing
+			store := openHookStore(t, "snapshot_on=checkout", "restore_on=")
+			if err := runHook(context.Background(), store, tt.hook, nil, strings.NewReader("")); err != nil {
+				t.Fatalf("Hook %s failed: %v", tt.hook, err)
+			}
+			if n := countSnapshots(t, store, head); n != 0 {
+				t.Errorf("Expected no snapshot without %s in snapshot_on, got %d", tt.event, n)
+			}
+
+			store = openHookStore(t, "snapshot_on="+tt.event, "restore_on=")
+			if err := runHook(context.Background(), store, tt.hook, nil, strings.NewReader("")); err != nil {
+				t.Fatalf("Hook %s failed: %v", tt.hook, err)
+			}
+			if n := countSnapshots(t, store, head); n != 1 {
+				t.Errorf("Expected a snapshot of HEAD, got %d", n)
+			}
+
+			// Running the hook again with nothing changed adds nothing
+			if err := runHook(context.Background(), store, tt.hook, nil, strings.NewReader("")); err != nil {
+				t.Fatalf("Hook %s failed: %v", tt.hook, err)
+			}
+			if n := countSnapshots(t, store, head); n != 1 {
+				t.Errorf("Expected the unchanged snapshot to be skipped, got %d", n)
+			}
+		})
+	}
+}
+
+func TestRunHookUnsupported(t *testing.T) {
+	setupHookRepo(t)
+	store := openHookStore(t)
+	err := runHook(context.Background(), store, "post-commit", nil, strings.NewReader(""))
+	if !errors.Is(err, errUnsupportedHook) {
+		t.Errorf("Expected errUnsupportedHook, got %v", err)
+	}
+}
+
+func TestRunHookPostRewrite(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string // amend or rebase, as Git passes it
+		// rewrites maps old commits (by letter) to new ones (by number)
+		rewrites [][2]string
+		// want maps each new commit to the old commit its snapshot must
+		// come from
+		want map[string]string
+	}{
+		{"amend", "amend", [][2]string{{"a", "1"}}, map[string]string{"1": "a"}},
+		{"rebase", "rebase", [][2]string{{"a", "1"}, {"b", "2"}}, map[string]string{"1": "a", "2": "b"}},
+		// A squash maps both old commits to one new commit, which gets the
+		// snapshot of the last of them
+		{"squash", "rebase", [][2]string{{"a", "1"}, {"b", "1"}}, map[string]string{"1": "b"}},
+		// Commits without snapshots are skipped
+		{"unsnapshotted", "rebase", [][2]string{{"c", "1"}, {"b", "2"}}, map[string]string{"2": "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupHookRepo(t)
+			store := openHookStore(t, "snapshot_on=rewrite", "restore_on=")
+
+			commits := map[string]string{
+				"a": commitEmpty(t, "a"),
+				"b": commitEmpty(t, "b"),
+				"c": commitEmpty(t, "c"),
+				"1": commitEmpty(t, "1"),
+				"2": commitEmpty(t, "2"),
+			}
+			originals := map[string]*ignoregrets.Snapshot{
+				"a": snapshotEnv(t, store, commits["a"], "A=a"),
+				"b": snapshotEnv(t, store, commits["b"], "A=b"),
+			}
+
+			var input strings.Builder
+			for _, r := range tt.rewrites {
+				input.WriteString(commits[r[0]] + " " + commits[r[1]] + "\n")
+			}
+			err := runHook(context.Background(), store, "post-rewrite", []string{tt.arg}, strings.NewReader(input.String()))
+			if err != nil {
+				t.Fatalf("Hook post-rewrite failed: %v", err)
+			}
+
+			for _, name := range []string{"1", "2"} {
+				snapshots, err := store.List(commits[name])
+				if err != nil {
+					t.Fatalf("Failed to list snapshots: %v", err)
+				}
+				from, ok := tt.want[name]
+				if !ok {
+					if len(snapshots) != 0 {
+						t.Errorf("Expected no snapshot carried to %s, got %d", name, len(snapshots))
+					}
+					continue
+				}
+				if len(snapshots) != 1 {
+					t.Fatalf("Expected one snapshot carried to %s, got %d", name, len(snapshots))
+				}
+				got := snapshots[0].Manifest
+				if got.RewrittenFrom != commits[from] || got.Files[".env"] != originals[from].Manifest.Files[".env"] {
+					t.Errorf("Expected %s to carry the snapshot of %s, got one rewritten from %s", name, from, got.RewrittenFrom)
+				}
+			}
+		})
+	}
+}
+
+func TestRunHookPostRewriteProfiles(t *testing.T) {
+	setupHookRepo(t)
+	settings := "snapshot_on: [rewrite]\nrestore_on: []\nprofiles:\n  build:\n    snapshot_on: [rewrite]\n"
+	if err := os.WriteFile(filepath.Join(".ignoregrets", "config.yaml"), []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	store := openHookStore(t)
+	build, err := store.WithProfile("build")
+	if err != nil {
+		t.Fatalf("Failed to select profile: %v", err)
+	}
+
+	old := commitEmpty(t, "old")
+	rewritten := commitEmpty(t, "rewritten")
+	snapshotEnv(t, store, old, "A=1")
+	snapshotEnv(t, build, old, "A=2")
+
+	// Both profiles read the one mapping Git passes on stdin
+	input := strings.NewReader(old + " " + rewritten + "\n")
+	if err := runHook(context.Background(), store, "post-rewrite", []string{"amend"}, input); err != nil {
+		t.Fatalf("Hook post-rewrite failed: %v", err)
+	}
+	snapshots, err := store.List(rewritten)
+	if err != nil {
+		t.Fatalf("Failed to list snapshots: %v", err)
+	}
+	profiles := map[string]bool{}
+	for _, s := range snapshots {
+		profiles[s.Manifest.Profile] = true
+	}
+	if len(snapshots) != 2 || !profiles[""] || !profiles["build"] {
+		t.Errorf("Expected the snapshots of both profiles to be carried, got %d: %v", len(snapshots), profiles)
+	}
+}
+
+// readEnv returns the content of the .env working file, or "" if missing
+func readEnv(t *testing.T) string {
+	t.Helper()
+	data, err := os.ReadFile(".env")
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestAutoRestoreNullPrevious(t *testing.T) {
+	head := setupHookRepo(t)
+	store := openHookStore(t, "snapshot_on=checkout", "restore_on=checkout")
+	snapshotEnv(t, store, head, "A=1")
+	if err := os.Remove(".env"); err != nil {
+		t.Fatal(err)
+	}
+
+	// On clone the previous HEAD is the null commit: nothing is snapshotted
+	// for it, and HEAD's snapshot is restored
+	null := strings.Repeat("0", len(head))
+	if err := runHook(context.Background(), store, "post-checkout", []string{null, head, "1"}, strings.NewReader("")); err != nil {
+		t.Fatalf("Hook post-checkout failed: %v", err)
+	}
+	if got := readEnv(t); got != "A=1" {
+		t.Errorf("Expected .env to be restored, got %q", got)
+	}
+	if n := countSnapshots(t, store, head); n != 1 {
+		t.Errorf("Expected no new snapshot, got %d", n)
+	}
+}
+
+func TestAutoRestoreFileCheckout(t *testing.T) {
+	previous := setupHookRepo(t)
+	head := commitEmpty(t, "head")
+	store := openHookStore(t, "snapshot_on=checkout", "restore_on=checkout")
+	snapshotEnv(t, store, head, "A=1")
+	if err := os.Remove(".env"); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file checkout (flag 0) neither snapshots nor restores
+	if err := runHook(context.Background(), store, "post-checkout", []string{previous, head, "0"}, strings.NewReader("")); err != nil {
+		t.Fatalf("Hook post-checkout failed: %v", err)
+	}
+	if got := readEnv(t); got != "" {
+		t.Errorf("Expected .env to stay missing, got %q", got)
+	}
+	if n := countSnapshots(t, store, previous); n != 0 {
+		t.Errorf("Expected no snapshot of the previous commit, got %d", n)
+	}
+}
+
+func TestAutoRestoreConflictPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  string
+		working string
+		answer  bool // for the prompt policy
+		want    string
+	}{
+		{"skip", "skip", "A=local", false, "A=local"},
+		{"overwrite", "overwrite", "A=local", false, "A=head"},
+		// The working file matches the previous commit's snapshot
+		{"overwrite-unchanged", "overwrite-unchanged", "A=previous", false, "A=head"},
+		{"overwrite-unchanged-changed", "overwrite-unchanged", "A=local", false, "A=local"},
+		{"prompt-yes", "prompt", "A=local", true, "A=head"},
+		{"prompt-no", "prompt", "A=local", false, "A=local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := setupHookRepo(t)
+			head := commitEmpty(t, "head")
+			store := openHookStore(t, "snapshot_on=", "restore_on=checkout", "conflict_policy="+tt.policy)
+			snapshotEnv(t, store, previous, "A=previous")
+			snapshotEnv(t, store, head, "A=head")
+			writeEnv(t, tt.working)
+
+			var asked []string
+			defer func(prompt func(string) bool) { hookPrompt = prompt }(hookPrompt)
+			hookPrompt = func(path string) bool {
+				asked = append(asked, path)
+				return tt.answer
+			}
+
+			if err := runHook(context.Background(), store, "post-checkout", []string{previous, head, "1"}, strings.NewReader("")); err != nil {
+				t.Fatalf("Hook post-checkout failed: %v", err)
+			}
+			if got := readEnv(t); got != tt.want {
+				t.Errorf("Expected .env to be %q, got %q", tt.want, got)
+			}
+			if tt.policy == "prompt" && (len(asked) != 1 || asked[0] != ".env") {
+				t.Errorf("Expected to be asked about .env, got %v", asked)
+			}
+			if tt.policy != "prompt" && len(asked) != 0 {
+				t.Errorf("Expected no prompt, got %v", asked)
+			}
+		})
+	}
+}
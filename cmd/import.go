@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var trustedKeys string
+
+var importCmd = &cobra.Command{
+	Use:   "import <bundle>",
+	Short: "Add a snapshot from a bundle file",
+	Long: `Add the snapshot in a bundle written by 'ignoregrets export' to the store.
+
+Every file is checked against the manifest checksums before the snapshot
+is added. If its index is already taken for the commit, it gets the next
+free index. Importing a bundle twice does nothing.
+
+Use --trusted-keys with an authorized_keys style file to require the
+bundle to be signed by one of its keys.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+
+		result, err := store.Import(cmd.Context(), args[0], ignoregrets.ImportOptions{
+			TrustedKeys: trustedKeys,
+		})
+		if errors.Is(err, ignoregrets.ErrAlreadyImported) {
+			fmt.Printf("Snapshot [%d] for commit %s is already in the store\n",
+				result.Manifest.Index, result.Manifest.CommitHash)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		manifest := result.Manifest
+		fmt.Printf("Imported snapshot [%d] for commit %s (%d files)\n",
+			manifest.Index, manifest.CommitHash, len(manifest.Files))
+		if manifest.Index != result.BundleIndex {
+			fmt.Printf("Index [%d] was taken, so the snapshot was renumbered\n", result.BundleIndex)
+		}
+		switch {
+		case result.Trusted:
+			fmt.Printf("Signed by trusted key %s\n", result.Signer)
+		case result.Signer != "":
+			fmt.Printf("Signed by %s (use --trusted-keys to check the signer)\n", result.Signer)
+		}
+		if secret := manifest.SecretFiles(); len(secret) > 0 {
+			fmt.Printf("%d file(s) contain secrets; run 'ignoregrets inspect --secrets' for details\n", len(secret))
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.Flags().StringVar(&trustedKeys, "trusted-keys", "", "authorized_keys style file of keys the bundle must be signed by")
+}
@@ -2,11 +2,13 @@ package cmd
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/spf13/cobra"
 
 	"github.com/Cod-e-Codes/ignoregrets/internal/config"
 	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
 )
 
 var setupHooks bool
@@ -18,52 +20,51 @@ var initCmd = &cobra.Command{
 Creates .ignoregrets directory and config.yaml if they don't exist.
 
 Use --hooks to set up Git hooks for automatic snapshots and restores.
-Hooks can also be enabled later via config.yaml.`,
+Hooks can also be enabled later via config.yaml. The hooks installed follow
+the snapshot_on and restore_on events, including those of profiles; rerun
+init after changing them.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Load or create config
-		cfg, err := config.LoadConfig()
+		// Create the local config, then load the merged one
+		if err := config.CreateLocalConfig(); err != nil {
+			return err
+		}
+		cfg, origins, err := config.Load(overrides...)
 		if err != nil {
 			return err
 		}
 
+		if err := config.Validate(cfg, origins); err != nil {
+			return err
+		}
+
 		// Update hooks setting if flag is provided
 		if setupHooks {
 			cfg.HooksEnabled = true
-			if err := config.SaveConfig(cfg); err != nil {
+			if err := config.Set(config.LocalPath, "hooks_enabled", "true"); err != nil {
 				return err
 			}
 		}
 
 		// Install hooks if enabled
 		if cfg.HooksEnabled {
-			// Pre-commit hook for snapshots
-			preCommitHook := `#!/bin/sh
-# Created by ignoregrets
-if command -v ignoregrets >/dev/null 2>&1; then
-  ignoregrets snapshot
-fi`
-			if err := git.InstallHook("pre-commit", preCommitHook); err != nil {
-				return fmt.Errorf("failed to install pre-commit hook: %w", err)
+			manager, err := git.DetectHookManager()
+			if err != nil {
+				return err
 			}
-
-			// Post-checkout hook for restores
-			postCheckoutHook := `#!/bin/sh
-# Created by ignoregrets
-if command -v ignoregrets >/dev/null 2>&1; then
-  ignoregrets restore --dry-run
-  if [ $? -eq 0 ]; then
-    echo "Run 'ignoregrets restore --force' to restore files"
-  fi
-fi`
-			if err := git.InstallHook("post-checkout", postCheckoutHook); err != nil {
-				return fmt.Errorf("failed to install post-checkout hook: %w", err)
+			if manager != "" {
+				printHookManagerInstructions(manager, hooksFor(cfg))
+			} else if err := installHooks(cfg); err != nil {
+				return err
 			}
-
-			fmt.Println("Git hooks installed successfully")
 		}
 
 		fmt.Println("Initialized ignoregrets successfully")
 		fmt.Printf("Config file: %s\n", ".ignoregrets/config.yaml")
+		if store, err := ignoregrets.New(cfg); err != nil {
+			return err
+		} else if dir := store.Dir(); dir != ".ignoregrets" {
+			fmt.Printf("Snapshot store: %s\n", dir)
+		}
 		if !cfg.HooksEnabled {
 			fmt.Println("\nTip: Run 'ignoregrets init --hooks' to set up Git hooks")
 		}
@@ -72,6 +73,122 @@ fi`
 	},
 }
 
+// gitHooks lists the Git hooks ignoregrets can install and the
+// snapshot_on/restore_on events that need each of them
+var gitHooks = []struct {
+	name       string
+	snapshotOn string
+	restoreOn  string
+	stdin      bool // hook receives data on stdin
+}{
+	{name: "pre-commit", snapshotOn: "commit"},
+	{name: "post-checkout", snapshotOn: "checkout", restoreOn: "checkout"},
+	{name: "post-merge", snapshotOn: "merge", restoreOn: "merge"},
+	{name: "post-rewrite", snapshotOn: "rewrite", restoreOn: "rewrite", stdin: true},
+	{name: "pre-rebase", snapshotOn: "rebase"},
+	{name: "pre-push", snapshotOn: "push"},
+}
+
+// hooksFor returns the names of the Git hooks needed by the configured
+// events, at the top level or in any profile
+func hooksFor(cfg *config.Config) []string {
+	settings := []*config.Config{cfg}
+	for _, name := range cfg.ProfileNames() {
+		if profiled, err := cfg.Profile(name); err == nil {
+			settings = append(settings, profiled)
+		}
+	}
+
+	var hooks []string
+	for _, h := range gitHooks {
+		for _, c := range settings {
+			if (h.snapshotOn != "" && config.HasEvent(c.SnapshotOn, h.snapshotOn)) ||
+				(h.restoreOn != "" && config.HasEvent(c.RestoreOn, h.restoreOn)) {
+				hooks = append(hooks, h.name)
+				break
+			}
+		}
+	}
+	return hooks
+}
+
+// hookScript returns the hook block that hands a Git hook to 'ignoregrets hook'.
+// Hooks that receive stdin save it to a file and replay it, so hook code
+// after the block still sees it.
+func hookScript(name string, stdin bool) string {
+	if stdin {
+		return fmt.Sprintf(`# Created by ignoregrets
+if command -v ignoregrets >/dev/null 2>&1; then
+  ignoregrets_input=$(mktemp)
+  cat > "$ignoregrets_input"
+  ignoregrets hook %s "$@" < "$ignoregrets_input"
+  exec < "$ignoregrets_input"
+  rm -f "$ignoregrets_input"
+fi`, name)
+	}
+	return fmt.Sprintf(`# Created by ignoregrets
+if command -v ignoregrets >/dev/null 2>&1; then
+  ignoregrets hook %s "$@"
+fi`, name)
+}
+
+// installHooks installs the hooks needed by the configured events and
+// removes the ignoregrets block from the ones no longer needed
+func installHooks(cfg *config.Config) error {
+	needed := make(map[string]bool)
+	for _, name := range hooksFor(cfg) {
+		needed[name] = true
+	}
+
+	for _, h := range gitHooks {
+		if !needed[h.name] {
+			if err := git.UninstallHook(h.name); err != nil {
+				return fmt.Errorf("failed to uninstall %s hook: %w", h.name, err)
+			}
+			continue
+		}
+		if err := git.InstallHook(h.name, hookScript(h.name, h.stdin)); err != nil {
+			return fmt.Errorf("failed to install %s hook: %w", h.name, err)
+		}
+	}
+	fmt.Println("Git hooks installed successfully")
+	return nil
+}
+
+// printHookManagerInstructions explains how to wire ignoregrets into a hook
+// manager instead of writing hook files it would overwrite
+func printHookManagerInstructions(manager string, hooks []string) {
+	fmt.Printf("Detected %s managing Git hooks; not installing hooks directly.\n", manager)
+	switch manager {
+	case "husky":
+		fmt.Println("Add these lines to the matching files in .husky/:")
+		for _, name := range hooks {
+			fmt.Printf("  .husky/%s:\n    ignoregrets hook %s \"$@\"\n", name, name)
+		}
+	case "lefthook":
+		fmt.Println("Add these commands to lefthook.yml:")
+		for _, name := range hooks {
+			fmt.Printf("  %s:\n    commands:\n      ignoregrets:\n        run: ignoregrets hook %s {0}\n", name, name)
+		}
+	case "pre-commit":
+		fmt.Println("Add this local hook to .pre-commit-config.yaml:")
+		fmt.Println("  - repo: local")
+		fmt.Println("    hooks:")
+		var hookTypes []string
+		for _, name := range hooks {
+			fmt.Printf("      - id: ignoregrets-%s\n", name)
+			fmt.Printf("        name: ignoregrets %s\n", name)
+			fmt.Printf("        entry: ignoregrets hook %s\n", name)
+			fmt.Println("        language: system")
+			fmt.Println("        always_run: true")
+			fmt.Println("        pass_filenames: false")
+			fmt.Printf("        stages: [%s]\n", name)
+			hookTypes = append(hookTypes, "--hook-type "+name)
+		}
+		fmt.Printf("Then run: pre-commit install %s\n", strings.Join(hookTypes, " "))
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(initCmd)
 	initCmd.Flags().BoolVar(&setupHooks, "hooks", false, "Set up Git hooks for automatic snapshots and restores")
@@ -2,16 +2,15 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
 	"sort"
 
 	"github.com/spf13/cobra"
 
-	"github.com/Cod-e-Codes/ignoregrets/internal/git"
-	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
 )
 
+var showSecrets bool
+
 var inspectCmd = &cobra.Command{
 	Use:   "inspect",
 	Short: "Show details of a snapshot",
@@ -19,48 +18,21 @@ var inspectCmd = &cobra.Command{
 By default, shows the latest snapshot for the current commit.
 
 Use --commit to specify a different commit hash and --snapshot
-to select a specific snapshot index.`,
+to select a specific snapshot index. Use --secrets to list what the
+secret scanner found, by file and line; the secrets themselves are
+never printed.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Get commit hash if not specified
-		if commitHash == "" {
-			var err error
-			commitHash, err = git.GetCurrentCommit()
-			if err != nil {
-				return err
-			}
-		}
-
-		// Find snapshot file
-		dir := filepath.Join(".ignoregrets", "snapshots")
-		pattern := fmt.Sprintf("%s_*.tar.gz", commitHash)
-		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		store, err := openStore()
 		if err != nil {
-			return fmt.Errorf("failed to list snapshots: %w", err)
+			return err
 		}
 
-		if len(matches) == 0 {
-			return fmt.Errorf("no snapshots found for commit %s", commitHash)
-		}
-
-		// Sort by timestamp (newest first)
-		sort.Sort(sort.Reverse(sort.StringSlice(matches)))
-
-		// Select snapshot by index
-		if snapIndex >= len(matches) {
-			return fmt.Errorf("snapshot index %d not found for commit %s", snapIndex, commitHash)
-		}
-
-		// Read and display manifest
-		file, err := os.Open(matches[snapIndex])
-		if err != nil {
-			return fmt.Errorf("failed to open snapshot: %w", err)
-		}
-		defer file.Close()
-
-		manifest, err := snapshot.ReadManifest(file)
+		// Find snapshot and read its manifest
+		snap, err := store.Get(ignoregrets.SnapshotRef{Commit: commitHash, Index: snapIndex})
 		if err != nil {
-			return fmt.Errorf("failed to read manifest: %w", err)
+			return err
 		}
+		manifest := snap.Manifest
 
 		// Display snapshot information
 		fmt.Printf("Snapshot details:\n")
@@ -68,6 +40,33 @@ to select a specific snapshot index.`,
 		fmt.Printf("Commit:    %s\n", manifest.CommitHash)
 		fmt.Printf("Timestamp: %s\n", manifest.Timestamp.Format("2006-01-02 15:04:05"))
 		fmt.Printf("Index:     %d\n", manifest.Index)
+		if manifest.Compression != "" {
+			fmt.Printf("Codec:     %s\n", manifest.Compression)
+		}
+		if manifest.Encryption != "" {
+			fmt.Printf("Encrypted: %s\n", manifest.Encryption)
+		}
+		if manifest.Branch != "" {
+			fmt.Printf("Branch:    %s\n", manifest.Branch)
+		}
+		if manifest.Message != "" {
+			fmt.Printf("Message:   %s\n", manifest.Message)
+		}
+		if manifest.Profile != "" {
+			fmt.Printf("Profile:   %s\n", manifest.Profile)
+		}
+		if manifest.Reason != "" {
+			fmt.Printf("Reason:    %s\n", manifest.Reason)
+		}
+		if manifest.DerivedFrom != nil {
+			fmt.Printf("Derived:   from %s\n", manifest.DerivedFrom)
+		}
+		if manifest.Parent != nil {
+			fmt.Printf("Parent:    %s (%d files inherited)\n", manifest.Parent, len(manifest.Inherited))
+		}
+		if manifest.RewrittenFrom != "" {
+			fmt.Printf("Rewritten: from %s\n", manifest.RewrittenFrom)
+		}
 		fmt.Printf("\nConfiguration:\n")
 		fmt.Printf("  Retention:     %d\n", manifest.Config.Retention)
 		fmt.Printf("  Snapshot on:   %v\n", manifest.Config.SnapshotOn)
@@ -87,21 +86,61 @@ to select a specific snapshot index.`,
 		}
 		sort.Strings(files)
 
+		secret := make(map[string]bool)
+		for _, file := range manifest.SecretFiles() {
+			secret[file] = true
+		}
+
 		fmt.Printf("\nFiles (%d total):\n", len(files))
 		for _, file := range files {
-			fmt.Printf("  %s\n", file)
+			if secret[file] {
+				fmt.Printf("  %s [secret]\n", file)
+			} else {
+				fmt.Printf("  %s\n", file)
+			}
 			if verbose {
 				fmt.Printf("    SHA256: %s\n", manifest.Files[file])
 			}
 		}
 
+		if showSecrets {
+			printSecrets(manifest)
+		}
+
 		return nil
 	},
 }
 
+// printSecrets lists the secret scanner's findings by file and line,
+// without the secrets themselves
+func printSecrets(manifest *ignoregrets.Manifest) {
+	fmt.Printf("\nSecrets:\n")
+	if manifest.SecretRules == "" {
+		fmt.Printf("  Not scanned (snapshot predates the scanner or skip_secret_scan is set)\n")
+		return
+	}
+	if len(manifest.Secrets) == 0 {
+		fmt.Printf("  None found\n")
+		return
+	}
+
+	files := make([]string, 0, len(manifest.Secrets))
+	for file := range manifest.Secrets {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		fmt.Printf("  %s\n", file)
+		for _, f := range manifest.Secrets[file] {
+			fmt.Printf("    line %d: %s (%s)\n", f.Line, f.Rule, f.Action)
+		}
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(inspectCmd)
 	inspectCmd.Flags().StringVar(&commitHash, "commit", "", "Commit hash to inspect (defaults to current HEAD)")
-	inspectCmd.Flags().IntVar(&snapIndex, "snapshot", 0, "Snapshot index to inspect (defaults to 0)")
+	inspectCmd.Flags().IntVar(&snapIndex, "snapshot", ignoregrets.Latest, "Snapshot index to inspect, as shown by list; -1 selects the latest")
 	inspectCmd.Flags().BoolVar(&verbose, "verbose", false, "Show file checksums")
+	inspectCmd.Flags().BoolVar(&showSecrets, "secrets", false, "Show secrets found in the snapshot's files, without their values")
 }
@@ -4,13 +4,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
-	"strings"
-	"time"
 
 	"github.com/spf13/cobra"
-
-	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
 )
 
 var listCmd = &cobra.Command{
@@ -19,57 +14,21 @@ var listCmd = &cobra.Command{
 	Long: `List all snapshots in .ignoregrets/snapshots/ with their commit hash,
 timestamp, index, and file count.
 
-Snapshots are sorted by commit hash and timestamp.`,
+Snapshots are sorted by commit hash, newest first. The index in brackets
+selects the snapshot with --snapshot in restore and inspect. Snapshots
+taken with a profile are marked with its name, followed by the note
+given with snapshot -m.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		dir := filepath.Join(".ignoregrets", "snapshots")
-		files, err := os.ReadDir(dir)
+		store, err := openStore()
 		if err != nil {
-			return fmt.Errorf("failed to read snapshots directory: %w", err)
-		}
-
-		type snapshotInfo struct {
-			path      string
-			commit    string
-			timestamp time.Time
-			index     int
+			return err
 		}
 
-		var snapshots []snapshotInfo
-
-		// Collect snapshot information
-		for _, file := range files {
-			if !file.IsDir() && strings.HasSuffix(file.Name(), ".tar.gz") {
-				path := filepath.Join(dir, file.Name())
-				f, err := os.Open(path)
-				if err != nil {
-					return fmt.Errorf("failed to open snapshot %s: %w", file.Name(), err)
-				}
-
-				manifest, err := snapshot.ReadManifest(f)
-				f.Close()
-				if err != nil {
-					fmt.Fprintf(os.Stderr, "Warning: failed to read manifest from %s: %v\n", file.Name(), err)
-					continue
-				}
-
-				snapshots = append(snapshots, snapshotInfo{
-					path:      file.Name(),
-					commit:    manifest.CommitHash,
-					timestamp: manifest.Timestamp,
-					index:     manifest.Index,
-				})
-			}
+		snapshots, err := store.List("")
+		if err != nil {
+			return err
 		}
 
-		// Sort by commit hash and timestamp
-		sort.Slice(snapshots, func(i, j int) bool {
-			if snapshots[i].commit != snapshots[j].commit {
-				return snapshots[i].commit < snapshots[j].commit
-			}
-			return snapshots[i].timestamp.Before(snapshots[j].timestamp)
-		})
-
-		// Print snapshots
 		if len(snapshots) == 0 {
 			fmt.Println("No snapshots found")
 			return nil
@@ -79,17 +38,28 @@ Snapshots are sorted by commit hash and timestamp.`,
 		fmt.Println("--------------------")
 		currentCommit := ""
 		for _, s := range snapshots {
-			if s.commit != currentCommit {
+			if s.Err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to read manifest from %s: %v\n", filepath.Base(s.Path), s.Err)
+				continue
+			}
+			if s.Ref.Commit != currentCommit {
 				if currentCommit != "" {
 					fmt.Println()
 				}
-				currentCommit = s.commit
-				fmt.Printf("Commit: %s\n", s.commit)
+				currentCommit = s.Ref.Commit
+				fmt.Printf("Commit: %s\n", s.Ref.Commit)
+			}
+			fmt.Printf("  [%d] %s (%d files)",
+				s.Ref.Index,
+				s.Manifest.Timestamp.Format("2006-01-02 15:04:05"),
+				len(s.Manifest.Files))
+			if s.Manifest.Profile != "" {
+				fmt.Printf(" profile %s", s.Manifest.Profile)
+			}
+			if s.Manifest.Message != "" {
+				fmt.Printf(" - %s", s.Manifest.Message)
 			}
-			fmt.Printf("  [%d] %s (%d files)\n",
-				s.index,
-				s.timestamp.Format("2006-01-02 15:04:05"),
-				len(s.path))
+			fmt.Println()
 		}
 
 		return nil
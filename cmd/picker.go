@@ -0,0 +1,445 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+// Keys the picker understands
+const (
+	keyNone = iota
+	keyUp
+	keyDown
+	keyPageUp
+	keyPageDown
+	keySpace
+	keyAll
+	keyEnter
+	keyBack
+	keyQuit
+)
+
+// Drift of a file in the previewed snapshot, shown like status
+const (
+	driftModified  = 'M'
+	driftDeleted   = 'D'
+	driftUnchanged = '='
+)
+
+// pickerFile is a file of the previewed snapshot
+type pickerFile struct {
+	path     string
+	drift    byte
+	selected bool
+}
+
+// picker is the terminal UI of restore -i. It lists snapshots, previews
+// the files of one with their drift from the working tree, and returns
+// the files chosen to restore.
+type picker struct {
+	ctx   context.Context
+	store *ignoregrets.Store
+	in    *os.File
+	out   io.Writer
+
+	snapshots []ignoregrets.Snapshot
+	subjects  map[string]string
+	cursor    int
+	offset    int
+
+	// status and files are set while a snapshot is previewed
+	status     *ignoregrets.Status
+	files      []pickerFile
+	fileCursor int
+	fileOffset int
+
+	message string
+	width   int
+	height  int
+}
+
+// pickerResult is the snapshot and files chosen in the picker
+type pickerResult struct {
+	Ref   ignoregrets.SnapshotRef
+	Paths []string
+}
+
+// runPicker shows the picker for snapshots on the terminal. It returns
+// nil if the user quits without choosing files.
+func runPicker(ctx context.Context, store *ignoregrets.Store, snapshots []ignoregrets.Snapshot) (*pickerResult, error) {
+	// Like promptOverwrite, prefer /dev/tty so the picker works when stdin
+	// or stdout is redirected
+	in, out := os.Stdin, io.Writer(os.Stdout)
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		in, out = tty, tty
+	}
+	if !term.IsTerminal(int(in.Fd())) {
+		return nil, fmt.Errorf("restore -i needs a terminal")
+	}
+
+	// Newest first, whatever the commit
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Manifest.Timestamp.After(snapshots[j].Manifest.Timestamp)
+	})
+	commits := make([]string, 0, len(snapshots))
+	for _, s := range snapshots {
+		commits = append(commits, s.Ref.Commit)
+	}
+	// Subjects are only shown, and commits may be gone after a rewrite
+	subjects, err := git.CommitSubjects(ctx, commits)
+	if err != nil {
+		subjects = nil
+	}
+
+	state, err := term.MakeRaw(int(in.Fd()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	defer term.Restore(int(in.Fd()), state)
+	// Use the alternate screen so the shell is left as it was
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	defer fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+
+	p := &picker{ctx: ctx, store: store, in: in, out: out, snapshots: snapshots, subjects: subjects}
+	return p.run()
+}
+
+// run handles keys until a restore is chosen or the user quits
+func (p *picker) run() (*pickerResult, error) {
+	buf := make([]byte, 16)
+	for {
+		if err := p.ctx.Err(); err != nil {
+			return nil, err
+		}
+		p.render()
+
+		n, err := p.in.Read(buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read from terminal: %w", err)
+		}
+		key := parseKey(buf[:n])
+		p.message = ""
+
+		if key == keyQuit {
+			return nil, nil
+		}
+		if p.status == nil {
+			p.listKey(key)
+			continue
+		}
+		if result := p.previewKey(key); result != nil {
+			return result, nil
+		}
+	}
+}
+
+// parseKey maps the bytes of one read from the terminal to a key
+func parseKey(b []byte) int {
+	switch string(b) {
+	case "\x1b[A", "\x1bOA", "k":
+		return keyUp
+	case "\x1b[B", "\x1bOB", "j":
+		return keyDown
+	case "\x1b[5~":
+		return keyPageUp
+	case "\x1b[6~":
+		return keyPageDown
+	case " ":
+		return keySpace
+	case "a":
+		return keyAll
+	case "\r", "\n", "\x1b[C", "\x1bOC", "l":
+		return keyEnter
+	case "\x1b", "\x7f", "\x1b[D", "\x1bOD", "h":
+		return keyBack
+	case "q", "\x03", "\x04":
+		return keyQuit
+	}
+	return keyNone
+}
+
+// move applies a movement key to a cursor over n rows
+func (p *picker) move(cursor *int, key, n int) {
+	page := p.rows()
+	switch key {
+	case keyUp:
+		*cursor--
+	case keyDown:
+		*cursor++
+	case keyPageUp:
+		*cursor -= page
+	case keyPageDown:
+		*cursor += page
+	}
+	*cursor = max(0, min(*cursor, n-1))
+}
+
+// listKey handles a key on the snapshot list
+func (p *picker) listKey(key int) {
+	switch key {
+	case keyEnter:
+		p.preview()
+	case keyBack:
+		// Nothing to go back to
+	default:
+		p.move(&p.cursor, key, len(p.snapshots))
+	}
+}
+
+// previewKey handles a key on the preview of a snapshot, returning the
+// result once files are chosen
+func (p *picker) previewKey(key int) *pickerResult {
+	switch key {
+	case keyBack:
+		p.status, p.files = nil, nil
+	case keySpace:
+		if len(p.files) > 0 {
+			p.files[p.fileCursor].selected = !p.files[p.fileCursor].selected
+			p.move(&p.fileCursor, keyDown, len(p.files))
+		}
+	case keyAll:
+		// Select all, or clear if everything is selected already
+		all := true
+		for _, f := range p.files {
+			all = all && f.selected
+		}
+		for i := range p.files {
+			p.files[i].selected = !all
+		}
+	case keyEnter:
+		var paths []string
+		for _, f := range p.files {
+			if f.selected {
+				paths = append(paths, f.path)
+			}
+		}
+		if len(paths) == 0 {
+			p.message = "No files selected"
+			return nil
+		}
+		return &pickerResult{Ref: p.status.Snapshot.Ref, Paths: paths}
+	default:
+		p.move(&p.fileCursor, key, len(p.files))
+	}
+	return nil
+}
+
+// preview compares the snapshot under the cursor with the working tree.
+// Modified and deleted files are selected to start with, as they are the
+// ones a restore changes; see pickerFiles.
+func (p *picker) preview() {
+	if len(p.snapshots) == 0 {
+		return
+	}
+	p.message = "Comparing files..."
+	p.render()
+
+	status, err := p.store.Status(p.ctx, p.snapshots[p.cursor].Ref)
+	if err != nil {
+		p.message = fmt.Sprintf("Failed to compare files: %v", err)
+		return
+	}
+	p.message = ""
+	p.status, p.files = status, pickerFiles(status)
+	p.fileCursor, p.fileOffset = 0, 0
+}
+
+// pickerFiles returns the files of the snapshot compared in status, sorted
+// by path, with the modified and deleted ones selected
+func pickerFiles(status *ignoregrets.Status) []pickerFile {
+	var files []pickerFile
+	for _, path := range status.Modified {
+		files = append(files, pickerFile{path: path, drift: driftModified, selected: true})
+	}
+	for _, path := range status.Deleted {
+		files = append(files, pickerFile{path: path, drift: driftDeleted, selected: true})
+	}
+	for _, path := range status.Unchanged {
+		files = append(files, pickerFile{path: path, drift: driftUnchanged})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+// rows returns the number of rows left for the list or file rows
+func (p *picker) rows() int {
+	header := 2
+	if p.status != nil {
+		header = 6
+	}
+	return max(1, p.height-header-2)
+}
+
+// render redraws the screen
+func (p *picker) render() {
+	p.width, p.height = 80, 24
+	if f, ok := p.out.(*os.File); ok {
+		if w, h, err := term.GetSize(int(f.Fd())); err == nil && w > 0 && h > 0 {
+			p.width, p.height = w, h
+		}
+	}
+
+	// Lines are separated rather than ended, so the last row doesn't
+	// scroll the screen
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	first := true
+	line := func(text string, highlight bool) {
+		if !first {
+			b.WriteString("\r\n")
+		}
+		first = false
+		text = truncate(text, p.width)
+		if highlight {
+			text = "\x1b[7m" + text + "\x1b[0m"
+		}
+		b.WriteString(text + "\x1b[K")
+	}
+
+	if p.status == nil {
+		p.renderList(line)
+	} else {
+		p.renderPreview(line)
+	}
+	b.WriteString("\x1b[J")
+	fmt.Fprint(p.out, b.String())
+}
+
+// renderList draws the snapshot list
+func (p *picker) renderList(line func(string, bool)) {
+	line(fmt.Sprintf("Snapshots (%d)", len(p.snapshots)), false)
+	line("", false)
+
+	rows := p.rows()
+	p.offset = scroll(p.offset, p.cursor, rows)
+	now := time.Now()
+	for i := p.offset; i < len(p.snapshots) && i < p.offset+rows; i++ {
+		s := p.snapshots[i]
+		m := s.Manifest
+		text := fmt.Sprintf("%s  %-9s %5d files  %s", shortRef(s.Ref), formatAge(m.Timestamp, now), len(m.Files), m.Branch)
+		if m.Profile != "" {
+			text += " [" + m.Profile + "]"
+		}
+		if subject := p.subjects[s.Ref.Commit]; subject != "" {
+			text += "  " + subject
+		}
+		if m.Message != "" {
+			text += " - " + m.Message
+		}
+		line(text, i == p.cursor)
+	}
+	for i := len(p.snapshots) - p.offset; i < rows; i++ {
+		line("", false)
+	}
+
+	line(p.message, false)
+	line("up/down move  enter preview  q quit", false)
+}
+
+// renderPreview draws the files of the previewed snapshot
+func (p *picker) renderPreview(line func(string, bool)) {
+	s := p.status.Snapshot
+	m := s.Manifest
+	header := fmt.Sprintf("Snapshot %s, %s", shortRef(s.Ref), formatAge(m.Timestamp, time.Now()))
+	if m.Branch != "" {
+		header += ", branch " + m.Branch
+	}
+	if m.Profile != "" {
+		header += ", profile " + m.Profile
+	}
+	line(header, false)
+	line(p.subjects[s.Ref.Commit], false)
+	line(m.Message, false)
+	drift := fmt.Sprintf("%d files: %d modified, %d deleted, %d unchanged",
+		len(p.files), len(p.status.Modified), len(p.status.Deleted), len(p.status.Unchanged))
+	if len(p.status.Added) > 0 {
+		drift += fmt.Sprintf("; %d new files not in the snapshot", len(p.status.Added))
+	}
+	line(drift, false)
+	line("", false)
+
+	selected := 0
+	for _, f := range p.files {
+		if f.selected {
+			selected++
+		}
+	}
+	line(fmt.Sprintf("%d of %d files selected", selected, len(p.files)), false)
+
+	rows := p.rows()
+	p.fileOffset = scroll(p.fileOffset, p.fileCursor, rows)
+	for i := p.fileOffset; i < len(p.files) && i < p.fileOffset+rows; i++ {
+		f := p.files[i]
+		mark := " "
+		if f.selected {
+			mark = "x"
+		}
+		line(fmt.Sprintf("[%s] %c %s", mark, f.drift, f.path), i == p.fileCursor)
+	}
+	for i := len(p.files) - p.fileOffset; i < rows; i++ {
+		line("", false)
+	}
+
+	line(p.message, false)
+	line("space toggle  a all  enter restore  esc back  q quit", false)
+}
+
+// scroll returns the offset that keeps cursor within rows of the view
+func scroll(offset, cursor, rows int) int {
+	if cursor < offset {
+		return cursor
+	}
+	if cursor >= offset+rows {
+		return cursor - rows + 1
+	}
+	return offset
+}
+
+// truncate cuts text to width columns, counting each rune as one
+func truncate(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	if width < 1 {
+		return ""
+	}
+	return string(runes[:width-1]) + "~"
+}
+
+// shortRef formats a snapshot reference with an abbreviated commit
+func shortRef(ref ignoregrets.SnapshotRef) string {
+	commit := ref.Commit
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	return fmt.Sprintf("%s:%d", commit, ref.Index)
+}
+
+// formatAge formats how long before now t was, falling back to the date
+// after a month
+func formatAge(t, now time.Time) string {
+	age := now.Sub(t)
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	case age < 30*24*time.Hour:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+	return t.Local().Format("2006-01-02")
+}
@@ -0,0 +1,199 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+func TestParseKey(t *testing.T) {
+	tests := map[string]int{
+		"\x1b[A": keyUp, "\x1bOA": keyUp, "k": keyUp,
+		"\x1b[B": keyDown, "\x1bOB": keyDown, "j": keyDown,
+		"\x1b[5~": keyPageUp, "\x1b[6~": keyPageDown,
+		" ": keySpace, "a": keyAll,
+		"\r": keyEnter, "\n": keyEnter, "\x1b[C": keyEnter, "l": keyEnter,
+		"\x1b": keyBack, "\x7f": keyBack, "\x1b[D": keyBack, "h": keyBack,
+		"q": keyQuit, "\x03": keyQuit, "\x04": keyQuit,
+		"x": keyNone, "": keyNone, "\x1b[Z": keyNone,
+		// Keys pasted together in one read aren't split
+		"jj": keyNone,
+	}
+	for in, want := range tests {
+		if got := parseKey([]byte(in)); got != want {
+			t.Errorf("parseKey(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestPickerMove(t *testing.T) {
+	// 10 rows leave 6 list rows under the header and above the footer
+	p := &picker{height: 10}
+	if rows := p.rows(); rows != 6 {
+		t.Fatalf("Expected 6 rows, got %d", rows)
+	}
+
+	tests := []struct {
+		cursor, key, n, want int
+	}{
+		{0, keyDown, 3, 1},
+		{2, keyDown, 3, 2},
+		{0, keyUp, 3, 0},
+		{0, keyPageDown, 20, 6},
+		{18, keyPageDown, 20, 19},
+		{3, keyPageUp, 20, 0},
+		{0, keyDown, 0, 0},
+		{5, keyNone, 3, 2},
+	}
+	for _, tt := range tests {
+		cursor := tt.cursor
+		p.move(&cursor, tt.key, tt.n)
+		if cursor != tt.want {
+			t.Errorf("move from %d with key %d over %d rows = %d, want %d", tt.cursor, tt.key, tt.n, cursor, tt.want)
+		}
+	}
+
+	// A tiny terminal still shows a row
+	if rows := (&picker{height: 3}).rows(); rows != 1 {
+		t.Errorf("Expected 1 row on a tiny terminal, got %d", rows)
+	}
+}
+
+func TestScroll(t *testing.T) {
+	tests := []struct {
+		offset, cursor, rows, want int
+	}{
+		{0, 3, 5, 0},
+		{0, 5, 5, 1},
+		{0, 12, 5, 8},
+		{8, 4, 5, 4},
+		{8, 8, 5, 8},
+	}
+	for _, tt := range tests {
+		if got := scroll(tt.offset, tt.cursor, tt.rows); got != tt.want {
+			t.Errorf("scroll(%d, %d, %d) = %d, want %d", tt.offset, tt.cursor, tt.rows, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 5, "too ~"},
+		{"héllo wörld", 6, "héllo~"},
+		{"anything", 0, ""},
+		{"", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := truncate(tt.text, tt.width); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestShortRef(t *testing.T) {
+	ref := ignoregrets.SnapshotRef{Commit: "e0ecaaea44f6f4ca1f86f2b6464bdd74d2eb6fa0", Index: 2}
+	if got := shortRef(ref); got != "e0ecaae:2" {
+		t.Errorf("Expected e0ecaae:2, got %s", got)
+	}
+	if got := shortRef(ignoregrets.SnapshotRef{Commit: "abc", Index: 0}); got != "abc:0" {
+		t.Errorf("Expected abc:0, got %s", got)
+	}
+}
+
+func TestFormatAge(t *testing.T) {
+	now := time.Date(2025, 7, 26, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{0, "just now"},
+		{59 * time.Second, "just now"},
+		{time.Minute, "1m ago"},
+		{59 * time.Minute, "59m ago"},
+		{time.Hour, "1h ago"},
+		{23*time.Hour + 59*time.Minute, "23h ago"},
+		{24 * time.Hour, "1d ago"},
+		{29 * 24 * time.Hour, "29d ago"},
+		{30 * 24 * time.Hour, "2025-06-26"},
+	}
+	for _, tt := range tests {
+		if got := formatAge(now.Add(-tt.age), now); got != tt.want {
+			t.Errorf("formatAge(%v ago) = %q, want %q", tt.age, got, tt.want)
+		}
+	}
+}
+
+func TestPickerFiles(t *testing.T) {
+	status := &ignoregrets.Status{
+		Modified:  []string{"b/mod"},
+		Deleted:   []string{"a/del"},
+		Unchanged: []string{"c/same", ".env"},
+		Added:     []string{"new"},
+	}
+	want := []pickerFile{
+		{path: ".env", drift: driftUnchanged},
+		{path: "a/del", drift: driftDeleted, selected: true},
+		{path: "b/mod", drift: driftModified, selected: true},
+		{path: "c/same", drift: driftUnchanged},
+	}
+	if got := pickerFiles(status); !reflect.DeepEqual(got, want) {
+		t.Errorf("pickerFiles() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPickerPreviewKeys(t *testing.T) {
+	ref := ignoregrets.SnapshotRef{Commit: "abc123", Index: 1}
+	status := &ignoregrets.Status{
+		Snapshot:  &ignoregrets.Snapshot{Ref: ref},
+		Modified:  []string{"mod"},
+		Unchanged: []string{"same"},
+	}
+	p := &picker{height: 20, status: status, files: pickerFiles(status)}
+	selected := func() []string {
+		var paths []string
+		for _, f := range p.files {
+			if f.selected {
+				paths = append(paths, f.path)
+			}
+		}
+		return paths
+	}
+
+	// Space toggles the file under the cursor and moves down
+	if p.previewKey(keySpace) != nil || p.fileCursor != 1 || len(selected()) != 0 {
+		t.Fatalf("Expected mod to be cleared and the cursor on row 1, got %v at %d", selected(), p.fileCursor)
+	}
+	if result := p.previewKey(keyEnter); result != nil || p.message != "No files selected" {
+		t.Errorf("Expected no restore without a selection, got %+v, %q", result, p.message)
+	}
+
+	// a selects all, then clears all
+	p.previewKey(keyAll)
+	if got := selected(); !reflect.DeepEqual(got, []string{"mod", "same"}) {
+		t.Errorf("Expected all files selected, got %v", got)
+	}
+	p.previewKey(keyAll)
+	if got := selected(); len(got) != 0 {
+		t.Errorf("Expected no files selected, got %v", got)
+	}
+
+	p.previewKey(keySpace)
+	result := p.previewKey(keyEnter)
+	want := &pickerResult{Ref: ref, Paths: []string{"same"}}
+	if !reflect.DeepEqual(result, want) {
+		t.Errorf("Expected %+v, got %+v", want, result)
+	}
+
+	// Esc returns to the list
+	if p.previewKey(keyBack) != nil || p.status != nil || p.files != nil {
+		t.Error("Expected Esc to leave the preview")
+	}
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+const (
+	progressWidth    = 30
+	progressInterval = 100 * time.Millisecond
+)
+
+// progressBar draws progress on stderr. A nil bar draws nothing.
+type progressBar struct {
+	last  time.Time
+	drawn bool
+}
+
+// newProgressBar returns a progress bar, or nil if stderr is not a terminal
+func newProgressBar() *progressBar {
+	info, err := os.Stderr.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return nil
+	}
+	return &progressBar{}
+}
+
+// Update redraws the bar, at most once per progressInterval except for the
+// last file of a phase
+func (b *progressBar) Update(p ignoregrets.Progress) {
+	if b == nil || (p.Files < p.Total && time.Since(b.last) < progressInterval) {
+		return
+	}
+	b.last = time.Now()
+
+	filled := progressWidth
+	if p.Total > 0 {
+		filled = progressWidth * p.Files / p.Total
+	}
+	fmt.Fprintf(os.Stderr, "\r\033[K%-9s [%s%s] %d/%d files, %s",
+		p.Phase,
+		strings.Repeat("#", filled),
+		strings.Repeat(".", progressWidth-filled),
+		p.Files, p.Total, formatBytes(p.Bytes))
+	b.drawn = true
+}
+
+// Done clears the bar
+func (b *progressBar) Done() {
+	if b == nil || !b.drawn {
+		return
+	}
+	fmt.Fprint(os.Stderr, "\r\033[K")
+	b.drawn = false
+}
+
+// formatBytes formats a size with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -1,18 +1,15 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-	"path/filepath"
-	"sort"
-	"strings"
-
 	"github.com/spf13/cobra"
 
-	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
 )
 
-var retention int
+var (
+	retention int
+	compact   bool
+)
 
 var pruneCmd = &cobra.Command{
 	Use:   "prune",
@@ -20,66 +17,25 @@ var pruneCmd = &cobra.Command{
 	Long: `Delete old snapshots, keeping only the latest N snapshots per commit.
 The number of snapshots to keep is determined by the retention setting
 in config.yaml, which can be overridden with the --retention flag.
+Snapshots of each profile are counted separately, with the profile's own
+retention.
 
-Snapshots are sorted by timestamp and index, with the newest kept.`,
+Snapshots are sorted by timestamp and index, with the newest kept.
+Snapshots still needed as parents by kept incremental snapshots are never
+deleted; use --compact to merge those chains into full snapshots first.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// Load config for default retention
-		cfg, err := config.LoadConfig()
+		store, err := openStore()
 		if err != nil {
 			return err
 		}
 
-		// Use flag value if provided, otherwise use config
-		if retention == 0 {
-			retention = cfg.Retention
-		}
-
-		if retention < 1 {
-			return fmt.Errorf("retention must be greater than 0")
-		}
-
-		// Get all snapshots
-		dir := filepath.Join(".ignoregrets", "snapshots")
-		files, err := os.ReadDir(dir)
-		if err != nil {
-			return fmt.Errorf("failed to read snapshots directory: %w", err)
-		}
-
-		// Group snapshots by commit
-		snapshots := make(map[string][]string)
-		for _, file := range files {
-			if !file.IsDir() && strings.HasSuffix(file.Name(), ".tar.gz") {
-				parts := strings.Split(file.Name(), "_")
-				if len(parts) >= 2 {
-					commit := parts[0]
-					snapshots[commit] = append(snapshots[commit], file.Name())
-				}
-			}
-		}
-
-		// Sort and prune each commit's snapshots
-		for commit, files := range snapshots {
-			// Sort by timestamp and index (newest first)
-			sort.Sort(sort.Reverse(sort.StringSlice(files)))
-
-			// Delete older snapshots
-			if len(files) > retention {
-				fmt.Printf("Pruning snapshots for commit %s:\n", commit)
-				for _, file := range files[retention:] {
-					path := filepath.Join(dir, file)
-					fmt.Printf("  Deleting %s\n", file)
-					if err := os.Remove(path); err != nil {
-						return fmt.Errorf("failed to delete snapshot %s: %w", file, err)
-					}
-				}
-			}
-		}
-
-		return nil
+		// A zero retention uses the configured one
+		return store.Prune(cmd.Context(), ignoregrets.PruneOptions{Retention: retention, Compact: compact})
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(pruneCmd)
 	pruneCmd.Flags().IntVar(&retention, "retention", 0, "Number of snapshots to keep per commit (defaults to config value)")
+	pruneCmd.Flags().BoolVar(&compact, "compact", false, "Compact incremental snapshots whose parents would be pruned")
 }
@@ -0,0 +1,22 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var pullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Copy snapshots from the remote",
+	Long: `Copy the snapshots the local store lacks from the configured remote, with
+their manifest sidecars. Snapshots already in the store are not
+transferred again.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return syncRemote(cmd, "Pulled", (*ignoregrets.Store).Pull)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(pullCmd)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push",
+	Short: "Copy snapshots to the remote",
+	Long: `Copy the snapshots the configured remote lacks to it, with their manifest
+sidecars. Snapshots already on the remote are not transferred again.
+
+Set remote.url in .ignoregrets/config.yaml to an s3://bucket/prefix URL
+or a directory, e.g. on a network share.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return syncRemote(cmd, "Pushed", (*ignoregrets.Store).Push)
+	},
+}
+
+// syncRemote runs push or pull against the configured remote and prints
+// what was copied
+func syncRemote(cmd *cobra.Command, verb string, sync func(*ignoregrets.Store, context.Context, ignoregrets.Backend, ignoregrets.SyncFunc) (*ignoregrets.SyncResult, error)) error {
+	store, err := openStore()
+	if err != nil {
+		return err
+	}
+	remote, err := store.Remote()
+	if err != nil {
+		return err
+	}
+
+	result, err := sync(store, cmd.Context(), remote, func(obj ignoregrets.Object) {
+		fmt.Printf("  %s (%s)\n", obj.Name, formatBytes(obj.Size))
+	})
+	if result != nil {
+		for _, conflict := range result.Conflicts {
+			fmt.Fprintf(os.Stderr, "Warning: skipped %s; use export and import to copy it\n", conflict)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s %d object(s), %s; %d snapshot(s) were already there\n",
+		verb, len(result.Transferred), formatBytes(result.Bytes), result.Present)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(pushCmd)
+}
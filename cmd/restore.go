@@ -1,17 +1,26 @@
 package cmd
 
 import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/spf13/cobra"
 
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
 	"github.com/Cod-e-Codes/ignoregrets/internal/git"
-	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
 )
 
 var (
-	commitHash string
-	snapIndex  int
-	force      bool
-	dryRun     bool
+	commitHash     string
+	snapIndex      int
+	force          bool
+	dryRun         bool
+	conflictPolicy string
+	interactive    bool
 )
 
 var restoreCmd = &cobra.Command{
@@ -22,25 +31,127 @@ By default, restores the latest snapshot for the current commit.
 
 Use --commit to specify a different commit hash and --snapshot to select
 a specific snapshot index. Files will not be overwritten unless --force
-is specified. Use --dry-run to preview what would be restored.`,
+is specified. Use --dry-run to preview what would be restored.
+
+Use --conflict to choose how existing files that differ from the snapshot
+are handled: skip (default), overwrite-unchanged (overwrite only files that
+match the latest snapshot of the current commit), overwrite, or prompt.
+
+Use --profile to restore the latest snapshot taken with a profile, e.g.
+--profile env; without it, the latest snapshot taken without one.
+
+Use -i to pick the snapshot and files in a terminal UI. It lists every
+snapshot, newest first, with its commit subject, branch, age, file count
+and message; --commit and --profile narrow the list. Enter previews a
+snapshot's files marked M (modified), D (deleted) or = (unchanged) like
+status, with the modified and deleted ones selected. Space toggles a file,
+a toggles all, Enter restores the selected files over the working tree,
+Esc goes back and q quits.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		if commitHash == "" {
-			var err error
-			commitHash, err = git.GetCurrentCommit()
-			if err != nil {
-				return err
-			}
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+
+		if interactive {
+			return restoreInteractive(cmd, store)
 		}
 
-		return snapshot.RestoreSnapshot(commitHash, snapIndex, force, dryRun)
+		if force {
+			conflictPolicy = config.ConflictOverwrite
+		}
+		if !config.ValidConflictPolicy(conflictPolicy) {
+			return fmt.Errorf("invalid conflict policy: %s", conflictPolicy)
+		}
+
+		head, err := git.GetCurrentCommit(cmd.Context())
+		if err != nil {
+			return err
+		}
+
+		ref := ignoregrets.SnapshotRef{Commit: commitHash, Index: snapIndex}
+		return store.Restore(cmd.Context(), ref, ignoregrets.RestoreOptions{
+			Policy:   conflictPolicy,
+			DryRun:   dryRun,
+			Baseline: baselineFor(store, head),
+			Prompt:   promptOverwrite,
+		})
 	},
 }
 
+// restoreInteractive restores the files picked in the restore -i picker
+func restoreInteractive(cmd *cobra.Command, store *ignoregrets.Store) error {
+	for _, name := range []string{"snapshot", "force", "conflict"} {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--%s can't be used with -i, which picks the snapshot and files to overwrite", name)
+		}
+	}
+
+	all, err := store.List(commitHash)
+	if err != nil {
+		return err
+	}
+	var snapshots []ignoregrets.Snapshot
+	for _, s := range all {
+		if s.Err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: failed to read manifest from %s: %v\n", filepath.Base(s.Path), s.Err)
+			continue
+		}
+		if profile == "" || s.Manifest.Profile == profile {
+			snapshots = append(snapshots, s)
+		}
+	}
+	if len(snapshots) == 0 {
+		return fmt.Errorf("no snapshots found")
+	}
+
+	result, err := runPicker(cmd.Context(), store, snapshots)
+	if err != nil || result == nil {
+		return err
+	}
+	return store.Restore(cmd.Context(), result.Ref, ignoregrets.RestoreOptions{
+		Policy: config.ConflictOverwrite,
+		DryRun: dryRun,
+		Paths:  result.Paths,
+	})
+}
+
+// baselineFor returns the checksums of the latest snapshot of commit, or
+// nil if it has none
+func baselineFor(store *ignoregrets.Store, commit string) map[string]string {
+	latest, err := store.Get(ignoregrets.SnapshotRef{Commit: commit, Index: ignoregrets.Latest})
+	if err != nil {
+		return nil
+	}
+	return latest.Manifest.Files
+}
+
+// promptOverwrite asks on the terminal whether to overwrite path. Git hooks
+// don't get the terminal on stdin, so /dev/tty is preferred when available.
+func promptOverwrite(path string) bool {
+	in := os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		in = tty
+	}
+
+	fmt.Printf("Overwrite %s with the snapshot version? [y/N] ", path)
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil {
+		fmt.Println()
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
 func init() {
 	rootCmd.AddCommand(restoreCmd)
 
 	restoreCmd.Flags().StringVar(&commitHash, "commit", "", "Commit hash to restore from (defaults to current HEAD)")
-	restoreCmd.Flags().IntVar(&snapIndex, "snapshot", 0, "Snapshot index to restore (defaults to 0)")
+	restoreCmd.Flags().IntVar(&snapIndex, "snapshot", ignoregrets.Latest, "Snapshot index to restore, as shown by list; -1 selects the latest")
 	restoreCmd.Flags().BoolVar(&force, "force", false, "Force overwrite of existing files")
 	restoreCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be restored without making changes")
+	restoreCmd.Flags().StringVar(&conflictPolicy, "conflict", config.ConflictSkip, "How to handle existing files that differ: skip, overwrite-unchanged, overwrite, prompt")
+	restoreCmd.Flags().BoolVarP(&interactive, "interactive", "i", false, "Pick the snapshot and files to restore in a terminal UI")
 }
@@ -1,11 +1,26 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var (
+	lockWait  time.Duration
+	overrides []string
+	profile   string
 )
 
 var rootCmd = &cobra.Command{
@@ -14,7 +29,7 @@ var rootCmd = &cobra.Command{
 	Long: `ignoregrets is a lightweight, local-only CLI tool for snapshotting and restoring 
 Git-ignored files (e.g., build artifacts, .env, IDE metadata) tied to Git commits.
 
-Snapshots are stored in .ignoregrets/snapshots/ and tied to Git commits for version-aware restoration.
+Snapshots are stored in .ignoregrets/snapshots/ (or under store_path) and tied to Git commits for version-aware restoration.
 The tool prioritizes simplicity, safety, and predictability for a solo developer's workflow.
 
 Snapshots of your Git-ignored files. Because resets shouldn't mean regrets.`,
@@ -28,17 +43,80 @@ Snapshots of your Git-ignored files. Because resets shouldn't mean regrets.`,
 		if err := isGitRepo(); err != nil {
 			return fmt.Errorf("not a Git repository: %w", err)
 		}
-		return nil
+		return config.CheckOverrides(overrides)
 	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() error {
-	return rootCmd.Execute()
+	// Run as 'clean' when invoked through the git-ignoregrets-clean alias
+	name := strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
+	if name == cleanAlias {
+		rootCmd.SetArgs(append([]string{"clean"}, os.Args[1:]...))
+	}
+
+	// Cancel running operations on Ctrl-C, so partial archives are removed
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return rootCmd.ExecuteContext(ctx)
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
+	rootCmd.PersistentFlags().DurationVar(&lockWait, "wait", 0, "How long to wait for another ignoregrets process to release the store (e.g. 30s)")
+	rootCmd.PersistentFlags().StringArrayVar(&overrides, "set", nil, "Override a configuration key for this run, as key=value (repeatable)")
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "Use the settings and snapshots of a named profile from the config")
+}
+
+// openStore opens the repository's store with the --profile settings,
+// waiting up to --wait for locks
+func openStore() (*ignoregrets.Store, error) {
+	store, err := ignoregrets.Open(overrides...)
+	if err != nil {
+		return nil, err
+	}
+	if store, err = store.WithProfile(profile); err != nil {
+		return nil, err
+	}
+	store.SetLockWait(lockWait)
+	store.SetPassphraseFunc(promptPassphrase)
+	return store, nil
+}
+
+// promptPassphrase asks for the snapshot passphrase on the terminal without
+// echoing it. Like promptOverwrite, it prefers /dev/tty so it works in hooks.
+func promptPassphrase(confirm bool) (string, error) {
+	in := os.Stdin
+	if tty, err := os.Open("/dev/tty"); err == nil {
+		defer tty.Close()
+		in = tty
+	}
+	if !term.IsTerminal(int(in.Fd())) {
+		return "", fmt.Errorf("no terminal to ask for the snapshot passphrase; set %s", ignoregrets.PassphraseEnv)
+	}
+
+	read := func(prompt string) (string, error) {
+		fmt.Fprint(os.Stderr, prompt)
+		data, err := term.ReadPassword(int(in.Fd()))
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+		return string(data), nil
+	}
+
+	passphrase, err := read("Snapshot passphrase: ")
+	if err != nil || !confirm {
+		return passphrase, err
+	}
+	again, err := read("Confirm passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if again != passphrase {
+		return "", fmt.Errorf("passphrases do not match")
+	}
+	return passphrase, nil
 }
 
 func initConfig() {
@@ -48,13 +126,6 @@ func initConfig() {
 		fmt.Fprintf(os.Stderr, "Error creating .ignoregrets directory: %v\n", err)
 		os.Exit(1)
 	}
-
-	// Create snapshots directory if it doesn't exist
-	snapshotsDir := filepath.Join(ignoregretsDir, "snapshots")
-	if err := os.MkdirAll(snapshotsDir, 0755); err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating snapshots directory: %v\n", err)
-		os.Exit(1)
-	}
 }
 
 // isGitRepo checks if the current directory is a git repository
@@ -1,10 +1,20 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/spf13/cobra"
 
-	"github.com/Cod-e-Codes/ignoregrets/internal/config"
-	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var (
+	always      bool
+	incremental bool
+	message     string
 )
 
 var snapshotCmd = &cobra.Command{
@@ -15,21 +25,84 @@ The snapshot will be stored in .ignoregrets/snapshots/ with a unique name
 based on the commit hash, timestamp, and index.
 
 Files are filtered based on exclude/include patterns in config.yaml.
-A manifest.json file is included in the snapshot with metadata and checksums.`,
+A manifest.json file is included in the snapshot with metadata and checksums.
+
+The snapshot is skipped if the files are identical to the latest snapshot
+for this commit, or for its parent if this commit has none. Use --always
+to create one anyway.
+
+Use --incremental to store only files whose checksum differs from the
+previous snapshot. Unchanged files are referenced from that parent, and
+restore follows the chain to rebuild the full set.
+
+Use --profile to snapshot with the patterns and compression of a profile
+from the config, e.g. --profile build. The snapshot is compared with the
+previous snapshot of the same profile.
+
+Use -m to record a note with the snapshot, shown by list, inspect and
+restore -i.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		cfg, err := config.LoadConfig()
+		store, err := openStore()
 		if err != nil {
 			return err
 		}
 
-		if err := config.ValidateConfig(cfg); err != nil {
+		bar := newProgressBar()
+		snap, err := store.Create(cmd.Context(), ignoregrets.CreateOptions{
+			Always:      always,
+			Incremental: incremental,
+			Message:     message,
+			Progress:    bar.Update,
+			OnSecret: func(path string, f ignoregrets.Finding) {
+				bar.Done()
+				reportSecret(path, f)
+			},
+		})
+		bar.Done()
+		if errors.Is(err, ignoregrets.ErrUnchanged) {
+			manifest := snap.Manifest
+			fmt.Printf("Nothing changed since snapshot [%d] of commit %s, skipping (use --always to force)\n",
+				manifest.Index, manifest.CommitHash)
+			return nil
+		}
+		if errors.Is(err, context.Canceled) {
+			return fmt.Errorf("snapshot cancelled, no archive was written")
+		}
+		if err != nil {
 			return err
 		}
 
-		return snapshot.CreateSnapshot(cfg)
+		manifest := snap.Manifest
+		fmt.Printf("Created snapshot [%d] for commit %s (%d files)\n",
+			manifest.Index, manifest.CommitHash, len(manifest.Files))
+		if manifest.Profile != "" {
+			fmt.Printf("Profile: %s\n", manifest.Profile)
+		}
+		if manifest.Parent != nil {
+			fmt.Printf("Incremental: %d stored, %d from parent %s\n",
+				len(manifest.Files)-len(manifest.Inherited), len(manifest.Inherited), manifest.Parent)
+		}
+		if secret := manifest.SecretFiles(); len(secret) > 0 {
+			fmt.Printf("%d file(s) contain secrets; run 'ignoregrets inspect --secrets' for details\n", len(secret))
+		}
+		return nil
 	},
 }
 
+// reportSecret prints a warning for a secret found while snapshotting,
+// without the secret itself. Recorded secrets are summarized instead.
+func reportSecret(path string, f ignoregrets.Finding) {
+	switch f.Action {
+	case ignoregrets.SecretWarn:
+		fmt.Fprintf(os.Stderr, "Warning: %s:%d matches secret rule %s\n", path, f.Line, f.Rule)
+	case ignoregrets.SecretExclude:
+		fmt.Fprintf(os.Stderr, "Excluding %s: line %d matches secret rule %s\n", path, f.Line, f.Rule)
+	}
+}
+
 func init() {
 	rootCmd.AddCommand(snapshotCmd)
+	snapshotCmd.Flags().BoolVar(&always, "always", false, "Create a snapshot even if nothing changed since the previous one")
+	snapshotCmd.Flags().BoolVar(&incremental, "incremental", false, "Store only files that changed since the previous snapshot")
+	snapshotCmd.Flags().StringVarP(&message, "message", "m", "", "Record a note with the snapshot")
 }
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/Cod-e-Codes/ignoregrets/pkg/ignoregrets"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check a snapshot against its checksums",
+	Long: `Read every file of a snapshot, following incremental snapshots to their
+parents, and check it against the checksum recorded in the manifest.
+By default, verifies the latest snapshot for the current commit.
+
+Use --commit to specify a different commit hash and --snapshot
+to select a specific snapshot index.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := openStore()
+		if err != nil {
+			return err
+		}
+
+		snap, err := store.Get(ignoregrets.SnapshotRef{Commit: commitHash, Index: snapIndex})
+		if err != nil {
+			return err
+		}
+		if err := store.Verify(cmd.Context(), snap.Ref); err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot [%d] for commit %s is intact (%d files)\n",
+			snap.Ref.Index, snap.Ref.Commit, len(snap.Manifest.Files))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringVar(&commitHash, "commit", "", "Commit hash to verify (defaults to current HEAD)")
+	verifyCmd.Flags().IntVar(&snapIndex, "snapshot", ignoregrets.Latest, "Snapshot index to verify, as shown by list; -1 selects the latest")
+}
@@ -2,9 +2,18 @@ module github.com/Cod-e-Codes/ignoregrets
 
 go 1.24.4
 
+require (
+	filippo.io/age v1.2.1
+	github.com/klauspost/compress v1.18.0
+	github.com/klauspost/pgzip v1.2.6
+	github.com/spf13/cobra v1.9.1
+	golang.org/x/crypto v0.24.0
+	golang.org/x/term v0.21.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
 require (
 	github.com/inconshreveable/mousetrap v1.1.0 // indirect
-	github.com/spf13/cobra v1.9.1 // indirect
 	github.com/spf13/pflag v1.0.6 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	golang.org/x/sys v0.21.0 // indirect
 )
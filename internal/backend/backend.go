@@ -0,0 +1,117 @@
+// Package backend stores snapshot objects: archives and their manifest
+// sidecars, addressed by file name. The local snapshot directory and
+// remote stores implement the same interface, so snapshots can be synced
+// between them.
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// ErrNotExist is returned for objects that are not in the backend
+var ErrNotExist = errors.New("object does not exist")
+
+// Object describes a stored object
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Backend stores named objects. Put must not expose partially written
+// objects to Get or List.
+type Backend interface {
+	// Put stores size bytes read from r as name, replacing any object of
+	// that name
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+
+	// Get opens the object name for reading
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+
+	// Stat describes the object name
+	Stat(ctx context.Context, name string) (Object, error)
+
+	// List returns every object, sorted by name
+	List(ctx context.Context) ([]Object, error)
+
+	// Delete removes the object name
+	Delete(ctx context.Context, name string) error
+}
+
+// Open returns the backend for a configured remote
+func Open(remote config.Remote) (Backend, error) {
+	if remote.URL == "" {
+		return nil, fmt.Errorf("no remote configured; set remote.url")
+	}
+	bucket, prefix, ok, err := remote.S3()
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		return NewS3(S3Config{
+			Endpoint:     remote.Endpoint,
+			Bucket:       bucket,
+			Prefix:       prefix,
+			Region:       remote.Region,
+			PathStyle:    remote.PathStyle,
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		})
+	}
+
+	dir := remote.URL
+	if u, err := url.Parse(dir); err == nil && u.Scheme == "file" {
+		dir = u.Path
+	}
+	return NewLocal(dir), nil
+}
+
+// validName reports whether name can be used as an object name: a plain
+// file name without directories
+func validName(name string) bool {
+	if name == "" || name == "." || name == ".." {
+		return false
+	}
+	for _, c := range name {
+		if c == '/' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// checkName returns an error for invalid object names
+func checkName(name string) error {
+	if !validName(name) {
+		return fmt.Errorf("invalid object name: %q", name)
+	}
+	return nil
+}
+
+// ContextReader returns a reader that fails once ctx is done, so copying a
+// large object or file stops promptly on cancellation
+func ContextReader(ctx context.Context, r io.Reader) io.Reader {
+	return ctxReader{ctx, r}
+}
+
+// ctxReader is the reader returned by ContextReader
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
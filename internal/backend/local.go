@@ -0,0 +1,132 @@
+package backend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Local stores objects as files in a directory
+type Local struct {
+	dir string
+}
+
+// NewLocal returns a backend for the directory dir, which is created when
+// the first object is stored
+func NewLocal(dir string) *Local {
+	return &Local{dir: dir}
+}
+
+// Dir returns the backend's directory
+func (l *Local) Dir() string {
+	return l.dir
+}
+
+// Put writes the object to a temporary file and renames it into place.
+// Temporary files are hidden and contain ".tmp-", like the store's own,
+// so List skips them and prune removes them if left behind.
+func (l *Local) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", l.dir, err)
+	}
+
+	tmp, err := os.CreateTemp(l.dir, "."+name+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", name, err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	n, err := io.Copy(tmp, ContextReader(ctx, r))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if n != size {
+		return fmt.Errorf("failed to write %s: expected %d bytes, got %d", name, size, n)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", name, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", name, err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(l.dir, name)); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get opens the object's file
+func (l *Local) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	file, err := os.Open(filepath.Join(l.dir, name))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+	return file, err
+}
+
+// Stat describes the object's file
+func (l *Local) Stat(ctx context.Context, name string) (Object, error) {
+	if err := checkName(name); err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(filepath.Join(l.dir, name))
+	if os.IsNotExist(err) {
+		return Object{}, fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Name: name, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// List returns the files in the directory, skipping hidden files such as
+// temporary files. A missing directory has no objects.
+func (l *Local) List(ctx context.Context) ([]Object, error) {
+	entries, err := os.ReadDir(l.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", l.dir, err)
+	}
+
+	var objects []Object
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue // removed since ReadDir
+		}
+		objects = append(objects, Object{Name: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// Delete removes the object's file
+func (l *Local) Delete(ctx context.Context, name string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	err := os.Remove(filepath.Join(l.dir, name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrNotExist, name)
+	}
+	return err
+}
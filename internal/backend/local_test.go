@@ -0,0 +1,91 @@
+package backend
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBackend checks the behaviour every backend must share
+func testBackend(t *testing.T, b Backend) {
+	ctx := context.Background()
+
+	if objects, err := b.List(ctx); err != nil || len(objects) != 0 {
+		t.Fatalf("Expected an empty backend, got %v, %v", objects, err)
+	}
+
+	for name, content := range map[string]string{"b.tar.gz": "archive", "a.manifest.json": "{}", "empty": ""} {
+		if err := b.Put(ctx, name, strings.NewReader(content), int64(len(content))); err != nil {
+			t.Fatalf("Failed to put %s: %v", name, err)
+		}
+	}
+	if err := b.Put(ctx, "b.tar.gz", strings.NewReader("replaced"), 8); err != nil {
+		t.Fatalf("Failed to replace object: %v", err)
+	}
+
+	objects, err := b.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list: %v", err)
+	}
+	var names []string
+	for _, o := range objects {
+		names = append(names, o.Name)
+	}
+	if strings.Join(names, ",") != "a.manifest.json,b.tar.gz,empty" {
+		t.Errorf("Expected sorted objects, got %v", names)
+	}
+
+	r, err := b.Get(ctx, "b.tar.gz")
+	if err != nil {
+		t.Fatalf("Failed to get: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "replaced" {
+		t.Errorf("Expected replaced content, got %q", data)
+	}
+
+	obj, err := b.Stat(ctx, "b.tar.gz")
+	if err != nil || obj.Size != 8 {
+		t.Errorf("Expected size 8, got %+v, %v", obj, err)
+	}
+
+	if err := b.Delete(ctx, "b.tar.gz"); err != nil {
+		t.Fatalf("Failed to delete: %v", err)
+	}
+	if _, err := b.Get(ctx, "b.tar.gz"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist after delete, got %v", err)
+	}
+	if _, err := b.Stat(ctx, "missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist from Stat, got %v", err)
+	}
+	if err := b.Delete(ctx, "missing"); !errors.Is(err, ErrNotExist) {
+		t.Errorf("Expected ErrNotExist from Delete, got %v", err)
+	}
+
+	if err := b.Put(ctx, "../escape", strings.NewReader("x"), 1); err == nil {
+		t.Error("Expected an error for a name with a directory")
+	}
+}
+
+func TestLocal(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	testBackend(t, NewLocal(dir))
+}
+
+func TestLocalPutShortRead(t *testing.T) {
+	dir := t.TempDir()
+	b := NewLocal(dir)
+
+	if err := b.Put(context.Background(), "a", strings.NewReader("abc"), 10); err == nil {
+		t.Fatal("Expected an error when the reader is short")
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("Expected no files after a failed put, found %d", len(entries))
+	}
+}
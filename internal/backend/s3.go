@@ -0,0 +1,343 @@
+package backend
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload is sent as the payload hash, so objects are streamed
+// without being read twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Config configures an S3 backend
+type S3Config struct {
+	// Endpoint is the service URL; it defaults to AWS for Region
+	Endpoint string
+	Bucket   string
+	Prefix   string
+
+	// Region defaults to us-east-1
+	Region string
+
+	// PathStyle puts the bucket in the URL path instead of the host name,
+	// as most self-hosted services require
+	PathStyle bool
+
+	// Requests are anonymous without an access key
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+}
+
+// S3 stores objects in a bucket of an S3-compatible service, under an
+// optional key prefix
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	now      func() time.Time
+}
+
+// NewS3 returns a backend for the bucket cfg describes
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("no S3 bucket configured")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.Client == nil {
+		cfg.Client = http.DefaultClient
+	}
+	cfg.Prefix = strings.Trim(cfg.Prefix, "/")
+
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint: %s", cfg.Endpoint)
+	}
+	return &S3{cfg: cfg, endpoint: endpoint, now: time.Now}, nil
+}
+
+// key returns the object key for name
+func (s *S3) key(name string) string {
+	if s.cfg.Prefix == "" {
+		return name
+	}
+	return s.cfg.Prefix + "/" + name
+}
+
+// url returns the URL of key, or of the bucket if key is empty
+func (s *S3) url(key string, query url.Values) *url.URL {
+	u := *s.endpoint
+	path := strings.TrimSuffix(u.Path, "/")
+	if s.cfg.PathStyle {
+		path += "/" + s.cfg.Bucket
+	} else {
+		u.Host = s.cfg.Bucket + "." + u.Host
+	}
+	u.Path = path + "/" + key
+	u.RawPath = uriEncode(path, false) + "/" + uriEncode(key, false)
+	u.RawQuery = query.Encode()
+	return &u
+}
+
+// do sends a signed request and returns the response if its status is
+// 2xx. Other responses are turned into errors, 404 into ErrNotExist.
+func (s *S3) do(ctx context.Context, method string, u *url.URL, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+	if s.cfg.AccessKey != "" {
+		s.sign(req, unsignedPayload, s.now())
+	}
+
+	resp, err := s.cfg.Client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("S3 %s failed: %w", method, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	var e struct {
+		Code    string
+		Message string
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	xml.Unmarshal(data, &e)
+	if resp.StatusCode == http.StatusNotFound && e.Code != "NoSuchBucket" {
+		return nil, ErrNotExist
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("S3 %s %s: %s %s", method, u.Path, e.Code, e.Message)
+}
+
+// Put uploads the object
+func (s *S3) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.url(s.key(name), nil), r, size)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object
+func (s *S3) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, s.url(s.key(name), nil), nil, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", name, err)
+	}
+	return resp.Body, nil
+}
+
+// Stat describes the object from a HEAD request
+func (s *S3) Stat(ctx context.Context, name string) (Object, error) {
+	if err := checkName(name); err != nil {
+		return Object{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, s.url(s.key(name), nil), nil, 0)
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to stat %s: %w", name, err)
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Name: name, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// List lists the objects under the prefix with ListObjectsV2, skipping
+// keys in deeper "directories"
+func (s *S3) List(ctx context.Context) ([]Object, error) {
+	prefix := ""
+	if s.cfg.Prefix != "" {
+		prefix = s.cfg.Prefix + "/"
+	}
+
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, s.url("", query), nil, 0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects: %w", err)
+		}
+
+		var result struct {
+			Contents []struct {
+				Key          string
+				Size         int64
+				LastModified time.Time
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse object list: %w", err)
+		}
+
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if validName(name) {
+				objects = append(objects, Object{Name: name, Size: c.Size, ModTime: c.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	return objects, nil
+}
+
+// Delete removes the object. S3 reports success for missing objects, so
+// Stat first to return ErrNotExist like the other backends.
+func (s *S3) Delete(ctx context.Context, name string) error {
+	if _, err := s.Stat(ctx, name); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, s.url(s.key(name), nil), nil, 0)
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req,
+// covering the host and every header already set
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.cfg.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.cfg.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.cfg.AccessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery encodes a query string as Signature Version 4 requires:
+// sorted by key, with every reserved character escaped
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var parts []string
+	for _, k := range keys {
+		values := append([]string(nil), query[k]...)
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// uriEncode escapes every byte except unreserved characters, and slashes
+// too if encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteString("%" + strings.ToUpper(strconv.FormatUint(uint64(c)|0x100, 16)[1:]))
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -2,60 +2,277 @@ package config
 
 import (
 	"fmt"
-	"os"
+	"net/url"
 	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
 
+	"filippo.io/age"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the configuration structure for ignoregrets
 type Config struct {
+	// Version is the format of the config file; see Version and migrate
+	Version int `yaml:"version,omitempty"`
+
 	Retention    int      `yaml:"retention"`
 	SnapshotOn   []string `yaml:"snapshot_on"`
 	RestoreOn    []string `yaml:"restore_on"`
 	HooksEnabled bool     `yaml:"hooks_enabled"`
 	Exclude      []string `yaml:"exclude"`
 	Include      []string `yaml:"include"`
+
+	// ConflictPolicy decides how hook restores treat existing files
+	// that differ from the snapshot
+	ConflictPolicy string `yaml:"conflict_policy"`
+
+	// Compression is the codec for new snapshots, and CompressionLevel its
+	// level (0 for the codec's default)
+	Compression      string `yaml:"compression"`
+	CompressionLevel int    `yaml:"compression_level"`
+
+	// Jobs bounds the workers used for hashing and compression
+	// (0 for one per CPU)
+	Jobs int `yaml:"jobs"`
+
+	// Encryption protects new snapshots at rest: none, age (to the X25519
+	// Recipients) or passphrase. IdentityFile holds the age identities that
+	// decrypt them, and EncryptManifest also encrypts the manifest sidecars.
+	Encryption      string   `yaml:"encryption"`
+	Recipients      []string `yaml:"recipients,omitempty"`
+	IdentityFile    string   `yaml:"identity_file,omitempty"`
+	EncryptManifest bool     `yaml:"encrypt_manifest,omitempty"`
+
+	// SecretRules are checked along with the built-in secret rules when a
+	// snapshot is created; a rule named like a built-in one replaces it.
+	// SkipSecretScan turns the scanner off.
+	SecretRules    []SecretRule `yaml:"secret_rules,omitempty"`
+	SkipSecretScan bool         `yaml:"skip_secret_scan,omitempty"`
+
+	// Remote is where push and pull sync snapshots
+	Remote Remote `yaml:"remote,omitempty"`
+
+	// StorePath moves the snapshot store out of the repository. Snapshots
+	// go in a directory under it named by the repository id, so every
+	// clone of the repository finds them.
+	StorePath string `yaml:"store_path,omitempty"`
+
+	// Profiles are named kinds of snapshot, e.g. env or build, each with
+	// its own files, retention, compression and hook events
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+}
+
+// Profile overrides settings for the snapshots of a named profile. Unset
+// fields keep the top-level value; a list set to [] clears it.
+type Profile struct {
+	// Retention is the number of snapshots of the profile to keep per
+	// commit, or KeepAll
+	Retention int `yaml:"retention,omitempty"`
+
+	SnapshotOn       []string `yaml:"snapshot_on,omitempty"`
+	RestoreOn        []string `yaml:"restore_on,omitempty"`
+	Exclude          []string `yaml:"exclude,omitempty"`
+	Include          []string `yaml:"include,omitempty"`
+	Compression      string   `yaml:"compression,omitempty"`
+	CompressionLevel int      `yaml:"compression_level,omitempty"`
+}
+
+// DefaultProfile names the top-level settings, used by snapshots taken
+// without a profile
+const DefaultProfile = "default"
+
+// KeepAll is the profile retention that never prunes the profile's
+// snapshots
+const KeepAll = -1
+
+// profileName matches valid profile names
+var profileName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// Profile returns the settings of the named profile: cfg with the
+// profile's overrides applied. The empty name and DefaultProfile return
+// cfg itself.
+func (c *Config) Profile(name string) (*Config, error) {
+	if name == "" || name == DefaultProfile {
+		return c, nil
+	}
+	p, ok := c.Profiles[name]
+	if !ok {
+		if len(c.Profiles) == 0 {
+			return nil, fmt.Errorf("unknown profile %s: no profiles are configured", name)
+		}
+		return nil, fmt.Errorf("unknown profile %s (profiles: %s)", name, strings.Join(c.ProfileNames(), ", "))
+	}
+
+	merged := *c
+	merged.Profiles = nil
+	if p.Retention != 0 {
+		merged.Retention = p.Retention
+	}
+	if p.SnapshotOn != nil {
+		merged.SnapshotOn = p.SnapshotOn
+	}
+	if p.RestoreOn != nil {
+		merged.RestoreOn = p.RestoreOn
+	}
+	if p.Exclude != nil {
+		merged.Exclude = p.Exclude
+	}
+	if p.Include != nil {
+		merged.Include = p.Include
+	}
+	// A codec's level doesn't carry over to another codec
+	if p.Compression != "" {
+		merged.Compression = p.Compression
+		merged.CompressionLevel = p.CompressionLevel
+	} else if p.CompressionLevel != 0 {
+		merged.CompressionLevel = p.CompressionLevel
+	}
+	return &merged, nil
+}
+
+// ProfileNames returns the names of the configured profiles in order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Profiles))
+	for name := range c.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Remote configures the remote snapshot store. URL is s3://bucket/prefix
+// for an S3-compatible service, or a directory path, e.g. on a network
+// share. Endpoint, Region and PathStyle configure S3; credentials come
+// from the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY variables.
+type Remote struct {
+	URL       string `yaml:"url"`
+	Endpoint  string `yaml:"endpoint,omitempty"`
+	Region    string `yaml:"region,omitempty"`
+	PathStyle bool   `yaml:"path_style,omitempty"`
+}
+
+// SecretRule is a regular expression that finds secrets in snapshot files,
+// and what to do with files that match it
+type SecretRule struct {
+	Name    string `yaml:"name" json:"name"`
+	Pattern string `yaml:"pattern" json:"pattern"`
+	Action  string `yaml:"action" json:"action"`
+}
+
+// Parallelism returns the number of workers to use
+func (c *Config) Parallelism() int {
+	if c.Jobs > 0 {
+		return c.Jobs
+	}
+	return runtime.NumCPU()
+}
+
+// Snapshot compression codecs
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+	CompressionNone = "none"
+)
+
+// Snapshot encryption modes
+const (
+	EncryptionNone       = "none"
+	EncryptionAge        = "age"        // age X25519 recipients
+	EncryptionPassphrase = "passphrase" // age scrypt passphrase
+)
+
+// Secret rule actions
+const (
+	SecretWarn    = "warn"    // warn, and snapshot the file anyway
+	SecretExclude = "exclude" // leave the file out of the snapshot
+	SecretRecord  = "record"  // mark the file as secret in the manifest
+	SecretOff     = "off"     // disable a built-in rule
+)
+
+// secretActions lists the valid secret rule actions
+var secretActions = map[string]bool{
+	SecretWarn:    true,
+	SecretExclude: true,
+	SecretRecord:  true,
+	SecretOff:     true,
+}
+
+// Conflict policies for restoring over files that differ from the snapshot
+const (
+	ConflictSkip               = "skip"                // keep the existing file
+	ConflictOverwriteUnchanged = "overwrite-unchanged" // overwrite if unchanged since its last snapshot
+	ConflictOverwrite          = "overwrite"           // always overwrite
+	ConflictPrompt             = "prompt"              // ask for each file
+)
+
+// conflictPolicies lists the valid conflict_policy values
+var conflictPolicies = map[string]bool{
+	ConflictSkip:               true,
+	ConflictOverwriteUnchanged: true,
+	ConflictOverwrite:          true,
+	ConflictPrompt:             true,
+}
+
+// ValidConflictPolicy reports whether policy is a known conflict policy
+func ValidConflictPolicy(policy string) bool {
+	return conflictPolicies[policy]
+}
+
+// SnapshotEvents lists the Git events valid in snapshot_on
+var SnapshotEvents = map[string]bool{
+	"commit":   true, // pre-commit
+	"checkout": true, // post-checkout
+	"merge":    true, // post-merge, e.g. git pull
+	"rewrite":  true, // post-rewrite: carry snapshots to rewritten commits
+	"rebase":   true, // pre-rebase
+	"push":     true, // pre-push
+}
+
+// RestoreEvents lists the Git events valid in restore_on
+var RestoreEvents = map[string]bool{
+	"checkout": true, // post-checkout
+	"merge":    true, // post-merge
+	"rewrite":  true, // post-rewrite
+}
+
+// HasEvent reports whether event is listed in events
+func HasEvent(events []string, event string) bool {
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
 }
 
 // DefaultConfig returns a new Config with default values
 func DefaultConfig() *Config {
 	return &Config{
+		Version:      Version,
 		Retention:    10,
 		SnapshotOn:   []string{"commit"},
 		RestoreOn:    []string{"checkout"},
 		HooksEnabled: false,
 		Exclude:      []string{},
 		Include:      []string{},
+
+		ConflictPolicy: ConflictSkip,
+		Compression:    CompressionGzip,
+		Encryption:     EncryptionNone,
 	}
 }
 
-// LoadConfig loads the configuration from .ignoregrets/config.yaml
+// LoadConfig loads the configuration, merging the layers described in Load
 func LoadConfig() (*Config, error) {
-	configPath := filepath.Join(".ignoregrets", "config.yaml")
-
-	// Check if config file exists
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		// Create default config if it doesn't exist
-		cfg := DefaultConfig()
-		if err := SaveConfig(cfg); err != nil {
-			return nil, fmt.Errorf("failed to create default config: %w", err)
-		}
-		return cfg, nil
-	}
-
-	// Read config file
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
-	}
-
-	cfg := &Config{}
-	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return nil, fmt.Errorf("failed to parse config file: %w", err)
-	}
+	cfg, _, err := Load()
+	return cfg, err
+}
 
-	// Apply defaults for unset values
+// applyDefaults sets the defaults of values left unset or zero
+func applyDefaults(cfg *Config) {
 	if cfg.Retention <= 0 {
 		cfg.Retention = DefaultConfig().Retention
 	}
@@ -65,47 +282,230 @@ func LoadConfig() (*Config, error) {
 	if len(cfg.RestoreOn) == 0 {
 		cfg.RestoreOn = DefaultConfig().RestoreOn
 	}
-
-	return cfg, nil
+	if cfg.ConflictPolicy == "" {
+		cfg.ConflictPolicy = DefaultConfig().ConflictPolicy
+	}
+	if cfg.Compression == "" {
+		cfg.Compression = DefaultConfig().Compression
+	}
 }
 
-// SaveConfig saves the configuration to .ignoregrets/config.yaml
+// SaveConfig saves the configuration, in the current format, to
+// .ignoregrets/config.yaml
 func SaveConfig(cfg *Config) error {
-	data, err := yaml.Marshal(cfg)
+	current := *cfg
+	current.Version = Version
+	data, err := yaml.Marshal(&current)
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
 
-	configPath := filepath.Join(".ignoregrets", "config.yaml")
-	if err := os.WriteFile(configPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write config file: %w", err)
-	}
+	return writeConfigFile(LocalPath, data)
+}
 
-	return nil
+// KeyError is a validation error in the value of a config key
+type KeyError struct {
+	Key string
+	Err error
+}
+
+func (e *KeyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *KeyError) Unwrap() error {
+	return e.Err
 }
 
-// ValidateConfig checks if the configuration is valid
+// keyErrorf returns a KeyError for key with a formatted message
+func keyErrorf(key, format string, args ...any) error {
+	return &KeyError{Key: key, Err: fmt.Errorf(format, args...)}
+}
+
+// ValidateConfig checks if the configuration is valid. Errors are
+// KeyErrors naming the key at fault.
 func ValidateConfig(cfg *Config) error {
 	if cfg.Retention < 1 {
-		return fmt.Errorf("retention must be greater than 0")
+		return keyErrorf("retention", "retention must be greater than 0")
 	}
 
-	validEvents := map[string]bool{
-		"commit":   true,
-		"checkout": true,
+	if cfg.ConflictPolicy != "" && !ValidConflictPolicy(cfg.ConflictPolicy) {
+		return keyErrorf("conflict_policy", "invalid conflict_policy: %s", cfg.ConflictPolicy)
 	}
 
+	if cfg.Jobs < 0 {
+		return keyErrorf("jobs", "jobs must not be negative")
+	}
+
+	if err := validateSnapshotSettings(cfg); err != nil {
+		return err
+	}
+
+	switch cfg.Encryption {
+	case EncryptionNone, "":
+		if cfg.EncryptManifest {
+			return keyErrorf("encrypt_manifest", "encrypt_manifest requires encryption")
+		}
+	case EncryptionAge:
+		if len(cfg.Recipients) == 0 {
+			return keyErrorf("recipients", "encryption: age requires at least one recipient")
+		}
+		for _, recipient := range cfg.Recipients {
+			if _, err := age.ParseX25519Recipient(recipient); err != nil {
+				return keyErrorf("recipients", "invalid recipient %s: %w", recipient, err)
+			}
+		}
+	case EncryptionPassphrase:
+	default:
+		return keyErrorf("encryption", "invalid encryption: %s", cfg.Encryption)
+	}
+
+	for _, rule := range cfg.SecretRules {
+		if rule.Name == "" {
+			return keyErrorf("secret_rules", "secret rule without a name")
+		}
+		if !secretActions[rule.Action] {
+			return keyErrorf("secret_rules", "invalid action for secret rule %s: %s", rule.Name, rule.Action)
+		}
+		if rule.Action == SecretOff {
+			continue
+		}
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return keyErrorf("secret_rules", "invalid pattern for secret rule %s: %w", rule.Name, err)
+		}
+	}
+
+	if err := validateRemote(cfg.Remote); err != nil {
+		return err
+	}
+
+	for _, name := range cfg.ProfileNames() {
+		if err := validateProfile(cfg, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateSnapshotSettings checks the settings a profile can override,
+// other than retention
+func validateSnapshotSettings(cfg *Config) error {
 	for _, event := range cfg.SnapshotOn {
-		if !validEvents[event] {
-			return fmt.Errorf("invalid snapshot_on event: %s", event)
+		if !SnapshotEvents[event] {
+			return keyErrorf("snapshot_on", "invalid snapshot_on event: %s", event)
 		}
 	}
 
 	for _, event := range cfg.RestoreOn {
-		if !validEvents[event] {
-			return fmt.Errorf("invalid restore_on event: %s", event)
+		if !RestoreEvents[event] {
+			return keyErrorf("restore_on", "invalid restore_on event: %s", event)
+		}
+	}
+
+	for _, list := range []struct {
+		key      string
+		patterns []string
+	}{{"exclude", cfg.Exclude}, {"include", cfg.Include}} {
+		for _, pattern := range list.patterns {
+			if _, err := filepath.Match(pattern, ""); err != nil {
+				return keyErrorf(list.key, "invalid %s pattern %q: %w", list.key, pattern, err)
+			}
 		}
 	}
 
+	switch cfg.Compression {
+	case CompressionGzip, "":
+		// gzip.HuffmanOnly (-2) through gzip.BestCompression (9)
+		if cfg.CompressionLevel < -2 || cfg.CompressionLevel > 9 {
+			return keyErrorf("compression_level", "compression_level for gzip must be between -2 and 9")
+		}
+	case CompressionZstd:
+		if cfg.CompressionLevel < 0 || cfg.CompressionLevel > 22 {
+			return keyErrorf("compression_level", "compression_level for zstd must be 0 (default) or between 1 and 22")
+		}
+	case CompressionNone:
+		if cfg.CompressionLevel != 0 {
+			return keyErrorf("compression_level", "compression_level is not used with compression: none")
+		}
+	default:
+		return keyErrorf("compression", "invalid compression: %s", cfg.Compression)
+	}
+	return nil
+}
+
+// validateProfile checks the named profile's overrides and the settings
+// they result in, reporting errors against the profiles key
+func validateProfile(cfg *Config, name string) error {
+	if !profileName.MatchString(name) || name == DefaultProfile {
+		return keyErrorf("profiles", "invalid profile name %q", name)
+	}
+	if cfg.Profiles[name].Retention < KeepAll {
+		return keyErrorf("profiles", "profile %s: retention must be greater than 0, or %d to keep every snapshot", name, KeepAll)
+	}
+	merged, err := cfg.Profile(name)
+	if err != nil {
+		return err
+	}
+	if err := validateSnapshotSettings(merged); err != nil {
+		return &KeyError{Key: "profiles", Err: fmt.Errorf("profile %s: %w", name, err)}
+	}
 	return nil
 }
+
+// validateRemote checks the remote settings, if a remote is configured
+func validateRemote(r Remote) error {
+	if r.URL == "" {
+		if r.Endpoint != "" || r.Region != "" || r.PathStyle {
+			return keyErrorf("remote.url", "remote settings require remote.url")
+		}
+		return nil
+	}
+
+	bucket, _, ok, err := r.S3()
+	if err != nil {
+		return &KeyError{Key: "remote.url", Err: err}
+	}
+	if !ok {
+		if r.Endpoint != "" || r.Region != "" || r.PathStyle {
+			return keyErrorf("remote.url", "remote.endpoint, region and path_style only apply to s3 remotes")
+		}
+		return nil
+	}
+	if bucket == "" {
+		return keyErrorf("remote.url", "remote.url %s has no bucket", r.URL)
+	}
+	if r.Endpoint != "" {
+		e, err := url.Parse(r.Endpoint)
+		if err != nil || (e.Scheme != "http" && e.Scheme != "https") || e.Host == "" {
+			return keyErrorf("remote.endpoint", "invalid remote.endpoint: %s", r.Endpoint)
+		}
+	}
+	return nil
+}
+
+// S3 returns the bucket and key prefix of an s3:// remote URL, and
+// reports whether the URL is one. Other URLs are directory paths, which
+// may also be written as file:// URLs.
+func (r Remote) S3() (bucket, prefix string, ok bool, err error) {
+	if filepath.VolumeName(r.URL) != "" { // C:\share is a path, not a URL
+		return "", "", false, nil
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return "", "", false, fmt.Errorf("invalid remote.url: %w", err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return u.Host, strings.Trim(u.Path, "/"), true, nil
+	case "", "file":
+		return "", "", false, nil
+	default:
+		return "", "", false, fmt.Errorf("unsupported remote.url scheme: %s", u.Scheme)
+	}
+}
+
+// Encrypted reports whether new snapshots are encrypted
+func (c *Config) Encrypted() bool {
+	return c.Encryption != "" && c.Encryption != EncryptionNone
+}
@@ -1,13 +1,17 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 )
 
 func TestLoadConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
 	// Create test directory
 	if err := os.MkdirAll(".ignoregrets", 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %v", err)
@@ -28,12 +32,17 @@ func TestLoadConfig(t *testing.T) {
 
 	// Test loading custom config
 	customCfg := &Config{
+		Version:      Version,
 		Retention:    5,
 		SnapshotOn:   []string{"commit", "checkout"},
 		RestoreOn:    []string{"checkout"},
 		HooksEnabled: true,
 		Exclude:      []string{"*.log", "*.tmp"},
 		Include:      []string{".env", "config.local"},
+
+		ConflictPolicy:   ConflictOverwriteUnchanged,
+		Compression:      CompressionZstd,
+		CompressionLevel: 3,
 	}
 
 	if err := SaveConfig(customCfg); err != nil {
@@ -66,6 +75,213 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "extended events",
+			cfg: &Config{
+				Retention:    10,
+				SnapshotOn:   []string{"commit", "merge", "rewrite", "rebase", "push"},
+				RestoreOn:    []string{"checkout", "merge", "rewrite"},
+				HooksEnabled: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "snapshot-only event in restore_on",
+			cfg: &Config{
+				Retention:    10,
+				SnapshotOn:   []string{"commit"},
+				RestoreOn:    []string{"push"},
+				HooksEnabled: false,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid conflict policy",
+			cfg: &Config{
+				Retention:      10,
+				SnapshotOn:     []string{"commit"},
+				RestoreOn:      []string{"checkout"},
+				ConflictPolicy: "clobber",
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed exclude pattern",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Exclude:    []string{"*.log", "build[0-9"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid compression",
+			cfg: &Config{
+				Retention:   10,
+				SnapshotOn:  []string{"commit"},
+				RestoreOn:   []string{"checkout"},
+				Compression: "lz4",
+			},
+			wantErr: true,
+		},
+		{
+			name: "compression level out of range",
+			cfg: &Config{
+				Retention:        10,
+				SnapshotOn:       []string{"commit"},
+				RestoreOn:        []string{"checkout"},
+				Compression:      CompressionGzip,
+				CompressionLevel: 12,
+			},
+			wantErr: true,
+		},
+		{
+			name: "zstd default level",
+			cfg: &Config{
+				Retention:   10,
+				SnapshotOn:  []string{"commit"},
+				RestoreOn:   []string{"checkout"},
+				Compression: CompressionZstd,
+			},
+			wantErr: false,
+		},
+		{
+			name: "zstd level out of range",
+			cfg: &Config{
+				Retention:        10,
+				SnapshotOn:       []string{"commit"},
+				RestoreOn:        []string{"checkout"},
+				Compression:      CompressionZstd,
+				CompressionLevel: 23,
+			},
+			wantErr: true,
+		},
+		{
+			name: "age encryption without recipients",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Encryption: EncryptionAge,
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid age recipient",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Encryption: EncryptionAge,
+				Recipients: []string{"age1notakey"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "passphrase encryption",
+			cfg: &Config{
+				Retention:       10,
+				SnapshotOn:      []string{"commit"},
+				RestoreOn:       []string{"checkout"},
+				Encryption:      EncryptionPassphrase,
+				EncryptManifest: true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "encrypt_manifest without encryption",
+			cfg: &Config{
+				Retention:       10,
+				SnapshotOn:      []string{"commit"},
+				RestoreOn:       []string{"checkout"},
+				EncryptManifest: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "secret rules",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				SecretRules: []SecretRule{
+					{Name: "internal-token", Pattern: `itk_[a-z0-9]{32}`, Action: SecretExclude},
+					{Name: "private-key", Action: SecretOff},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid secret rule action",
+			cfg: &Config{
+				Retention:   10,
+				SnapshotOn:  []string{"commit"},
+				RestoreOn:   []string{"checkout"},
+				SecretRules: []SecretRule{{Name: "token", Pattern: `tok_`, Action: "delete"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid secret rule pattern",
+			cfg: &Config{
+				Retention:   10,
+				SnapshotOn:  []string{"commit"},
+				RestoreOn:   []string{"checkout"},
+				SecretRules: []SecretRule{{Name: "token", Pattern: `tok_(`, Action: SecretWarn}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "secret rule without a name",
+			cfg: &Config{
+				Retention:   10,
+				SnapshotOn:  []string{"commit"},
+				RestoreOn:   []string{"checkout"},
+				SecretRules: []SecretRule{{Pattern: `tok_`, Action: SecretWarn}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "s3 remote",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Remote:     Remote{URL: "s3://team-snapshots/repo", Endpoint: "http://localhost:9000", PathStyle: true},
+			},
+			wantErr: false,
+		},
+		{
+			name: "s3 remote without bucket",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Remote:     Remote{URL: "s3:///repo"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "endpoint for directory remote",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Remote:     Remote{URL: "/mnt/share/snapshots", Endpoint: "http://localhost:9000"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported remote scheme",
+			cfg: &Config{
+				Retention:  10,
+				SnapshotOn: []string{"commit"},
+				RestoreOn:  []string{"checkout"},
+				Remote:     Remote{URL: "ftp://example.com/snapshots"},
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid retention",
 			cfg: &Config{
@@ -108,7 +324,65 @@ func TestValidateConfig(t *testing.T) {
 	}
 }
 
+func TestProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Exclude = []string{"*.log"}
+	cfg.CompressionLevel = 9
+	cfg.Profiles = map[string]Profile{
+		"env":   {Exclude: []string{"*"}, Include: []string{".env*"}, Retention: KeepAll, SnapshotOn: []string{}},
+		"build": {Compression: CompressionZstd, Retention: 2},
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		t.Fatalf("Expected valid profiles, got %v", err)
+	}
+	if names := cfg.ProfileNames(); !reflect.DeepEqual(names, []string{"build", "env"}) {
+		t.Errorf("Unexpected profile names %v", names)
+	}
+
+	env, err := cfg.Profile("env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(env.Include, []string{".env*"}) || env.Retention != KeepAll || len(env.SnapshotOn) != 0 {
+		t.Errorf("Expected the env overrides, got %+v", env)
+	}
+	if env.Compression != CompressionGzip || env.CompressionLevel != 9 || !reflect.DeepEqual(env.RestoreOn, cfg.RestoreOn) {
+		t.Errorf("Expected unset settings to be inherited, got %+v", env)
+	}
+	build, err := cfg.Profile("build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if build.Compression != CompressionZstd || build.CompressionLevel != 0 || !reflect.DeepEqual(build.Exclude, []string{"*.log"}) {
+		t.Errorf("Expected zstd at its default level with inherited patterns, got %+v", build)
+	}
+	if top, _ := cfg.Profile(DefaultProfile); top != cfg {
+		t.Error("Expected the default profile to be the top-level settings")
+	}
+	if _, err := cfg.Profile("docs"); err == nil || !strings.Contains(err.Error(), "build, env") {
+		t.Errorf("Expected an unknown profile error listing the profiles, got %v", err)
+	}
+
+	for name, p := range map[string]Profile{
+		"default":   {},
+		"bad/name":  {},
+		"retention": {Retention: -2},
+		"events":    {SnapshotOn: []string{"save"}},
+		"codec":     {Compression: "lz4"},
+		"level":     {Compression: CompressionNone, CompressionLevel: 3},
+	} {
+		invalid := DefaultConfig()
+		invalid.Profiles = map[string]Profile{name: p}
+		var keyErr *KeyError
+		if err := ValidateConfig(invalid); !errors.As(err, &keyErr) || keyErr.Key != "profiles" {
+			t.Errorf("Expected a profiles error for %s, got %v", name, err)
+		}
+	}
+}
+
 func TestSaveConfig(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
 	// Create test directory
 	if err := os.MkdirAll(".ignoregrets", 0755); err != nil {
 		t.Fatalf("Failed to create test directory: %v", err)
@@ -117,12 +391,16 @@ func TestSaveConfig(t *testing.T) {
 
 	// Test saving and loading config
 	cfg := &Config{
+		Version:      Version,
 		Retention:    5,
 		SnapshotOn:   []string{"commit"},
 		RestoreOn:    []string{"checkout"},
 		HooksEnabled: true,
 		Exclude:      []string{"*.log"},
 		Include:      []string{".env"},
+
+		ConflictPolicy: ConflictPrompt,
+		Compression:    CompressionNone,
 	}
 
 	if err := SaveConfig(cfg); err != nil {
@@ -0,0 +1,550 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration layers, from lowest to highest precedence
+const (
+	LayerDefault = "default" // built into ignoregrets
+	LayerGlobal  = "global"  // the user's config file, see GlobalPath
+	LayerProject = "project" // .ignoregrets.yaml, committed with the project
+	LayerLocal   = "local"   // .ignoregrets/config.yaml, for this clone only
+	LayerEnv     = "env"     // IGNOREGRETS_* environment variables
+	LayerFlag    = "flag"    // settings given on the command line
+)
+
+// EnvPrefix starts the environment variables that set config keys, e.g.
+// IGNOREGRETS_RETENTION or IGNOREGRETS_REMOTE_URL
+const EnvPrefix = "IGNOREGRETS_"
+
+// Repository config files, relative to the repository root
+var (
+	ProjectPath = ".ignoregrets.yaml"
+	LocalPath   = filepath.Join(".ignoregrets", "config.yaml")
+)
+
+// GlobalPath returns the path of the user's config file,
+// $XDG_CONFIG_HOME/ignoregrets/config.yaml or the platform's equivalent
+func GlobalPath() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", fmt.Errorf("failed to find config directory: %w", err)
+		}
+	}
+	return filepath.Join(dir, "ignoregrets", "config.yaml"), nil
+}
+
+// Origin tells which layer the value of a config key came from
+type Origin struct {
+	Layer string
+
+	// Source is the file, environment variable or flag that set the
+	// value; it is empty for defaults
+	Source string
+
+	// Line and Column locate the key in a config file
+	Line, Column int
+}
+
+func (o Origin) String() string {
+	if o.Source == "" {
+		return o.Layer
+	}
+	return o.Layer + ":" + o.Source
+}
+
+// Origins maps config keys to the origin of their values
+type Origins map[string]Origin
+
+// OverrideFlag is the command line flag that sets config keys
+const OverrideFlag = "--set"
+
+// CheckOverrides checks that each of settings is key=value for a known
+// key, as Load expects its overrides
+func CheckOverrides(settings []string) error {
+	for _, s := range settings {
+		name, _, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("invalid setting %q: expected key=value", s)
+		}
+		if _, err := lookupKey(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Load merges the configuration layers: the defaults, the global,
+// project and local config files, the environment, and overrides, which
+// are key=value settings from the command line. It reports which layer
+// each key's value came from.
+func Load(overrides ...string) (*Config, Origins, error) {
+	return load(nil, overrides...)
+}
+
+// load is Load with the config files in pending read from memory instead
+// of disk, keyed by path
+func load(pending map[string][]byte, overrides ...string) (*Config, Origins, error) {
+	if err := CheckOverrides(overrides); err != nil {
+		return nil, nil, err
+	}
+	cfg := DefaultConfig()
+	origins := make(Origins, len(keys))
+	for _, k := range keys {
+		origins[k.name] = Origin{Layer: LayerDefault}
+	}
+
+	global, err := GlobalPath()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range []struct{ layer, path string }{
+		{LayerGlobal, global},
+		{LayerProject, ProjectPath},
+		{LayerLocal, LocalPath},
+	} {
+		if data, ok := pending[file.path]; ok {
+			err = mergeFile(cfg, origins, file.layer, file.path, data)
+		} else {
+			err = loadFile(cfg, origins, file.layer, file.path)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, k := range keys {
+		name := EnvName(k.name)
+		value := os.Getenv(name)
+		if value == "" {
+			continue
+		}
+		if err := k.add(cfg, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %w", name, err)
+		}
+		origins[k.name] = Origin{Layer: LayerEnv, Source: name}
+	}
+
+	for _, s := range overrides {
+		name, value, _ := strings.Cut(s, "=")
+		k, _ := lookupKey(name)
+		if err := k.add(cfg, value); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s %s: %w", OverrideFlag, name, err)
+		}
+		origins[k.name] = Origin{Layer: LayerFlag, Source: OverrideFlag}
+	}
+
+	applyDefaults(cfg)
+	return cfg, origins, nil
+}
+
+// loadFile merges the config file at path, if it exists, into cfg
+func loadFile(cfg *Config, origins Origins, layer, path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return mergeFile(cfg, origins, layer, path, data)
+}
+
+// mergeFile merges data, the contents of the config file at path, into cfg
+func mergeFile(cfg *Config, origins Origins, layer, path string, data []byte) error {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return syntaxError(path, err)
+	}
+	if len(doc.Content) == 0 {
+		return nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		if root.Tag == "!!null" {
+			return nil // only comments
+		}
+		return fmt.Errorf("%s:%d:%d: expected a mapping of config keys", path, root.Line, root.Column)
+	}
+
+	if _, err := migrate(root, path); err != nil {
+		return err
+	}
+
+	// Check every key and value before merging any of them
+	var errs []error
+	checkNode(root, reflect.TypeOf(Config{}), "", func(node *yaml.Node, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s:%d:%d: %s", path, node.Line, node.Column, fmt.Sprintf(format, args...)))
+	})
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	below := make(map[string]reflect.Value)
+	for name := range combinedKeys {
+		k, _ := lookupKey(name)
+		below[name] = reflect.ValueOf(k.field(cfg).Interface())
+	}
+	if err := doc.Decode(cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	for name, v := range below {
+		k, _ := lookupKey(name)
+		if mappingValue(root, name) != nil {
+			k.combine(cfg, v)
+		}
+	}
+
+	var record func(node *yaml.Node, prefix string)
+	record = func(node *yaml.Node, prefix string) {
+		if node.Kind != yaml.MappingNode {
+			return
+		}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode := node.Content[i]
+			name := prefix + keyNode.Value
+			if _, ok := origins[name]; ok {
+				origins[name] = Origin{Layer: layer, Source: path, Line: keyNode.Line, Column: keyNode.Column}
+			} else {
+				record(node.Content[i+1], name+".")
+			}
+		}
+	}
+	record(root, "")
+	return nil
+}
+
+// key is a config key: the YAML name of a Config field, or of a field of
+// a nested struct like remote, joined with dots
+type key struct {
+	name  string
+	index []int
+}
+
+// keys lists the config keys in file order
+var keys = configKeys(reflect.TypeOf(Config{}), "", nil)
+
+func configKeys(t reflect.Type, prefix string, index []int) []key {
+	var ks []key
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" || name == "version" {
+			continue // version describes the file, not a setting
+		}
+		fieldIndex := append(append([]int(nil), index...), i)
+		if field.Type.Kind() == reflect.Struct {
+			ks = append(ks, configKeys(field.Type, prefix+name+".", fieldIndex)...)
+			continue
+		}
+		ks = append(ks, key{name: prefix + name, index: fieldIndex})
+	}
+	return ks
+}
+
+// Keys returns the names of the config keys
+func Keys() []string {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k.name
+	}
+	return names
+}
+
+// EnvName returns the environment variable that sets a config key
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, ".", "_"))
+}
+
+func lookupKey(name string) (key, error) {
+	for _, k := range keys {
+		if k.name == name {
+			return k, nil
+		}
+	}
+	return key{}, fmt.Errorf("unknown config key: %s", name)
+}
+
+// field returns the field of cfg the key names
+func (k key) field(cfg *Config) reflect.Value {
+	return reflect.ValueOf(cfg).Elem().FieldByIndex(k.index)
+}
+
+// parse parses text as a YAML value for the key, rejecting unknown
+// fields. Lists of strings may also be given separated by commas.
+func (k key) parse(text string) (reflect.Value, error) {
+	t := reflect.ValueOf(&Config{}).Elem().FieldByIndex(k.index).Type()
+	v := reflect.New(t)
+	dec := yaml.NewDecoder(strings.NewReader(text))
+	dec.KnownFields(true)
+	if err := dec.Decode(v.Interface()); err != nil && err != io.EOF {
+		if t.Kind() != reflect.Slice || t.Elem().Kind() != reflect.String {
+			if t.Kind() == reflect.Slice {
+				return reflect.Value{}, errors.New(yamlMessage(err))
+			}
+			return reflect.Value{}, fmt.Errorf("%q is not a valid %s", text, typeName(t))
+		}
+		var items []string
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Elem().Set(reflect.ValueOf(items))
+	}
+	return v.Elem(), nil
+}
+
+// combinedKeys are the lists that layers add to rather than replace, so
+// that patterns set for every project in the global config are kept when
+// a project sets its own. Other keys, lists included, take the value of
+// the highest layer that sets them.
+var combinedKeys = map[string]bool{"exclude": true, "include": true}
+
+// add parses text and sets the key in cfg, or adds to it for combinedKeys
+func (k key) add(cfg *Config, text string) error {
+	v, err := k.parse(text)
+	if err != nil {
+		return err
+	}
+	below := k.field(cfg).Interface()
+	k.field(cfg).Set(v)
+	if combinedKeys[k.name] {
+		k.combine(cfg, reflect.ValueOf(below))
+	}
+	return nil
+}
+
+// combine puts the items of below, the list of the lower layers, before
+// the list now set for the key in cfg, leaving out duplicates
+func (k key) combine(cfg *Config, below reflect.Value) {
+	field := k.field(cfg)
+	items := []string{}
+	seen := make(map[string]bool)
+	for _, list := range [][]string{below.Interface().([]string), field.Interface().([]string)} {
+		for _, item := range list {
+			if !seen[item] {
+				seen[item] = true
+				items = append(items, item)
+			}
+		}
+	}
+	field.Set(reflect.ValueOf(items))
+}
+
+// typeName describes a value type in error messages
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int:
+		return "number"
+	case reflect.Slice:
+		return "list"
+	case reflect.Map:
+		return "mapping"
+	default:
+		return t.String()
+	}
+}
+
+// Get returns the value of a config key in cfg as YAML, with lists on
+// one line
+func Get(cfg *Config, name string) (string, error) {
+	k, err := lookupKey(name)
+	if err != nil {
+		return "", err
+	}
+	var node yaml.Node
+	if err := node.Encode(k.field(cfg).Interface()); err != nil {
+		return "", err
+	}
+	flowStyle(&node)
+	out, err := yaml.Marshal(&node)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// flowStyle formats the lists and mappings under node on one line
+func flowStyle(node *yaml.Node) {
+	if node.Kind == yaml.SequenceNode || node.Kind == yaml.MappingNode {
+		node.Style = yaml.FlowStyle
+	}
+	for _, child := range node.Content {
+		flowStyle(child)
+	}
+}
+
+// Set sets a config key to a YAML value in the config file at path,
+// keeping the rest of the file and its comments. The file is left
+// unchanged if the merged configuration would not be valid.
+func Set(path, name, value string) error {
+	k, err := lookupKey(name)
+	if err != nil {
+		return err
+	}
+	v, err := k.parse(value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", name, err)
+	}
+	var valueNode yaml.Node
+	if err := valueNode.Encode(v.Interface()); err != nil {
+		return err
+	}
+
+	_, doc, header, err := readConfigDoc(path)
+	if err != nil {
+		return err
+	}
+	// Edit the file in the current format
+	if _, err := migrate(doc.Content[0], path); err != nil {
+		return err
+	}
+
+	node := doc.Content[0]
+	parts := strings.Split(name, ".")
+	for i, part := range parts {
+		if node.Kind != yaml.MappingNode {
+			return fmt.Errorf("failed to set %s: %s is not a mapping", name, strings.Join(parts[:i], "."))
+		}
+		child := mappingValue(node, part)
+		if i == len(parts)-1 {
+			if child != nil {
+				valueNode.LineComment = child.LineComment
+				*child = valueNode
+			} else {
+				node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, &valueNode)
+			}
+			break
+		}
+		if child == nil {
+			child = &yaml.Node{Kind: yaml.MappingNode}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: part}, child)
+		} else if child.Kind == yaml.ScalarNode && child.Tag == "!!null" {
+			*child = yaml.Node{Kind: yaml.MappingNode}
+		}
+		node = child
+	}
+
+	// Check the merged configuration before the file is touched
+	data, err := encodeConfigDoc(doc, header)
+	if err != nil {
+		return err
+	}
+	cfg, origins, err := load(map[string][]byte{path: data})
+	if err == nil {
+		err = Validate(cfg, origins)
+	}
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(path, data)
+}
+
+// readConfigDoc reads the config file at path, if it exists, as a YAML
+// tree whose root is a mapping. The leading comments of a file without
+// settings, like the one CreateLocalConfig writes, are returned as header.
+func readConfigDoc(path string) (old []byte, doc *yaml.Node, header []byte, err error) {
+	old, err = os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	doc = &yaml.Node{}
+	if err := yaml.Unmarshal(old, doc); err != nil {
+		return nil, nil, nil, syntaxError(path, err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Tag == "!!null" {
+		header = bytes.TrimSpace(old)
+		if len(header) > 0 {
+			header = append(header, '\n')
+		}
+		doc = &yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if root := doc.Content[0]; root.Kind != yaml.MappingNode {
+		return nil, nil, nil, fmt.Errorf("%s:%d:%d: expected a mapping of config keys", path, root.Line, root.Column)
+	}
+	return old, doc, header, nil
+}
+
+// writeConfigDoc writes a YAML tree read by readConfigDoc back to path
+func writeConfigDoc(path string, doc *yaml.Node, header []byte) error {
+	data, err := encodeConfigDoc(doc, header)
+	if err != nil {
+		return err
+	}
+	return writeConfigFile(path, data)
+}
+
+// encodeConfigDoc formats a YAML tree read by readConfigDoc, after header
+func encodeConfigDoc(doc *yaml.Node, header []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(header)
+	enc := yaml.NewEncoder(buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(doc); err != nil {
+		return nil, fmt.Errorf("failed to marshal config: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// mappingValue returns the value of name in a mapping node, or nil
+func mappingValue(node *yaml.Node, name string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == name {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+func writeConfigFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	return nil
+}
+
+// CreateLocalConfig writes a local config file listing the defaults as
+// comments, if there is none. Settings left commented out are inherited
+// from the other layers.
+func CreateLocalConfig() error {
+	if _, err := os.Stat(LocalPath); !os.IsNotExist(err) {
+		return err
+	}
+
+	defaults := DefaultConfig()
+	defaults.Version = 0
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(defaults); err != nil {
+		return fmt.Errorf("failed to marshal config: %w", err)
+	}
+
+	var out strings.Builder
+	out.WriteString("# Settings for this clone only. They override the global config and\n")
+	out.WriteString("# .ignoregrets.yaml; uncomment a line to change it here.\n")
+	out.WriteString("# See 'ignoregrets config list --show-origin'.\n")
+	fmt.Fprintf(&out, "version: %d\n\n", Version)
+	for _, line := range strings.SplitAfter(buf.String(), "\n") {
+		if line != "" {
+			out.WriteString("# " + line)
+		}
+	}
+	return writeConfigFile(LocalPath, []byte(out.String()))
+}
@@ -0,0 +1,207 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// chdirTemp switches to an empty directory with an empty global config
+// directory, and returns the global config path
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(oldDir) })
+
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	return filepath.Join(home, "ignoregrets", "config.yaml")
+}
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	global := chdirTemp(t)
+
+	writeFile(t, global, "retention: 20\nexclude: [node_modules]\nencryption: passphrase\n")
+	writeFile(t, ProjectPath, "retention: 15\nexclude: [dist, node_modules]\nremote:\n  url: s3://team/snapshots\n")
+	writeFile(t, LocalPath, "# local settings\ncompression: zstd\n")
+	t.Setenv("IGNOREGRETS_REMOTE_REGION", "eu-west-1")
+	t.Setenv("IGNOREGRETS_INCLUDE", ".env, .env.local")
+	cfg, origins, err := Load("compression=none", "include=.env.test")
+	if err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+	if cfg.Retention != 15 || cfg.Encryption != EncryptionPassphrase || cfg.Compression != CompressionNone {
+		t.Errorf("Unexpected merged config %+v", cfg)
+	}
+	// Patterns add to those of the layers below
+	if !reflect.DeepEqual(cfg.Exclude, []string{"node_modules", "dist"}) || !reflect.DeepEqual(cfg.Include, []string{".env", ".env.local", ".env.test"}) {
+		t.Errorf("Unexpected patterns: exclude %v, include %v", cfg.Exclude, cfg.Include)
+	}
+	if cfg.Remote.URL != "s3://team/snapshots" || cfg.Remote.Region != "eu-west-1" {
+		t.Errorf("Unexpected remote %+v", cfg.Remote)
+	}
+
+	want := map[string]string{
+		"retention":     "project:" + ProjectPath,
+		"exclude":       "project:" + ProjectPath,
+		"include":       "flag:--set",
+		"remote.url":    "project:" + ProjectPath,
+		"remote.region": "env:IGNOREGRETS_REMOTE_REGION",
+		"compression":   "flag:--set",
+		"jobs":          "default",
+	}
+	for key, origin := range want {
+		if got := origins[key].String(); got != origin {
+			t.Errorf("Expected %s from %s, got %s", key, origin, got)
+		}
+	}
+
+	if _, _, err := Load("retension=5"); err == nil {
+		t.Error("Expected an error for an unknown key")
+	}
+	if err := CheckOverrides([]string{"retention"}); err == nil {
+		t.Error("Expected an error for a setting without a value")
+	}
+	t.Setenv("IGNOREGRETS_JOBS", "many")
+	if _, _, err := Load(); err == nil || !strings.Contains(err.Error(), "IGNOREGRETS_JOBS") {
+		t.Errorf("Expected an error naming IGNOREGRETS_JOBS, got %v", err)
+	}
+}
+
+func TestGet(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.SecretRules = []SecretRule{{Name: "token", Pattern: "tk_[a-z]+", Action: SecretWarn}}
+	tests := map[string]string{
+		"retention":     "10",
+		"snapshot_on":   "[commit]",
+		"identity_file": `""`,
+		"remote.url":    `""`,
+		"secret_rules":  "[{name: token, pattern: 'tk_[a-z]+', action: warn}]",
+	}
+	for key, want := range tests {
+		got, err := Get(cfg, key)
+		if err != nil || got != want {
+			t.Errorf("Get(%s) = %q, %v; want %q", key, got, err, want)
+		}
+	}
+	if _, err := Get(cfg, "remote"); err == nil {
+		t.Error("Expected an error for a key that is not a value")
+	}
+}
+
+func TestSet(t *testing.T) {
+	chdirTemp(t)
+
+	writeFile(t, LocalPath, "# Keep snapshots longer\nretention: 5 # per commit\nexclude:\n  - \"*.log\"\n")
+	if err := Set(LocalPath, "retention", "30"); err != nil {
+		t.Fatalf("Failed to set retention: %v", err)
+	}
+	if err := Set(LocalPath, "remote.url", "/mnt/share"); err != nil {
+		t.Fatalf("Failed to set remote.url: %v", err)
+	}
+	if err := Set(LocalPath, "exclude", "node_modules,dist"); err != nil {
+		t.Fatalf("Failed to set exclude: %v", err)
+	}
+
+	data, err := os.ReadFile(LocalPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"# Keep snapshots longer\n", "retention: 30 # per commit\n", "remote:\n  url: /mnt/share\n"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("Expected the config file to contain %q, got:\n%s", want, data)
+		}
+	}
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Retention != 30 || !reflect.DeepEqual(cfg.Exclude, []string{"node_modules", "dist"}) {
+		t.Errorf("Unexpected config after set: %+v", cfg)
+	}
+
+	// Invalid values leave the file alone
+	for _, tt := range [][2]string{{"retention", "ten"}, {"jobs", "-1"}, {"compression", "lz4"}, {"retension", "5"}} {
+		if err := Set(LocalPath, tt[0], tt[1]); err == nil {
+			t.Errorf("Expected an error setting %s to %s", tt[0], tt[1])
+		}
+	}
+	if after, _ := os.ReadFile(LocalPath); string(after) != string(data) {
+		t.Errorf("Expected failed sets to leave the file unchanged, got:\n%s", after)
+	}
+
+	// A new file is created, and removed again if invalid
+	if err := Set(ProjectPath, "compression", "lz4"); err == nil {
+		t.Error("Expected an error for an invalid compression")
+	}
+	if _, err := os.Stat(ProjectPath); !os.IsNotExist(err) {
+		t.Error("Expected no project config to be left behind")
+	}
+}
+
+func TestSetValidatesBeforeWriting(t *testing.T) {
+	global := chdirTemp(t)
+
+	// The value is valid on its own, but not without encryption
+	err := Set(global, "encrypt_manifest", "true")
+	if err == nil || !strings.Contains(err.Error(), "requires encryption") {
+		t.Errorf("Expected an encrypt_manifest error, got %v", err)
+	}
+	// Nothing is written, not even the config directory
+	if _, err := os.Stat(filepath.Dir(global)); !os.IsNotExist(err) {
+		t.Errorf("Expected no global config directory to be created, got %v", err)
+	}
+
+	// Errors point into the file as it would have been written
+	writeFile(t, LocalPath, "# Local settings\nretention: 5\n")
+	err = Set(LocalPath, "encrypt_manifest", "true")
+	if err == nil || !strings.HasPrefix(err.Error(), LocalPath+":") {
+		t.Errorf("Expected an error located in %s, got %v", LocalPath, err)
+	}
+	if data, _ := os.ReadFile(LocalPath); string(data) != "# Local settings\nretention: 5\n" {
+		t.Errorf("Expected the local config to be unchanged, got %q", data)
+	}
+}
+
+func TestCreateLocalConfig(t *testing.T) {
+	global := chdirTemp(t)
+	writeFile(t, global, "retention: 20\n")
+
+	if err := CreateLocalConfig(); err != nil {
+		t.Fatalf("Failed to create local config: %v", err)
+	}
+	cfg, origins, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The commented-out defaults don't shadow the global config
+	if cfg.Retention != 20 || origins["retention"].Layer != LayerGlobal {
+		t.Errorf("Expected retention 20 from the global config, got %d from %s", cfg.Retention, origins["retention"])
+	}
+
+	writeFile(t, LocalPath, "retention: 3\n")
+	if err := CreateLocalConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(LocalPath); string(data) != "retention: 3\n" {
+		t.Errorf("Expected an existing local config to be kept, got %q", data)
+	}
+}
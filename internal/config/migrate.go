@@ -0,0 +1,104 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Version is the config file format this version of ignoregrets reads
+// and writes. Files without a version key are version 0.
+const Version = 1
+
+// ErrNewerVersion is returned for config files and manifests written by a
+// newer version of ignoregrets
+var ErrNewerVersion = errors.New("newer than this version of ignoregrets supports; upgrade ignoregrets")
+
+// migration upgrades a config file from one version to the next
+type migration struct {
+	Description string
+	Apply       func(root *yaml.Node) error
+}
+
+// migrations holds the step from each version to the one after it. A
+// change to the format adds a step here and bumps Version.
+var migrations = map[int]migration{
+	0: {
+		Description: "add the version key",
+		Apply:       func(root *yaml.Node) error { return nil },
+	},
+}
+
+// migrate upgrades the mapping root of the config file at path to
+// Version in place, and returns the version it had
+func migrate(root *yaml.Node, path string) (int, error) {
+	from := 0
+	versionNode := mappingValue(root, "version")
+	if versionNode != nil {
+		v, err := strconv.Atoi(versionNode.Value)
+		if err != nil || versionNode.Kind != yaml.ScalarNode || v < 0 {
+			return 0, fmt.Errorf("%s:%d:%d: invalid config version %q", path, versionNode.Line, versionNode.Column, versionNode.Value)
+		}
+		from = v
+	}
+	if from > Version {
+		return from, fmt.Errorf("%s:%d:%d: config version %d is %w", path, versionNode.Line, versionNode.Column, from, ErrNewerVersion)
+	}
+
+	for v := from; v < Version; v++ {
+		step, ok := migrations[v]
+		if !ok {
+			return from, fmt.Errorf("%s: no migration from config version %d", path, v)
+		}
+		if err := step.Apply(root); err != nil {
+			return from, fmt.Errorf("%s: failed to %s: %w", path, step.Description, err)
+		}
+	}
+
+	value := strconv.Itoa(Version)
+	if versionNode != nil {
+		versionNode.Value = value
+		return from, nil
+	}
+	// Put the version first, where readers look for it
+	keyNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: value}
+	root.Content = append([]*yaml.Node{keyNode, valueNode}, root.Content...)
+	return from, nil
+}
+
+// Migrate upgrades the config file at path to Version on disk, keeping
+// its comments, and returns the version it had. A file that is missing,
+// has no settings or is already current is left alone; dryRun only
+// reports the version.
+func Migrate(path string, dryRun bool) (int, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return Version, nil
+	}
+	old, doc, header, err := readConfigDoc(path)
+	if err != nil {
+		return 0, err
+	}
+	if len(header) > 0 || len(bytes.TrimSpace(old)) == 0 {
+		return Version, nil
+	}
+	from, err := migrate(doc.Content[0], path)
+	if err != nil || from == Version || dryRun {
+		return from, err
+	}
+	return from, writeConfigDoc(path, doc, header)
+}
+
+// Paths returns the config files of the global, project and local
+// layers, whether or not they exist
+func Paths() ([]string, error) {
+	global, err := GlobalPath()
+	if err != nil {
+		return nil, err
+	}
+	return []string{global, ProjectPath, LocalPath}, nil
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMigrate(t *testing.T) {
+	chdirTemp(t)
+
+	old := "# team settings\nretention: 5 # per commit\n"
+	writeFile(t, ProjectPath, old)
+
+	// Old files are read without being changed
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("Failed to load a version 0 file: %v", err)
+	}
+	if cfg.Retention != 5 || cfg.Version != Version {
+		t.Errorf("Expected retention 5 at version %d, got %d at version %d", Version, cfg.Retention, cfg.Version)
+	}
+	if data, _ := os.ReadFile(ProjectPath); string(data) != old {
+		t.Errorf("Expected loading to leave the file alone, got:\n%s", data)
+	}
+
+	if from, err := Migrate(ProjectPath, true); err != nil || from != 0 {
+		t.Errorf("Expected a dry run to report version 0, got %d, %v", from, err)
+	}
+	if data, _ := os.ReadFile(ProjectPath); string(data) != old {
+		t.Errorf("Expected a dry run to leave the file alone, got:\n%s", data)
+	}
+
+	if from, err := Migrate(ProjectPath, false); err != nil || from != 0 {
+		t.Fatalf("Expected to migrate from version 0, got %d, %v", from, err)
+	}
+	data, err := os.ReadFile(ProjectPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "version: 1\n# team settings\nretention: 5 # per commit\n"
+	if string(data) != want {
+		t.Errorf("Expected migrated file %q, got %q", want, data)
+	}
+	if from, err := Migrate(ProjectPath, false); err != nil || from != Version {
+		t.Errorf("Expected a current file to be left alone, got %d, %v", from, err)
+	}
+
+	// Missing files and files of only comments are left alone
+	if _, err := Migrate(LocalPath, false); err != nil {
+		t.Errorf("Failed to skip a missing file: %v", err)
+	}
+	writeFile(t, LocalPath, "# retention: 10\n")
+	if _, err := Migrate(LocalPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(LocalPath); string(data) != "# retention: 10\n" {
+		t.Errorf("Expected a file of comments to be left alone, got %q", data)
+	}
+}
+
+func TestMigrateNewer(t *testing.T) {
+	chdirTemp(t)
+
+	writeFile(t, ProjectPath, "version: 99\nretention: 5\n")
+	for name, err := range map[string]error{
+		"load":     func() error { _, err := LoadConfig(); return err }(),
+		"validate": ValidateFile(ProjectPath),
+		"set":      Set(ProjectPath, "retention", "6"),
+		"migrate":  func() error { _, err := Migrate(ProjectPath, false); return err }(),
+	} {
+		if !errors.Is(err, ErrNewerVersion) || !strings.HasPrefix(err.Error(), ProjectPath+":1:10: config version 99") {
+			t.Errorf("Expected %s to reject version 99 with its position, got %v", name, err)
+		}
+	}
+
+	writeFile(t, ProjectPath, "version: two\n")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "invalid config version") {
+		t.Errorf("Expected an invalid version error, got %v", err)
+	}
+}
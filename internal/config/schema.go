@@ -0,0 +1,153 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// descriptions documents each config key in the JSON Schema
+var descriptions = map[string]string{
+	"version":              "Format of the file; 'ignoregrets config migrate' upgrades older files",
+	"retention":            "Snapshots to keep per commit",
+	"snapshot_on":          "Git events that take a snapshot",
+	"restore_on":           "Git events that restore the snapshot of the new commit",
+	"hooks_enabled":        "Install Git hooks with 'ignoregrets init'",
+	"exclude":              "File name patterns to leave out of snapshots, added to those of lower config layers",
+	"include":              "File name patterns to snapshot even if excluded, added to those of lower config layers",
+	"conflict_policy":      "How hook restores treat existing files that differ from the snapshot",
+	"compression":          "Codec for new snapshots",
+	"compression_level":    "Codec level; 0 uses the default (gzip: -2..9, zstd: 1..22)",
+	"jobs":                 "Workers for hashing and archiving; 0 uses one per CPU",
+	"encryption":           "Encryption of new snapshots",
+	"recipients":           "age X25519 public keys (age1...) to encrypt to with encryption: age",
+	"identity_file":        "age identity file that decrypts snapshots",
+	"encrypt_manifest":     "Also encrypt the manifest sidecars",
+	"secret_rules":         "Extra secret patterns; a rule named like a built-in one replaces it",
+	"secret_rules.name":    "Rule name, shown in warnings and manifests",
+	"secret_rules.pattern": "Regular expression matched against each line",
+	"secret_rules.action":  "What to do with files that match",
+	"skip_secret_scan":     "Turn off secret scanning",
+	"remote":               "Where push and pull sync snapshots",
+	"remote.url":           "s3://bucket/prefix, or a directory such as /mnt/share/snapshots",
+	"remote.endpoint":      "S3-compatible endpoint URL (default: AWS)",
+	"remote.region":        "S3 region (default: us-east-1)",
+	"remote.path_style":    "Put the bucket in the URL path, as most self-hosted services need",
+	"store_path":           "Keep snapshots outside the repository, in a directory per repository under this one",
+
+	"profiles":                   "Named kinds of snapshot, selected with --profile; unset settings keep the top-level value",
+	"profiles.retention":         "Snapshots of the profile to keep per commit; -1 keeps them all",
+	"profiles.snapshot_on":       "Git events that take a snapshot of the profile",
+	"profiles.restore_on":        "Git events that restore the profile's snapshot of the new commit",
+	"profiles.exclude":           "File name patterns to leave out of the profile's snapshots",
+	"profiles.include":           "File name patterns to snapshot with the profile even if excluded",
+	"profiles.compression":       "Codec for the profile's snapshots",
+	"profiles.compression_level": "Codec level; 0 uses the default",
+}
+
+// enums lists the valid values of string keys, and of the items of
+// string lists
+var enums = map[string][]string{
+	"snapshot_on":         sortedKeys(SnapshotEvents),
+	"restore_on":          sortedKeys(RestoreEvents),
+	"conflict_policy":     sortedKeys(conflictPolicies),
+	"compression":         {CompressionGzip, CompressionZstd, CompressionNone},
+	"encryption":          {EncryptionNone, EncryptionAge, EncryptionPassphrase},
+	"secret_rules.action": sortedKeys(secretActions),
+
+	"profiles.snapshot_on": sortedKeys(SnapshotEvents),
+	"profiles.restore_on":  sortedKeys(RestoreEvents),
+	"profiles.compression": {CompressionGzip, CompressionZstd, CompressionNone},
+}
+
+// Bounds of integer keys
+var (
+	minimums = map[string]int{
+		"version": 0, "retention": 1, "compression_level": -2, "jobs": 0,
+		"profiles.retention": KeepAll, "profiles.compression_level": -2,
+	}
+	maximums = map[string]int{"version": Version, "compression_level": 22, "profiles.compression_level": 22}
+)
+
+// required lists the fields the items of list keys must have
+var required = map[string][]string{
+	"secret_rules": {"name", "action"},
+}
+
+// Schema returns a JSON Schema of the config files, for editors
+func Schema() ([]byte, error) {
+	schema := schemaFor(reflect.TypeOf(Config{}), "")
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "ignoregrets configuration"
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// schemaFor returns the schema of a value of type t for the key name
+func schemaFor(t reflect.Type, name string) map[string]any {
+	schema := make(map[string]any)
+	switch t.Kind() {
+	case reflect.Struct:
+		properties := make(map[string]any)
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if tag == "" || tag == "-" {
+				continue
+			}
+			key := tag
+			if name != "" {
+				key = name + "." + tag
+			}
+			property := schemaFor(field.Type, key)
+			if description := descriptions[key]; description != "" {
+				property["description"] = description
+			}
+			properties[tag] = property
+		}
+		schema["type"] = "object"
+		schema["properties"] = properties
+		schema["additionalProperties"] = false
+		if fields, ok := required[name]; ok {
+			schema["required"] = fields
+		}
+	case reflect.Map:
+		// Mappings are keyed by name, like profiles
+		schema["type"] = "object"
+		schema["propertyNames"] = map[string]any{"pattern": profileName.String()}
+		schema["additionalProperties"] = schemaFor(t.Elem(), name)
+	case reflect.Slice:
+		schema["type"] = "array"
+		schema["items"] = schemaFor(t.Elem(), name)
+	case reflect.String:
+		schema["type"] = "string"
+		if values, ok := enums[name]; ok {
+			schema["enum"] = values
+		}
+	case reflect.Bool:
+		schema["type"] = "boolean"
+	case reflect.Int:
+		schema["type"] = "integer"
+		if lo, ok := minimums[name]; ok {
+			schema["minimum"] = lo
+		}
+		if hi, ok := maximums[name]; ok {
+			schema["maximum"] = hi
+		}
+	}
+	return schema
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,172 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validate checks cfg like ValidateConfig, and prefixes an error with
+// where the key at fault was set: the file, line and column, the
+// environment variable, or the flag
+func Validate(cfg *Config, origins Origins) error {
+	err := ValidateConfig(cfg)
+	var keyErr *KeyError
+	if errors.As(err, &keyErr) {
+		if at := origins[keyErr.Key].location(); at != "" {
+			return fmt.Errorf("%s: %w", at, err)
+		}
+	}
+	return err
+}
+
+// ValidateFile checks a single config file on top of the defaults
+func ValidateFile(path string) error {
+	cfg := DefaultConfig()
+	origins := make(Origins, len(keys))
+	if err := loadFile(cfg, origins, LayerLocal, path); err != nil {
+		return err
+	}
+	applyDefaults(cfg)
+	return Validate(cfg, origins)
+}
+
+// location describes where a value was set, for error messages
+func (o Origin) location() string {
+	if o.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d", o.Source, o.Line, o.Column)
+	}
+	return o.Source
+}
+
+// checkNode reports the keys under node that type t has no field for,
+// and the values that don't decode into their field
+func checkNode(node *yaml.Node, t reflect.Type, name string, fail func(node *yaml.Node, format string, args ...any)) {
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		prefix := ""
+		if name != "" {
+			prefix = name + "."
+		}
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, value := node.Content[i], node.Content[i+1]
+			child := prefix + keyNode.Value
+			if line, ok := seen[keyNode.Value]; ok {
+				fail(keyNode, "duplicate key %s, first set on line %d", child, line)
+				continue
+			}
+			seen[keyNode.Value] = keyNode.Line
+			field, ok := yamlField(t, keyNode.Value)
+			if !ok {
+				fail(keyNode, "unknown key %s%s", child, suggest(keyNode.Value, prefix, yamlFields(t)))
+				continue
+			}
+			checkNode(value, field.Type, child, fail)
+		}
+	case t.Kind() == reflect.Map && t.Elem().Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		seen := make(map[string]int)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			keyNode, value := node.Content[i], node.Content[i+1]
+			if line, ok := seen[keyNode.Value]; ok {
+				fail(keyNode, "duplicate key %s.%s, first set on line %d", name, keyNode.Value, line)
+				continue
+			}
+			seen[keyNode.Value] = keyNode.Line
+			checkNode(value, t.Elem(), name+"."+keyNode.Value, fail)
+		}
+	case t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Struct && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			checkNode(item, t.Elem(), name, fail)
+		}
+	default:
+		if err := node.Decode(reflect.New(t).Interface()); err != nil {
+			fail(node, "invalid value for %s: %s", name, yamlMessage(err))
+		}
+	}
+}
+
+// yamlField returns the field of struct type t with the YAML name
+func yamlField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if tag, _, _ := strings.Cut(field.Tag.Get("yaml"), ","); tag == name {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// yamlFields returns the YAML names of the fields of struct type t
+func yamlFields(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ","); tag != "" && tag != "-" {
+			names = append(names, tag)
+		}
+	}
+	return names
+}
+
+// suggest returns a hint naming the candidate closest to a misspelled
+// name, if one is close enough
+func suggest(name, prefix string, candidates []string) string {
+	best, bestDistance := "", 3
+	for _, c := range candidates {
+		if d := editDistance(name, c); d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	if best == "" {
+		return ""
+	}
+	return fmt.Sprintf(" (did you mean %s%s?)", prefix, best)
+}
+
+// editDistance returns the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// yamlLine matches the position yaml.v3 puts in front of its messages
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?(?:unmarshal errors:\s*)?line (\d+): `)
+
+// yamlMessage returns the first message of a yaml.v3 error without its
+// position
+func yamlMessage(err error) string {
+	msg := err.Error()
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) && len(typeErr.Errors) > 0 {
+		msg = typeErr.Errors[0]
+	}
+	return strings.TrimPrefix(yamlLine.ReplaceAllString(msg, ""), "yaml: ")
+}
+
+// syntaxError formats a YAML syntax error in the file at path as
+// path:line: message
+func syntaxError(path string, err error) error {
+	if m := yamlLine.FindStringSubmatch(err.Error()); m != nil {
+		return fmt.Errorf("%s:%s: %s", path, m[1], yamlMessage(err))
+	}
+	return fmt.Errorf("%s: %s", path, yamlMessage(err))
+}
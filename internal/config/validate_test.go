@@ -0,0 +1,110 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadStrict(t *testing.T) {
+	chdirTemp(t)
+
+	writeFile(t, ProjectPath, `retension: 5
+remote:
+  regoin: eu-west-1
+secret_rules:
+  - name: token
+    patern: tk_[a-z]+
+    action: warn
+jobs: many
+include: [.env]
+include: [.envrc]
+profiles:
+  env:
+    retension: 1
+`)
+	_, _, err := Load()
+	if err == nil {
+		t.Fatal("Expected errors for the project config")
+	}
+	for _, want := range []string{
+		".ignoregrets.yaml:1:1: unknown key retension (did you mean retention?)",
+		".ignoregrets.yaml:3:3: unknown key remote.regoin (did you mean remote.region?)",
+		".ignoregrets.yaml:6:5: unknown key secret_rules.patern (did you mean secret_rules.pattern?)",
+		".ignoregrets.yaml:8:7: invalid value for jobs: cannot unmarshal !!str `many` into int",
+		".ignoregrets.yaml:10:1: duplicate key include, first set on line 9",
+		".ignoregrets.yaml:13:5: unknown key profiles.env.retension (did you mean profiles.env.retention?)",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error %q, got:\n%v", want, err)
+		}
+	}
+
+	writeFile(t, ProjectPath, "exclude: [\"*.log\"\n")
+	if _, _, err := Load(); err == nil || !strings.HasPrefix(err.Error(), ".ignoregrets.yaml:1: ") {
+		t.Errorf("Expected a syntax error with its line, got %v", err)
+	}
+
+	// A file of only comments sets nothing
+	writeFile(t, ProjectPath, "# retention: 5\n")
+	if cfg, _, err := Load(); err != nil || cfg.Retention != DefaultConfig().Retention {
+		t.Errorf("Expected the defaults, got %+v, %v", cfg, err)
+	}
+}
+
+func TestValidateLocation(t *testing.T) {
+	chdirTemp(t)
+
+	writeFile(t, LocalPath, "retention: 5\nexclude:\n  - \"*.log\"\n  - \"[abc\"\n")
+	cfg, origins, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = Validate(cfg, origins)
+	var keyErr *KeyError
+	if !errors.As(err, &keyErr) || keyErr.Key != "exclude" {
+		t.Fatalf("Expected a KeyError for exclude, got %v", err)
+	}
+	if want := filepath.Join(".ignoregrets", "config.yaml") + ":2:1: invalid exclude pattern"; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Expected %q, got %v", want, err)
+	}
+	if err := ValidateFile(LocalPath); err == nil {
+		t.Error("Expected ValidateFile to fail")
+	}
+
+	t.Setenv("IGNOREGRETS_COMPRESSION", "lz4")
+	writeFile(t, LocalPath, "retention: 5\n")
+	cfg, origins, err = Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(cfg, origins); err == nil || err.Error() != "IGNOREGRETS_COMPRESSION: invalid compression: lz4" {
+		t.Errorf("Expected the error to name the variable, got %v", err)
+	}
+	// ValidateFile ignores the environment
+	if err := ValidateFile(LocalPath); err != nil {
+		t.Errorf("Expected the file alone to be valid, got %v", err)
+	}
+}
+
+func TestSchema(t *testing.T) {
+	schema, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	published, err := os.ReadFile(filepath.Join("..", "..", "docs", "config.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(published) != string(schema) {
+		t.Error("docs/config.schema.json is out of date; regenerate it with 'ignoregrets config schema'")
+	}
+
+	for _, key := range Keys() {
+		if descriptions[key] == "" {
+			t.Errorf("Key %s has no description in the schema", key)
+		}
+	}
+}
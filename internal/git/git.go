@@ -1,16 +1,23 @@
 package git
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
 // GetCurrentCommit returns the current commit hash
-func GetCurrentCommit() (string, error) {
-	cmd := exec.Command("git", "rev-parse", "HEAD")
+func GetCurrentCommit(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "HEAD")
 	output, err := cmd.Output()
 	if err != nil {
 		return "", fmt.Errorf("failed to get current commit: %w", err)
@@ -19,8 +26,8 @@ func GetCurrentCommit() (string, error) {
 }
 
 // GetIgnoredFiles returns a list of ignored files
-func GetIgnoredFiles() ([]string, error) {
-	cmd := exec.Command("git", "ls-files", "--others", "--exclude-standard")
+func GetIgnoredFiles(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "--others", "--exclude-standard")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list ignored files: %w", err)
@@ -34,7 +41,7 @@ func GetIgnoredFiles() ([]string, error) {
 	}
 
 	// Also check .git/info/exclude
-	excludeFiles, err := getExcludeFiles()
+	excludeFiles, err := getExcludeFiles(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -44,8 +51,8 @@ func GetIgnoredFiles() ([]string, error) {
 }
 
 // getExcludeFiles returns files excluded by .git/info/exclude
-func getExcludeFiles() ([]string, error) {
-	cmd := exec.Command("git", "ls-files", "--others", "--exclude-from=.git/info/exclude")
+func getExcludeFiles(ctx context.Context) ([]string, error) {
+	cmd := exec.CommandContext(ctx, "git", "ls-files", "--others", "--exclude-from=.git/info/exclude")
 	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list excluded files: %w", err)
@@ -60,42 +67,359 @@ func getExcludeFiles() ([]string, error) {
 	return files, nil
 }
 
-// InstallHook installs a Git hook
-func InstallHook(hookName, content string) error {
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
-	gitDirBytes, err := cmd.Output()
+// Markers delimiting the ignoregrets block inside a hook script
+const (
+	hookBlockStart = "# >>> ignoregrets >>>"
+	hookBlockEnd   = "# <<< ignoregrets <<<"
+	hookChainNote  = "# ignoregrets: chained to original hook"
+)
+
+// HooksDir returns the directory Git runs hooks from, honoring core.hooksPath
+func HooksDir() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--git-path", "hooks")
+	output, err := cmd.Output()
 	if err != nil {
-		return fmt.Errorf("failed to get git directory: %w", err)
+		return "", fmt.Errorf("failed to get hooks directory: %w", err)
 	}
-	gitDir := strings.TrimSpace(string(gitDirBytes))
+	return strings.TrimSpace(string(output)), nil
+}
 
-	hookPath := filepath.Join(gitDir, "hooks", hookName)
+// DetectHookManager returns the name of a hook manager that owns the
+// repository's hooks ("husky", "lefthook" or "pre-commit"), or "" if none.
+func DetectHookManager() (string, error) {
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get repository root: %w", err)
+	}
+	root := strings.TrimSpace(string(output))
+
+	hooksDir, err := HooksDir()
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(filepath.ToSlash(hooksDir), ".husky") || fileExists(filepath.Join(root, ".husky")) {
+		return "husky", nil
+	}
+
+	for _, name := range []string{"lefthook.yml", "lefthook.yaml", ".lefthook.yml", ".lefthook.yaml"} {
+		if fileExists(filepath.Join(root, name)) {
+			return "lefthook", nil
+		}
+	}
+
+	if fileExists(filepath.Join(root, ".pre-commit-config.yaml")) {
+		return "pre-commit", nil
+	}
+
+	return "", nil
+}
+
+// InstallHook installs content as the ignoregrets block of a Git hook.
+// A missing hook is created. An existing shell hook gets the block inserted
+// right after its shebang, so it runs before any exit in the original script.
+// Any other existing hook is moved to <hook>.orig and chained to.
+// Reinstalling replaces the previous block.
+func InstallHook(hookName, content string) error {
+	hooksDir, err := HooksDir()
+	if err != nil {
+		return err
+	}
+	hookPath := filepath.Join(hooksDir, hookName)
 
 	// Create hooks directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(hookPath), 0755); err != nil {
+	if err := os.MkdirAll(hooksDir, 0755); err != nil {
 		return fmt.Errorf("failed to create hooks directory: %w", err)
 	}
 
+	block := hookBlock(content)
+
+	existing, err := os.ReadFile(hookPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read hook file: %w", err)
+	}
+
+	var script string
+	switch {
+	case os.IsNotExist(err):
+		script = "#!/bin/sh\n" + block
+	case strings.Contains(string(existing), hookBlockStart):
+		rest, _ := removeHookBlock(string(existing))
+		script = insertAfterShebang(rest, block)
+	case isShellScript(string(existing)):
+		script = insertAfterShebang(string(existing), block)
+	default:
+		origPath := hookPath + ".orig"
+		if fileExists(origPath) {
+			return fmt.Errorf("cannot chain %s hook: %s already exists", hookName, origPath)
+		}
+		if err := os.Rename(hookPath, origPath); err != nil {
+			return fmt.Errorf("failed to move existing hook: %w", err)
+		}
+		script = "#!/bin/sh\n" + block +
+			hookChainNote + "\n" +
+			fmt.Sprintf("exec \"$(dirname \"$0\")/%s.orig\" \"$@\"\n", hookName)
+	}
+
 	// Write hook file
-	if err := os.WriteFile(hookPath, []byte(content), 0755); err != nil {
+	if err := os.WriteFile(hookPath, []byte(script), 0755); err != nil {
 		return fmt.Errorf("failed to write hook file: %w", err)
 	}
 
 	return nil
 }
 
-// UninstallHook removes a Git hook
+// UninstallHook removes the ignoregrets block from a Git hook. The hook file
+// is deleted only when nothing else is left in it, and a chained <hook>.orig
+// is moved back into place.
 func UninstallHook(hookName string) error {
-	cmd := exec.Command("git", "rev-parse", "--git-dir")
-	gitDirBytes, err := cmd.Output()
+	hooksDir, err := HooksDir()
 	if err != nil {
-		return fmt.Errorf("failed to get git directory: %w", err)
+		return err
+	}
+	hookPath := filepath.Join(hooksDir, hookName)
+
+	data, err := os.ReadFile(hookPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read hook file: %w", err)
+	}
+
+	rest, found := removeHookBlock(string(data))
+	if !found {
+		return nil
+	}
+
+	origPath := hookPath + ".orig"
+	if strings.Contains(rest, hookChainNote) {
+		if fileExists(origPath) {
+			if err := os.Rename(origPath, hookPath); err != nil {
+				return fmt.Errorf("failed to restore original hook: %w", err)
+			}
+			return nil
+		}
+		// The original hook is gone, so the chain has nothing left to run
+		rest = removeChainStub(rest)
+	}
+
+	// Delete the file only if we created it and nothing else was added
+	if t := strings.TrimSpace(rest); t == "" || t == "#!/bin/sh" {
+		if err := os.Remove(hookPath); err != nil {
+			return fmt.Errorf("failed to remove hook: %w", err)
+		}
+		return nil
 	}
-	gitDir := strings.TrimSpace(string(gitDirBytes))
 
-	hookPath := filepath.Join(gitDir, "hooks", hookName)
-	if err := os.Remove(hookPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove hook: %w", err)
+	if err := os.WriteFile(hookPath, []byte(rest), 0755); err != nil {
+		return fmt.Errorf("failed to write hook file: %w", err)
 	}
 	return nil
 }
+
+// removeChainStub strips the lines InstallHook adds to chain to <hook>.orig
+func removeChainStub(script string) string {
+	lines := strings.SplitAfter(script, "\n")
+	var kept []string
+	for i := 0; i < len(lines); i++ {
+		if strings.TrimSpace(lines[i]) == hookChainNote {
+			if i+1 < len(lines) && strings.HasPrefix(lines[i+1], "exec ") {
+				i++
+			}
+			continue
+		}
+		kept = append(kept, lines[i])
+	}
+	return strings.Join(kept, "")
+}
+
+// hookBlock wraps hook content in the ignoregrets markers, dropping any shebang
+func hookBlock(content string) string {
+	if strings.HasPrefix(content, "#!") {
+		if i := strings.Index(content, "\n"); i >= 0 {
+			content = content[i+1:]
+		} else {
+			content = ""
+		}
+	}
+	content = strings.TrimRight(content, "\n")
+	return hookBlockStart + "\n" + content + "\n" + hookBlockEnd + "\n"
+}
+
+// removeHookBlock strips the ignoregrets block from a script
+func removeHookBlock(script string) (string, bool) {
+	start := strings.Index(script, hookBlockStart)
+	if start < 0 {
+		return script, false
+	}
+	end := strings.Index(script[start:], hookBlockEnd)
+	if end < 0 {
+		return script, false
+	}
+	end += start + len(hookBlockEnd)
+	if end < len(script) && script[end] == '\n' {
+		end++
+	}
+	return script[:start] + script[end:], true
+}
+
+// insertAfterShebang inserts block after the first line of a script that
+// starts with a shebang, or at the top otherwise
+func insertAfterShebang(script, block string) string {
+	if !strings.HasPrefix(script, "#!") {
+		return "#!/bin/sh\n" + block + script
+	}
+	i := strings.Index(script, "\n")
+	if i < 0 {
+		return script + "\n" + block
+	}
+	return script[:i+1] + block + script[i+1:]
+}
+
+// isShellScript reports whether a hook is a POSIX-style shell script we can
+// safely insert a block into
+func isShellScript(script string) bool {
+	line := script
+	if i := strings.Index(script, "\n"); i >= 0 {
+		line = script[:i]
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return false
+	}
+	for _, shell := range []string{"sh", "bash", "dash", "zsh", "ksh"} {
+		if strings.HasSuffix(line, "/"+shell) || strings.HasSuffix(line, " "+shell) {
+			return true
+		}
+	}
+	return false
+}
+
+// fileExists reports whether path exists
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Rewrite maps a commit to the commit that replaced it
+type Rewrite struct {
+	Old string
+	New string
+}
+
+// ParseRewrites parses the "<old-sha> <new-sha> [<extra>]" lines Git passes
+// to the post-rewrite hook on stdin
+func ParseRewrites(r io.Reader) ([]Rewrite, error) {
+	var rewrites []Rewrite
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("malformed rewrite line: %q", scanner.Text())
+		}
+		rewrites = append(rewrites, Rewrite{Old: fields[0], New: fields[1]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read rewritten commits: %w", err)
+	}
+	return rewrites, nil
+}
+
+// Clean runs 'git clean' with args, attached to the terminal so interactive
+// mode and git's own output work as usual
+func Clean(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"clean"}, args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("git clean failed: %w", err)
+	}
+	return nil
+}
+
+// ResolveCommit returns the commit hash a revision such as ORIG_HEAD points to
+func ResolveCommit(ctx context.Context, rev string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-parse", "--verify", "--quiet", rev+"^{commit}")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CurrentBranch returns the short name of the checked out branch, or ""
+// when HEAD is detached
+func CurrentBranch(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "symbolic-ref", "--short", "-q", "HEAD")
+	output, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get current branch: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// CommitSubjects returns the subject line of each of the given commits.
+// Commits the repository doesn't have are left out.
+func CommitSubjects(ctx context.Context, commits []string) (map[string]string, error) {
+	subjects := make(map[string]string)
+	if len(commits) == 0 {
+		return subjects, nil
+	}
+	args := append([]string{"log", "--no-walk=unsorted", "--ignore-missing", "--format=%H%x09%s"}, commits...)
+	cmd := exec.CommandContext(ctx, "git", args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit subjects: %w", err)
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		hash, subject, ok := strings.Cut(line, "\t")
+		if ok {
+			subjects[hash] = subject
+		}
+	}
+	return subjects, nil
+}
+
+// RepoID returns an identifier shared by every clone of the repository:
+// the first root commit, or without commits a hash of the origin URL
+func RepoID(ctx context.Context) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", "rev-list", "--max-parents=0", "HEAD")
+	if output, err := cmd.Output(); err == nil {
+		roots := strings.Fields(string(output))
+		if len(roots) > 0 {
+			sort.Strings(roots)
+			return roots[0][:16], nil
+		}
+	}
+
+	cmd = exec.CommandContext(ctx, "git", "config", "--get", "remote.origin.url")
+	output, err := cmd.Output()
+	if err != nil || strings.TrimSpace(string(output)) == "" {
+		return "", fmt.Errorf("failed to identify repository: no commits and no origin remote")
+	}
+	sum := sha256.Sum256([]byte(normalizeRemoteURL(strings.TrimSpace(string(output)))))
+	return hex.EncodeToString(sum[:])[:16], nil
+}
+
+// normalizeRemoteURL reduces the forms of a remote URL to host/path, so
+// https://host/org/repo.git and git@host:org/repo name the same repository
+func normalizeRemoteURL(remote string) string {
+	if _, rest, ok := strings.Cut(remote, "://"); ok {
+		remote = rest
+	} else if host, path, ok := strings.Cut(remote, ":"); ok && !strings.Contains(host, "/") {
+		// scp-style host:path
+		remote = host + "/" + path
+	}
+	if at := strings.Index(remote, "@"); at >= 0 && at < strings.Index(remote+"/", "/") {
+		remote = remote[at+1:]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(remote, "/"), ".git")
+}
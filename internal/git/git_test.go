@@ -1,6 +1,7 @@
 package git
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -81,7 +82,7 @@ func TestGetCurrentCommit(t *testing.T) {
 	defer cleanup()
 
 	// Get commit hash
-	hash, err := GetCurrentCommit()
+	hash, err := GetCurrentCommit(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to get current commit: %v", err)
 	}
@@ -121,7 +122,7 @@ func TestGetIgnoredFiles(t *testing.T) {
 	}
 
 	// Get ignored files
-	files, err := GetIgnoredFiles()
+	files, err := GetIgnoredFiles(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to get ignored files: %v", err)
 	}
@@ -168,8 +169,141 @@ func TestInstallHook(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Failed to read hook file: %v", err)
 	}
-	if !strings.Contains(string(data), hookContent) {
-		t.Error("Hook content does not match")
+	if !strings.HasPrefix(string(data), "#!/bin/sh\n") || !strings.Contains(string(data), "echo test") {
+		t.Errorf("Hook content does not match: %q", data)
+	}
+}
+
+func TestInstallHookPreservesExistingHook(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	hookPath := filepath.Join(".git", "hooks", "pre-commit")
+	existing := "#!/bin/sh\nnpm run lint\nexit 0\n"
+	if err := os.WriteFile(hookPath, []byte(existing), 0755); err != nil {
+		t.Fatalf("Failed to write existing hook: %v", err)
+	}
+
+	// Installing twice must not duplicate the block
+	for i := 0; i < 2; i++ {
+		if err := InstallHook("pre-commit", "ignoregrets snapshot"); err != nil {
+			t.Fatalf("Failed to install hook: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("Failed to read hook file: %v", err)
+	}
+	script := string(data)
+	if !strings.Contains(script, "npm run lint") {
+		t.Error("Existing hook content was lost")
+	}
+	if strings.Count(script, hookBlockStart) != 1 {
+		t.Errorf("Expected exactly one ignoregrets block, got:\n%s", script)
+	}
+	if strings.Index(script, "ignoregrets snapshot") > strings.Index(script, "exit 0") {
+		t.Error("ignoregrets block must run before the existing hook exits")
+	}
+
+	// Uninstall leaves the original hook intact
+	if err := UninstallHook("pre-commit"); err != nil {
+		t.Fatalf("Failed to uninstall hook: %v", err)
+	}
+	data, err = os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("Hook file was removed: %v", err)
+	}
+	if string(data) != existing {
+		t.Errorf("Expected original hook %q, got %q", existing, data)
+	}
+}
+
+func TestInstallHookChainsNonShellHook(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	hookPath := filepath.Join(".git", "hooks", "pre-commit")
+	existing := "#!/usr/bin/env python3\nprint('lint')\n"
+	if err := os.WriteFile(hookPath, []byte(existing), 0755); err != nil {
+		t.Fatalf("Failed to write existing hook: %v", err)
+	}
+
+	if err := InstallHook("pre-commit", "ignoregrets snapshot"); err != nil {
+		t.Fatalf("Failed to install hook: %v", err)
+	}
+
+	orig, err := os.ReadFile(hookPath + ".orig")
+	if err != nil {
+		t.Fatalf("Original hook was not preserved: %v", err)
+	}
+	if string(orig) != existing {
+		t.Errorf("Expected .orig to hold original hook, got %q", orig)
+	}
+	data, err := os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("Failed to read hook file: %v", err)
+	}
+	if !strings.Contains(string(data), "pre-commit.orig") {
+		t.Error("Hook does not chain to the original hook")
+	}
+
+	if err := UninstallHook("pre-commit"); err != nil {
+		t.Fatalf("Failed to uninstall hook: %v", err)
+	}
+	data, err = os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("Original hook was not restored: %v", err)
+	}
+	if string(data) != existing {
+		t.Errorf("Expected original hook %q, got %q", existing, data)
+	}
+	if _, err := os.Stat(hookPath + ".orig"); !os.IsNotExist(err) {
+		t.Error(".orig file still exists")
+	}
+}
+
+func TestInstallHookRespectsHooksPath(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	if err := exec.Command("git", "config", "core.hooksPath", "githooks").Run(); err != nil {
+		t.Fatalf("Failed to set core.hooksPath: %v", err)
+	}
+
+	if err := InstallHook("pre-commit", "ignoregrets snapshot"); err != nil {
+		t.Fatalf("Failed to install hook: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join("githooks", "pre-commit")); err != nil {
+		t.Errorf("Hook not installed in core.hooksPath: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(".git", "hooks", "pre-commit")); !os.IsNotExist(err) {
+		t.Error("Hook should not be installed in .git/hooks")
+	}
+}
+
+func TestDetectHookManager(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	manager, err := DetectHookManager()
+	if err != nil {
+		t.Fatalf("Failed to detect hook manager: %v", err)
+	}
+	if manager != "" {
+		t.Errorf("Expected no hook manager, got %q", manager)
+	}
+
+	if err := os.WriteFile("lefthook.yml", []byte("pre-commit:\n"), 0644); err != nil {
+		t.Fatalf("Failed to write lefthook.yml: %v", err)
+	}
+	manager, err = DetectHookManager()
+	if err != nil {
+		t.Fatalf("Failed to detect hook manager: %v", err)
+	}
+	if manager != "lefthook" {
+		t.Errorf("Expected lefthook, got %q", manager)
 	}
 }
 
@@ -194,6 +328,123 @@ func TestUninstallHook(t *testing.T) {
 	}
 }
 
+func TestUninstallHookWithoutOriginal(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+
+	hookPath := filepath.Join(".git", "hooks", "pre-commit")
+	if err := os.WriteFile(hookPath, []byte("#!/usr/bin/env python3\nprint('lint')\n"), 0755); err != nil {
+		t.Fatalf("Failed to write existing hook: %v", err)
+	}
+	if err := InstallHook("pre-commit", "ignoregrets snapshot"); err != nil {
+		t.Fatalf("Failed to install hook: %v", err)
+	}
+
+	// With the chained hook gone, no stub that execs it is left behind
+	if err := os.Remove(hookPath + ".orig"); err != nil {
+		t.Fatal(err)
+	}
+	if err := UninstallHook("pre-commit"); err != nil {
+		t.Fatalf("Failed to uninstall hook: %v", err)
+	}
+	if _, err := os.Stat(hookPath); !os.IsNotExist(err) {
+		t.Error("Hook file still exists")
+	}
+
+	// Lines added to the hook since are kept
+	if err := os.WriteFile(hookPath, []byte("#!/usr/bin/env python3\nprint('lint')\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := InstallHook("pre-commit", "ignoregrets snapshot"); err != nil {
+		t.Fatalf("Failed to install hook: %v", err)
+	}
+	if err := os.Remove(hookPath + ".orig"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(hookPath, append(data, "echo done\n"...), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := UninstallHook("pre-commit"); err != nil {
+		t.Fatalf("Failed to uninstall hook: %v", err)
+	}
+	data, err = os.ReadFile(hookPath)
+	if err != nil {
+		t.Fatalf("Failed to read hook file: %v", err)
+	}
+	if string(data) != "#!/bin/sh\necho done\n" {
+		t.Errorf("Expected only the added lines to remain, got %q", data)
+	}
+}
+
+func TestParseRewrites(t *testing.T) {
+	input := "aaa111 bbb222\nccc333 ddd444 extra\n\n"
+	rewrites, err := ParseRewrites(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to parse rewrites: %v", err)
+	}
+
+	expected := []Rewrite{{Old: "aaa111", New: "bbb222"}, {Old: "ccc333", New: "ddd444"}}
+	if len(rewrites) != len(expected) {
+		t.Fatalf("Expected %d rewrites, got %d", len(expected), len(rewrites))
+	}
+	for i, r := range expected {
+		if rewrites[i] != r {
+			t.Errorf("Expected rewrite %v, got %v", r, rewrites[i])
+		}
+	}
+
+	if _, err := ParseRewrites(strings.NewReader("onlyone\n")); err == nil {
+		t.Error("Expected error for malformed line")
+	}
+}
+
+func TestRepoID(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	id, err := RepoID(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get repo id: %v", err)
+	}
+	root, err := GetCurrentCommit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != root[:16] {
+		t.Errorf("Expected the root commit prefix %s, got %s", root[:16], id)
+	}
+
+	// Later commits don't change it
+	if err := os.WriteFile("test.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := exec.Command("git", "commit", "-am", "Second commit").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := RepoID(ctx); again != id {
+		t.Errorf("Expected the repo id to stay %s, got %s", id, again)
+	}
+}
+
+func TestNormalizeRemoteURL(t *testing.T) {
+	for _, remote := range []string{
+		"https://github.com/org/repo.git",
+		"https://user@github.com/org/repo",
+		"ssh://git@github.com/org/repo.git",
+		"git@github.com:org/repo.git",
+		"github.com:org/repo/",
+	} {
+		if got := normalizeRemoteURL(remote); got != "github.com/org/repo" {
+			t.Errorf("normalizeRemoteURL(%q) = %q, want github.com/org/repo", remote, got)
+		}
+	}
+}
+
 func isHexString(s string) bool {
 	for _, r := range s {
 		if !strings.ContainsRune("0123456789abcdef", r) {
@@ -202,3 +453,38 @@ func isHexString(s string) bool {
 	}
 	return true
 }
+
+func TestCurrentBranchAndSubjects(t *testing.T) {
+	_, cleanup := setupTestRepo(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	if err := exec.Command("git", "checkout", "-q", "-b", "feature").Run(); err != nil {
+		t.Fatal(err)
+	}
+	branch, err := CurrentBranch(ctx)
+	if err != nil || branch != "feature" {
+		t.Errorf("Expected branch feature, got %q, %v", branch, err)
+	}
+
+	head, err := GetCurrentCommit(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := strings.Repeat("0", 40)
+	subjects, err := CommitSubjects(ctx, []string{head, missing})
+	if err != nil {
+		t.Fatalf("Failed to get commit subjects: %v", err)
+	}
+	if len(subjects) != 1 || subjects[head] != "Initial commit" {
+		t.Errorf("Expected only the subject of %s, got %v", head, subjects)
+	}
+
+	// A detached HEAD has no branch
+	if err := exec.Command("git", "checkout", "-q", "--detach").Run(); err != nil {
+		t.Fatal(err)
+	}
+	if branch, err := CurrentBranch(ctx); err != nil || branch != "" {
+		t.Errorf("Expected no branch on a detached HEAD, got %q, %v", branch, err)
+	}
+}
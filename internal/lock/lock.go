@@ -0,0 +1,262 @@
+// Package lock implements a shared/exclusive lock on a directory that works
+// across processes, using lock files that record the holder's PID and host.
+package lock
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mode is the mode a lock is held in
+type Mode int
+
+const (
+	// Shared locks may be held by any number of readers at once
+	Shared Mode = iota
+
+	// Exclusive locks exclude every other holder
+	Exclusive
+)
+
+// String returns the name of the mode
+func (m Mode) String() string {
+	if m == Exclusive {
+		return "exclusive"
+	}
+	return "shared"
+}
+
+// ErrLocked is returned when the lock is still held by someone else after
+// the wait timeout
+var ErrLocked = errors.New("store is locked")
+
+const (
+	exclusiveName = "exclusive"
+	sharedPrefix  = "shared-"
+	tempPrefix    = ".tmp-"
+
+	// pollInterval is how often a waiting Acquire checks the lock again
+	pollInterval = 50 * time.Millisecond
+)
+
+// Holder describes the process holding a lock, as stored in its lock file
+type Holder struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Mode    Mode      `json:"mode"`
+	Command string    `json:"command"`
+	Since   time.Time `json:"since"`
+}
+
+// String describes the holder for error messages
+func (h Holder) String() string {
+	return fmt.Sprintf("%q (pid %d on %s, %s since %s)",
+		h.Command, h.PID, h.Host, h.Mode, h.Since.Format("15:04:05"))
+}
+
+// stale reports whether the holder is a process on this host that no
+// longer exists. Holders on other hosts can't be checked and never are.
+func (h Holder) stale() bool {
+	host, err := os.Hostname()
+	if err != nil || h.Host != host {
+		return false
+	}
+	return !processAlive(h.PID)
+}
+
+// Lock is a held lock
+type Lock struct {
+	path string
+}
+
+// Acquire takes the lock on dir in mode, creating dir if needed. If the
+// lock is held in a conflicting mode it retries for up to wait, then
+// returns an error wrapping ErrLocked. Locks left behind by processes that
+// no longer exist are removed.
+func Acquire(ctx context.Context, dir string, mode Mode, wait time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	self, err := newHolder(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(wait)
+	retry := func(holder *Holder) error {
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%w by %s", ErrLocked, holder)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(pollInterval):
+			return nil
+		}
+	}
+
+	if mode == Exclusive {
+		return acquireExclusive(dir, self, retry)
+	}
+	return acquireShared(dir, self, retry)
+}
+
+// acquireExclusive creates the exclusive lock file, then waits for
+// shared holders to leave. Holding the exclusive file while waiting keeps
+// new readers out.
+func acquireExclusive(dir string, self *Holder, retry func(*Holder) error) (*Lock, error) {
+	path := filepath.Join(dir, exclusiveName)
+	for {
+		err := writeHolder(dir, self, func(tmp string) error { return os.Link(tmp, path) })
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+		holder, live := readLive(path)
+		if !live {
+			continue
+		}
+		if err := retry(holder); err != nil {
+			return nil, err
+		}
+	}
+
+	l := &Lock{path: path}
+	for {
+		holder, err := liveShared(dir)
+		if err != nil {
+			l.Release()
+			return nil, err
+		}
+		if holder == nil {
+			return l, nil
+		}
+		if err := retry(holder); err != nil {
+			l.Release()
+			return nil, err
+		}
+	}
+}
+
+// acquireShared adds a shared lock file, then backs off while there is an
+// exclusive holder. Writers add their file before checking for readers and
+// readers before checking for writers, so one of them always sees the other.
+func acquireShared(dir string, self *Holder, retry func(*Holder) error) (*Lock, error) {
+	exclusive := filepath.Join(dir, exclusiveName)
+	for {
+		var path string
+		err := writeHolder(dir, self, func(tmp string) error {
+			path = filepath.Join(dir, sharedPrefix+strings.TrimPrefix(filepath.Base(tmp), tempPrefix))
+			return os.Link(tmp, path)
+		})
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create lock file: %w", err)
+		}
+
+		holder, live := readLive(exclusive)
+		if !live {
+			return &Lock{path: path}, nil
+		}
+		os.Remove(path)
+		if err := retry(holder); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Release gives up the lock. It is safe to call on a nil lock.
+func (l *Lock) Release() error {
+	if l == nil {
+		return nil
+	}
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// newHolder describes the current process
+func newHolder(mode Mode) (*Holder, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get hostname: %w", err)
+	}
+	return &Holder{
+		PID:     os.Getpid(),
+		Host:    host,
+		Mode:    mode,
+		Command: strings.Join(append([]string{filepath.Base(os.Args[0])}, os.Args[1:]...), " "),
+		Since:   time.Now(),
+	}, nil
+}
+
+// writeHolder writes holder to a temporary file in dir and calls publish
+// to move it into place, so a lock file is never seen half written
+func writeHolder(dir string, holder *Holder, publish func(tmp string) error) error {
+	data, err := json.Marshal(holder)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, tempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return publish(f.Name())
+}
+
+// readLive reads the lock file at path and reports whether it belongs to
+// a live holder. Stale or unreadable lock files are removed.
+func readLive(path string) (*Holder, bool) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false
+	}
+	holder := &Holder{}
+	if err == nil {
+		err = json.Unmarshal(data, holder)
+	}
+	if err != nil || holder.stale() {
+		// Two processes can find the same stale file, and one could then
+		// remove a lock the other has just taken in its place. That needs a
+		// crashed holder and a race within microseconds, so it is accepted.
+		os.Remove(path)
+		return nil, false
+	}
+	return holder, true
+}
+
+// liveShared returns a live shared holder in dir, or nil if there is none
+func liveShared(dir string) (*Holder, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), sharedPrefix) {
+			continue
+		}
+		if holder, live := readLive(filepath.Join(dir, entry.Name())); live {
+			return holder, nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,224 @@
+package lock
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as a separate lock holder process
+func TestMain(m *testing.M) {
+	if dir := os.Getenv("LOCK_TEST_HOLD"); dir != "" {
+		holdLock(dir)
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// holdLock takes an exclusive lock on dir, reports it on stdout, and
+// releases it when stdin is closed
+func holdLock(dir string) {
+	l, err := Acquire(context.Background(), dir, Exclusive, 0)
+	if err != nil {
+		os.Exit(1)
+	}
+	os.Stdout.WriteString("locked\n")
+	bufio.NewReader(os.Stdin).ReadString('\n')
+	l.Release()
+}
+
+func mustAcquire(t *testing.T, dir string, mode Mode, wait time.Duration) *Lock {
+	t.Helper()
+	l, err := Acquire(context.Background(), dir, mode, wait)
+	if err != nil {
+		t.Fatalf("Failed to acquire %s lock: %v", mode, err)
+	}
+	return l
+}
+
+func TestSharedAndExclusive(t *testing.T) {
+	dir := t.TempDir()
+	ctx := context.Background()
+
+	// Readers share the lock but keep writers out
+	r1 := mustAcquire(t, dir, Shared, 0)
+	r2 := mustAcquire(t, dir, Shared, 0)
+	if _, err := Acquire(ctx, dir, Exclusive, 0); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Expected ErrLocked while readers hold the lock, got %v", err)
+	}
+	r1.Release()
+	r2.Release()
+
+	// A writer keeps everyone else out
+	w := mustAcquire(t, dir, Exclusive, 0)
+	if _, err := Acquire(ctx, dir, Shared, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for a reader, got %v", err)
+	}
+	if _, err := Acquire(ctx, dir, Exclusive, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for a second writer, got %v", err)
+	}
+	w.Release()
+
+	// Nothing is left behind once released
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Unexpected lock file left behind: %s", entry.Name())
+	}
+}
+
+func TestAcquireWaits(t *testing.T) {
+	dir := t.TempDir()
+	w := mustAcquire(t, dir, Exclusive, 0)
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		w.Release()
+	}()
+
+	r := mustAcquire(t, dir, Shared, 5*time.Second)
+	r.Release()
+}
+
+func TestAcquireCancelled(t *testing.T) {
+	dir := t.TempDir()
+	w := mustAcquire(t, dir, Exclusive, 0)
+	defer w.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := Acquire(ctx, dir, Exclusive, time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+func TestConcurrentWriters(t *testing.T) {
+	dir := t.TempDir()
+
+	var inside, maxInside, readers atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			l, err := Acquire(context.Background(), dir, Exclusive, 10*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if n := inside.Add(1); n > maxInside.Load() {
+				maxInside.Store(n)
+			}
+			if readers.Load() != 0 {
+				t.Error("Writer holds the lock while a reader does")
+			}
+			time.Sleep(5 * time.Millisecond)
+			inside.Add(-1)
+			l.Release()
+		}()
+		go func() {
+			defer wg.Done()
+			l, err := Acquire(context.Background(), dir, Shared, 10*time.Second)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			readers.Add(1)
+			if inside.Load() != 0 {
+				t.Error("Reader holds the lock while a writer does")
+			}
+			time.Sleep(5 * time.Millisecond)
+			readers.Add(-1)
+			l.Release()
+		}()
+	}
+	wg.Wait()
+
+	if maxInside.Load() != 1 {
+		t.Errorf("Expected one writer at a time, got %d", maxInside.Load())
+	}
+}
+
+func TestLockHeldByOtherProcess(t *testing.T) {
+	dir := t.TempDir()
+
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "LOCK_TEST_HOLD="+dir)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if line, _ := bufio.NewReader(stdout).ReadString('\n'); line != "locked\n" {
+		cmd.Wait()
+		t.Fatalf("Helper process failed to take the lock")
+	}
+
+	if _, err := Acquire(context.Background(), dir, Shared, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked while another process holds the lock, got %v", err)
+	}
+
+	// Release it from the other process while this one waits
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		stdin.Close()
+	}()
+	l := mustAcquire(t, dir, Exclusive, 5*time.Second)
+	l.Release()
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("Helper process failed: %v", err)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	dir := t.TempDir()
+
+	// Find the PID of a process that has exited
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	dead, err := newHolder(Exclusive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead.PID = cmd.Process.Pid
+	data, err := json.Marshal(dead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, exclusiveName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := mustAcquire(t, dir, Shared, 0)
+	l.Release()
+	if _, err := os.Stat(filepath.Join(dir, exclusiveName)); !os.IsNotExist(err) {
+		t.Error("Expected the stale lock file to be removed")
+	}
+
+	// Holders on other hosts are never considered stale
+	dead.Host = "elsewhere.invalid"
+	data, _ = json.Marshal(dead)
+	if err := os.WriteFile(filepath.Join(dir, exclusiveName), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Acquire(context.Background(), dir, Shared, 0); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked for a holder on another host, got %v", err)
+	}
+}
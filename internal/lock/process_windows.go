@@ -0,0 +1,20 @@
+//go:build windows
+
+package lock
+
+import "syscall"
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	const stillActive = 259
+	h, err := syscall.OpenProcess(syscall.PROCESS_QUERY_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return err == syscall.ERROR_ACCESS_DENIED
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
@@ -0,0 +1,272 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+)
+
+// bufferLimit is the largest file a worker reads into memory ahead of the
+// tar writer; larger files are streamed by the writer itself
+const bufferLimit = 1 << 20
+
+// ChecksumFiles calculates the SHA256 checksum of each file using up to
+// jobs concurrent workers. Files whose stat information matches the cache
+// are not read; cache and progress may be nil.
+func ChecksumFiles(ctx context.Context, files []string, jobs int, cache *StatCache, progress ProgressFunc) (map[string]string, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	type result struct {
+		path     string
+		checksum string
+		size     int64
+		err      error
+	}
+
+	paths := make(chan string)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				checksum, size, err := cachedChecksum(ctx, path, cache)
+				results <- result{path: path, checksum: checksum, size: size, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		defer close(paths)
+		for _, path := range files {
+			select {
+			case paths <- path:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	checksums := make(map[string]string, len(files))
+	var firstErr error
+	var bytes int64
+	for r := range results {
+		if r.err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to calculate checksum for %s: %w", r.path, r.err)
+			}
+			continue
+		}
+		checksums[r.path] = r.checksum
+		bytes += r.size
+		progress.report(Progress{Phase: PhaseHashing, Files: len(checksums), Total: len(files), Bytes: bytes, Path: r.path})
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return checksums, nil
+}
+
+// cachedChecksum returns the checksum and size of path, from the cache if
+// possible, or hashes the file and records the result
+func cachedChecksum(ctx context.Context, path string, cache *StatCache) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if checksum, ok := cache.Lookup(path, info); ok {
+		return checksum, info.Size(), nil
+	}
+	checksum, err := fileChecksum(ctx, path)
+	if err != nil {
+		return "", 0, err
+	}
+	cache.Update(path, info, checksum)
+	return checksum, info.Size(), nil
+}
+
+// archiveEntry is a file prepared by a worker for the tar writer
+type archiveEntry struct {
+	hdr      *tar.Header
+	data     []byte   // contents of small files, read in full
+	file     *os.File // large files, streamed by the writer
+	checksum string   // checksum of data
+	err      error
+}
+
+// close releases the entry's open file, if any
+func (e *archiveEntry) close() {
+	if e.file != nil {
+		e.file.Close()
+	}
+}
+
+// prepareEntry opens a file and, if it is small, reads and hashes it
+func prepareEntry(path string) *archiveEntry {
+	file, err := os.Open(path)
+	if err != nil {
+		return &archiveEntry{err: err}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return &archiveEntry{err: err}
+	}
+
+	entry := &archiveEntry{hdr: &tar.Header{
+		Name: path,
+		Mode: int64(info.Mode()),
+		Size: info.Size(),
+	}}
+
+	if info.Size() > bufferLimit {
+		entry.file = file
+		return entry
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, info.Size()))
+	if err != nil {
+		return &archiveEntry{err: err}
+	}
+	if int64(len(data)) != info.Size() {
+		return &archiveEntry{err: fmt.Errorf("file changed size while reading")}
+	}
+	sum := sha256.Sum256(data)
+	entry.data = data
+	entry.checksum = hex.EncodeToString(sum[:])
+	return entry
+}
+
+// writeEntry writes a prepared entry to the archive and returns the
+// checksum of what was written
+func writeEntry(ctx context.Context, tw *tar.Writer, entry *archiveEntry) (string, error) {
+	if err := tw.WriteHeader(entry.hdr); err != nil {
+		return "", err
+	}
+
+	if entry.file == nil {
+		if _, err := tw.Write(entry.data); err != nil {
+			return "", err
+		}
+		return entry.checksum, nil
+	}
+
+	// Calculate SHA256 while copying
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tw, h), backend.ContextReader(ctx, entry.file)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// archiveFiles adds files to the archive in the given order and records
+// the checksums of what was written in checksums. Up to jobs workers open,
+// read and hash files ahead of the writer, with at most 2*jobs files in
+// flight.
+func archiveFiles(ctx context.Context, tw *tar.Writer, files []string, jobs int, checksums map[string]string, progress ProgressFunc) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	results := make([]chan *archiveEntry, len(files))
+	for i := range results {
+		results[i] = make(chan *archiveEntry, 1)
+	}
+
+	indexes := make(chan int)
+	window := make(chan struct{}, 2*jobs)
+	done := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i] <- prepareEntry(files[i])
+			}
+		}()
+	}
+
+	// Feed indexes in order, staying within the window
+	go func() {
+		defer close(indexes)
+		for i := range files {
+			select {
+			case window <- struct{}{}:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case indexes <- i:
+			case <-done:
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var err error
+	var bytes int64
+write:
+	for i, path := range files {
+		var entry *archiveEntry
+		select {
+		case entry = <-results[i]:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break write
+		}
+		if entry.err != nil {
+			err = fmt.Errorf("failed to add file to archive: %s: %w", path, entry.err)
+			break
+		}
+		checksum, werr := writeEntry(ctx, tw, entry)
+		entry.close()
+		if werr != nil {
+			err = fmt.Errorf("failed to add file to archive: %s: %w", path, werr)
+			break
+		}
+		checksums[path] = checksum
+		bytes += entry.hdr.Size
+		progress.report(Progress{Phase: PhaseArchiving, Files: i + 1, Total: len(files), Bytes: bytes, Path: path})
+		<-window
+	}
+
+	// Stop the feeder and release anything prepared but not written
+	close(done)
+	wg.Wait()
+	for i := range results {
+		select {
+		case entry := <-results[i]:
+			entry.close()
+		default:
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,162 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// createArchiveFiles writes n files of size bytes under dir and returns
+// their paths
+func createArchiveFiles(tb testing.TB, dir string, n, size int) []string {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		tb.Fatalf("Failed to create directory: %v", err)
+	}
+	var files []string
+	for i := 0; i < n; i++ {
+		path := filepath.Join(dir, fmt.Sprintf("file%04d.bin", i))
+		data := bytes.Repeat([]byte{byte(i)}, size)
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			tb.Fatalf("Failed to create test file: %v", err)
+		}
+		files = append(files, path)
+	}
+	return files
+}
+
+func TestArchiveFilesOrderAndChecksums(t *testing.T) {
+	chdirTemp(t)
+
+	files := createArchiveFiles(t, "small", 50, 100)
+	files = append(files, createArchiveFiles(t, "large", 2, bufferLimit+1)...)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	written := make(map[string]string)
+	if err := archiveFiles(context.Background(), tw, files, 4, written, nil); err != nil {
+		t.Fatalf("Failed to archive files: %v", err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close archive: %v", err)
+	}
+
+	expected, err := ChecksumFiles(context.Background(), files, 4, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to checksum files: %v", err)
+	}
+
+	// Entries appear in input order with the recorded content
+	tr := tar.NewReader(&buf)
+	for i, path := range files {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("Failed to read entry %d: %v", i, err)
+		}
+		if hdr.Name != path {
+			t.Fatalf("Entry %d: expected %s, got %s", i, path, hdr.Name)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if checksumOf(string(data)) != expected[path] || written[path] != expected[path] {
+			t.Errorf("Checksum mismatch for %s", path)
+		}
+	}
+}
+
+func TestArchiveFilesMissingFile(t *testing.T) {
+	chdirTemp(t)
+
+	files := createArchiveFiles(t, "data", 20, 10)
+	files = append(files[:10], append([]string{"missing.bin"}, files[10:]...)...)
+
+	tw := tar.NewWriter(io.Discard)
+	if err := archiveFiles(context.Background(), tw, files, 4, make(map[string]string), nil); err == nil {
+		t.Error("Expected error for missing file")
+	}
+
+	if _, err := ChecksumFiles(context.Background(), files, 4, nil, nil); err == nil {
+		t.Error("Expected checksum error for missing file")
+	}
+}
+
+func TestArchiveProgressAndCancel(t *testing.T) {
+	st := chdirTemp(t)
+	files := createArchiveFiles(t, "data", 20, 100)
+
+	var updates []Progress
+	progress := func(p Progress) { updates = append(updates, p) }
+	if _, err := ChecksumFiles(context.Background(), files, 4, nil, progress); err != nil {
+		t.Fatalf("Failed to checksum files: %v", err)
+	}
+	if len(updates) != len(files) {
+		t.Fatalf("Expected %d updates, got %d", len(files), len(updates))
+	}
+	last := updates[len(updates)-1]
+	if last.Phase != PhaseHashing || last.Files != len(files) || last.Total != len(files) || last.Bytes != 2000 {
+		t.Errorf("Unexpected final progress: %+v", last)
+	}
+
+	// A cancelled snapshot leaves no archive behind
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ChecksumFiles(ctx, files, 4, nil, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from ChecksumFiles, got %v", err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.Files, _ = ChecksumFiles(context.Background(), files, 1, nil, nil)
+	path := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz")
+	if err := st.writeSnapshot(ctx, path, manifest, files, 0, 2, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled from writeSnapshot, got %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected partial archive to be removed, got %v", err)
+	}
+}
+
+func BenchmarkChecksumFiles(b *testing.B) {
+	chdirTemp(b)
+	files := createArchiveFiles(b, "data", 200, 64<<10)
+
+	for _, jobs := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(len(files)) * 64 << 10)
+			for i := 0; i < b.N; i++ {
+				if _, err := ChecksumFiles(context.Background(), files, jobs, nil, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkArchiveFiles(b *testing.B) {
+	chdirTemp(b)
+	files := createArchiveFiles(b, "data", 200, 64<<10)
+
+	for _, jobs := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(len(files)) * 64 << 10)
+			for i := 0; i < b.N; i++ {
+				gw, err := newCompressor(io.Discard, "gzip", 0, jobs)
+				if err != nil {
+					b.Fatal(err)
+				}
+				tw := tar.NewWriter(gw)
+				if err := archiveFiles(context.Background(), tw, files, jobs, make(map[string]string), nil); err != nil {
+					b.Fatal(err)
+				}
+				tw.Close()
+				gw.Close()
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package snapshot
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// tempMarker is part of the name of every temporary file in the store, so
+// leftovers from a crash can be recognised and removed
+const tempMarker = ".tmp-"
+
+// staleTempAge is how old a temporary file must be before Prune treats it
+// as left over from a crash rather than belonging to a running snapshot
+const staleTempAge = time.Hour
+
+// atomicFile is a temporary file that replaces its target path only when
+// committed, so readers never see a partially written file
+type atomicFile struct {
+	*os.File
+	path      string
+	committed bool
+}
+
+// createAtomic creates a temporary file with mode perm next to path. Write
+// to it, then call Commit; call Abort (it is safe after Commit) to discard it.
+func createAtomic(path string, perm os.FileMode) (*atomicFile, error) {
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	file, err := os.CreateTemp(dir, "."+name+tempMarker+"*")
+	if err != nil {
+		return nil, err
+	}
+	if err := file.Chmod(perm); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, err
+	}
+	return &atomicFile{File: file, path: path}, nil
+}
+
+// Commit flushes the file to disk, closes it, and renames it to its target
+func (f *atomicFile) Commit() error {
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", f.path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", f.path, err)
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", f.path, err)
+	}
+	f.committed = true
+	syncDir(filepath.Dir(f.path))
+	return nil
+}
+
+// Abort closes and removes the temporary file unless it was committed
+func (f *atomicFile) Abort() {
+	if f.committed {
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// writeFileAtomic writes data to path through a temporary file
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := createAtomic(path, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Commit()
+}
+
+// syncDir flushes a directory so a rename into it survives a crash. Not
+// every platform supports this, so errors are ignored.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// isTemp reports whether a file name is a temporary file in the store
+func isTemp(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.Contains(name, tempMarker)
+}
+
+// removeStaleTemps deletes temporary files older than staleTempAge
+func removeStaleTemps(dir string, entries []os.DirEntry) {
+	for _, entry := range entries {
+		if entry.IsDir() || !isTemp(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleTempAge {
+			continue
+		}
+		os.Remove(filepath.Join(dir, entry.Name()))
+	}
+}
@@ -0,0 +1,76 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAtomicFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "target")
+	if err := os.WriteFile(path, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Aborted writes leave the target untouched
+	f, err := createAtomic(path, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.WriteString("partial"); err != nil {
+		t.Fatal(err)
+	}
+	f.Abort()
+	if data, _ := os.ReadFile(path); string(data) != "old" {
+		t.Errorf("Expected target unchanged after abort, got %q", data)
+	}
+
+	// Committed writes replace it
+	if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new" {
+		t.Errorf("Expected new content, got %q", data)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("Expected only the target in %s, got %d entries", dir, len(entries))
+	}
+}
+
+func TestRemoveStaleTemps(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, ".c1_20250101T1000_0.tar.gz"+tempMarker+"1")
+	fresh := filepath.Join(dir, ".c1_20250101T1000_1.tar.gz"+tempMarker+"2")
+	for _, path := range []string{stale, fresh} {
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-2 * staleTempAge)
+	if err := os.Chtimes(stale, past, past); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removeStaleTemps(dir, entries)
+
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Error("Expected stale temporary file to be removed")
+	}
+	if _, err := os.Stat(fresh); err != nil {
+		t.Error("Expected recent temporary file to be kept")
+	}
+	if IsArchive(filepath.Base(fresh)) {
+		t.Error("Temporary files must not be listed as archives")
+	}
+}
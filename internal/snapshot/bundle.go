@@ -0,0 +1,424 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+)
+
+// A bundle is an uncompressed tar file holding bundle.json, an optional
+// bundle.sig, and a full snapshot archive, so a snapshot can be handed to
+// another clone of the repository
+const (
+	bundleFormat    = "ignoregrets-bundle"
+	bundleVersion   = 1
+	bundleHeaderEnt = "bundle.json"
+	bundleSigEnt    = "bundle.sig"
+)
+
+// ErrNotBundle is returned when importing a file that isn't a bundle
+var ErrNotBundle = errors.New("not an ignoregrets bundle")
+
+// ErrSecrets is returned when exporting an unencrypted snapshot with files
+// recorded as secret, unless ExportOptions.AllowSecrets is set
+var ErrSecrets = errors.New("snapshot has files recorded as secret")
+
+// ErrAlreadyImported is returned with the existing snapshot when a bundle's
+// snapshot is already in the store
+var ErrAlreadyImported = errors.New("snapshot is already in the store")
+
+// BundleHeader describes a bundle and the snapshot archive in it
+type BundleHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+
+	// Archive is the name of the archive entry, and Size and SHA256
+	// describe its contents
+	Archive string `json:"archive"`
+	Size    int64  `json:"size"`
+	SHA256  string `json:"sha256"`
+
+	Manifest *Manifest `json:"manifest"`
+}
+
+// ExportOptions controls how Export writes a bundle
+type ExportOptions struct {
+	// SignKey is an SSH private key file to sign the bundle with. Keys
+	// protected by a passphrase must be loaded in ssh-agent.
+	SignKey string
+
+	// AllowSecrets exports unencrypted snapshots with files recorded as
+	// secret
+	AllowSecrets bool
+}
+
+// ImportOptions controls how Import checks a bundle
+type ImportOptions struct {
+	// TrustedKeys is an authorized_keys style file; if set, the bundle
+	// must be signed by one of its keys
+	TrustedKeys string
+}
+
+// ImportResult describes an imported snapshot
+type ImportResult struct {
+	Manifest *Manifest
+	Path     string
+
+	// BundleIndex is the snapshot's index in the bundle; Manifest.Index
+	// differs from it if the index was already taken
+	BundleIndex int
+
+	// Signer is the fingerprint of the key that signed the bundle, and
+	// Trusted reports whether it was checked against ImportOptions.TrustedKeys
+	Signer  string
+	Trusted bool
+}
+
+// ParseRef parses a reference written as <commit>[:<index>], as printed by
+// Ref.String; without an index it selects the latest snapshot
+func ParseRef(s string) (Ref, error) {
+	commit, index, ok := strings.Cut(s, ":")
+	if !ok || index == "latest" {
+		return Ref{Commit: commit, Index: Latest}, nil
+	}
+	n, err := strconv.Atoi(index)
+	if err != nil || n < 0 {
+		return Ref{}, fmt.Errorf("invalid snapshot index in %s", s)
+	}
+	return Ref{Commit: commit, Index: n}, nil
+}
+
+// Export writes the snapshot for ref to a bundle at out. Incremental
+// snapshots are exported as full snapshots, keeping their codec and
+// encryption.
+func (st *Store) Export(ctx context.Context, ref Ref, out string, opts ExportOptions) error {
+	path, err := st.FindSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	manifest, err := st.LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	if secret := manifest.SecretFiles(); len(secret) > 0 && !manifest.encrypted() && !opts.AllowSecrets {
+		return fmt.Errorf("%w: %v", ErrSecrets, secret)
+	}
+
+	full := *manifest
+	archive := path
+	if manifest.Parent != nil {
+		scratch, err := createAtomic(path, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to create temporary archive: %w", err)
+		}
+		defer scratch.Abort()
+
+		flat, err := st.writeFull(ctx, scratch, path, manifest)
+		if err != nil {
+			return err
+		}
+		full = *flat
+		archive = scratch.Name()
+	}
+
+	src, err := os.Open(archive)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	h := sha256.New()
+	size, err := io.Copy(h, backend.ContextReader(ctx, src))
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind snapshot: %w", err)
+	}
+
+	header := BundleHeader{
+		Format:   bundleFormat,
+		Version:  bundleVersion,
+		Created:  time.Now(),
+		Archive:  snapshotName(&full),
+		Size:     size,
+		SHA256:   hex.EncodeToString(h.Sum(nil)),
+		Manifest: &full,
+	}
+	headerData, err := json.MarshalIndent(header, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal bundle header: %w", err)
+	}
+
+	var sigData []byte
+	if opts.SignKey != "" {
+		sig, err := signBundle(opts.SignKey, headerData)
+		if err != nil {
+			return err
+		}
+		if sigData, err = json.Marshal(sig); err != nil {
+			return fmt.Errorf("failed to marshal bundle signature: %w", err)
+		}
+	}
+
+	dst, err := createAtomic(out, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer dst.Abort()
+
+	tw := tar.NewWriter(dst)
+	if err := writeEntryData(tw, bundleHeaderEnt, headerData, header.Created); err != nil {
+		return err
+	}
+	if sigData != nil {
+		if err := writeEntryData(tw, bundleSigEnt, sigData, header.Created); err != nil {
+			return err
+		}
+	}
+	hdr := &tar.Header{Name: header.Archive, Mode: 0644, Size: size, ModTime: full.Timestamp}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	if _, err := io.Copy(tw, backend.ContextReader(ctx, src)); err != nil {
+		return fmt.Errorf("failed to copy snapshot into bundle: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := dst.Commit(); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return nil
+}
+
+// writeEntryData writes a small file into a tar archive
+func writeEntryData(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	hdr := &tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: modTime}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s header: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// Import adds the snapshot in the bundle at path to the store. The archive
+// is checked against the bundle header and every file against the
+// manifest checksums before it is added. If the snapshot's index is taken,
+// it gets the next free index of its commit.
+func (st *Store) Import(ctx context.Context, path string, opts ImportOptions) (*ImportResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	tr := tar.NewReader(file)
+	header, headerData, err := readBundleHeader(tr)
+	if err != nil {
+		return nil, err
+	}
+	manifest := header.Manifest
+	result := &ImportResult{BundleIndex: manifest.Index}
+
+	hdr, err := tr.Next()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotBundle, err)
+	}
+	var sig *bundleSignature
+	if hdr.Name == bundleSigEnt {
+		sig = &bundleSignature{}
+		if err := readJSONEntry(tr, sig); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+		}
+		if hdr, err = tr.Next(); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrNotBundle, err)
+		}
+	}
+	if hdr.Name != header.Archive {
+		return nil, fmt.Errorf("%w: expected %s, found %s", ErrNotBundle, header.Archive, hdr.Name)
+	}
+
+	if sig != nil {
+		pub, err := verifyBundle(headerData, sig)
+		if err != nil {
+			return nil, err
+		}
+		result.Signer = ssh.FingerprintSHA256(pub)
+		if opts.TrustedKeys != "" {
+			trusted, err := trustedKey(opts.TrustedKeys, pub)
+			if err != nil {
+				return nil, err
+			}
+			if !trusted {
+				return nil, fmt.Errorf("%w: signed by %s", ErrUntrusted, result.Signer)
+			}
+			result.Trusted = true
+		}
+	} else if opts.TrustedKeys != "" {
+		return nil, fmt.Errorf("%w: bundle is not signed", ErrUntrusted)
+	}
+
+	if existing, path := st.findImported(manifest); existing != nil {
+		result.Manifest = existing
+		result.Path = path
+		return result, ErrAlreadyImported
+	}
+
+	dir := st.dir
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+	named := *manifest
+	if _, err := st.FindSnapshot(Ref{Commit: manifest.CommitHash, Index: manifest.Index}); err == nil {
+		named.Index = st.getNextIndex(manifest.CommitHash)
+	}
+	dstPath := filepath.Join(dir, snapshotName(&named))
+
+	tmp, err := createAtomic(dstPath, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer tmp.Abort()
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), backend.ContextReader(ctx, tr))
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract snapshot from bundle: %w", err)
+	}
+	if size != header.Size || hex.EncodeToString(h.Sum(nil)) != header.SHA256 {
+		return nil, fmt.Errorf("%w: archive does not match the bundle header", ErrCorrupt)
+	}
+
+	archived, err := st.checkImport(ctx, tmp.Name(), manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if archived.Index == named.Index {
+		if err := tmp.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to write snapshot file: %w", err)
+		}
+		if err := st.writeSidecar(dstPath, archived); err != nil {
+			return nil, err
+		}
+	} else {
+		// Renumber the snapshot by rewriting its archive's manifest
+		archived.Index = named.Index
+		if err := st.copyArchive(ctx, tmp.Name(), archived); err != nil {
+			return nil, err
+		}
+	}
+
+	result.Manifest = archived
+	result.Path = dstPath
+	return result, nil
+}
+
+// readBundleHeader reads and checks the header that starts a bundle,
+// returning it with its raw bytes, which the signature covers
+func readBundleHeader(tr *tar.Reader) (*BundleHeader, []byte, error) {
+	hdr, err := tr.Next()
+	if err != nil || hdr.Name != bundleHeaderEnt {
+		return nil, nil, ErrNotBundle
+	}
+	data, err := io.ReadAll(tr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read bundle header: %w", err)
+	}
+
+	header := &BundleHeader{}
+	if err := json.Unmarshal(data, header); err != nil || header.Format != bundleFormat {
+		return nil, nil, ErrNotBundle
+	}
+	if header.Version > bundleVersion {
+		return nil, nil, fmt.Errorf("bundle version %d is newer than this version of ignoregrets supports", header.Version)
+	}
+
+	m := header.Manifest
+	switch {
+	case m == nil || m.CommitHash == "" || m.Index < 0:
+		return nil, nil, fmt.Errorf("%w: missing manifest", ErrNotBundle)
+	case m.Parent != nil:
+		return nil, nil, fmt.Errorf("%w: bundled snapshot is incremental", ErrNotBundle)
+	case strings.ContainsAny(m.CommitHash, `_/\`) || header.Archive != snapshotName(m):
+		return nil, nil, fmt.Errorf("%w: archive name %s does not match its manifest", ErrNotBundle, header.Archive)
+	}
+	if err := upgradeManifest(m); err != nil {
+		return nil, nil, err
+	}
+	if err := checkPaths(m); err != nil {
+		return nil, nil, err
+	}
+	return header, data, nil
+}
+
+// readJSONEntry decodes a JSON tar entry into v
+func readJSONEntry(r io.Reader, v any) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// findImported returns a snapshot of the store with the same commit,
+// timestamp and files as manifest, if there is one
+func (st *Store) findImported(manifest *Manifest) (*Manifest, string) {
+	snapshots, _ := st.listSnapshots(manifest.CommitHash)
+	for _, s := range snapshots {
+		existing, err := st.LoadManifest(s.path)
+		if err != nil {
+			continue
+		}
+		if existing.Timestamp.Equal(manifest.Timestamp) && maps.Equal(existing.Files, manifest.Files) {
+			return existing, s.path
+		}
+	}
+	return nil, ""
+}
+
+// checkImport checks that the archive at path holds the snapshot the
+// bundle header describes, with every file matching its checksum, and
+// returns the archive's manifest
+func (st *Store) checkImport(ctx context.Context, path string, want *Manifest) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	archived, err := st.ReadManifest(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	if archived.CommitHash != want.CommitHash || !archived.Timestamp.Equal(want.Timestamp) ||
+		archived.Index != want.Index || archived.Parent != nil || !maps.Equal(archived.Files, want.Files) {
+		return nil, fmt.Errorf("%w: archive manifest does not match the bundle header", ErrCorrupt)
+	}
+	if err := checkPaths(archived); err != nil {
+		return nil, err
+	}
+	if err := st.verifyFiles(ctx, path, archived); err != nil {
+		return nil, err
+	}
+	return archived, nil
+}
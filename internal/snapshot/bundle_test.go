@@ -0,0 +1,356 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// resetStore removes every snapshot from the store
+func resetStore(t *testing.T) {
+	dir := filepath.Join(".ignoregrets", "snapshots")
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	st, _, _ := setupChain(t)
+	ctx := context.Background()
+
+	// The incremental snapshot is exported as a full one
+	if err := st.Export(ctx, Ref{Commit: "c2", Index: 0}, "c2.bundle", ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := st.Export(ctx, Ref{Commit: "c1", Index: 0}, "c1.bundle", ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	resetStore(t)
+
+	result, err := st.Import(ctx, "c2.bundle", ImportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if result.Manifest.Parent != nil || result.Manifest.Index != 0 || result.Signer != "" {
+		t.Errorf("Unexpected import result %+v", result)
+	}
+	if err := st.RestoreSnapshot(ctx, Ref{Commit: "c2", Index: Latest}, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore imported snapshot: %v", err)
+	}
+	for path, expected := range map[string]string{"a.txt": "a1", "b.txt": "b2"} {
+		if data, _ := os.ReadFile(path); string(data) != expected {
+			t.Errorf("Expected %s to contain %q, got %q", path, expected, data)
+		}
+	}
+
+	if _, err := st.Import(ctx, "c2.bundle", ImportOptions{}); !errors.Is(err, ErrAlreadyImported) {
+		t.Errorf("Expected ErrAlreadyImported, got %v", err)
+	}
+
+	// A different snapshot of c1 already has index 0
+	other, _ := createTestManifest()
+	other.CommitHash = "c1"
+	writeTestSnapshot(t, filepath.Join(".ignoregrets", "snapshots", "c1_20250102T1000_0.tar.gz"), []string{"a.txt"}, other)
+
+	result, err = st.Import(ctx, "c1.bundle", ImportOptions{})
+	if err != nil {
+		t.Fatalf("Failed to import: %v", err)
+	}
+	if result.BundleIndex != 0 || result.Manifest.Index != 1 {
+		t.Errorf("Expected the snapshot to be renumbered from 0 to 1, got %d to %d", result.BundleIndex, result.Manifest.Index)
+	}
+	if err := st.Verify(ctx, Ref{Commit: "c1", Index: 1}); err != nil {
+		t.Errorf("Failed to verify renumbered snapshot: %v", err)
+	}
+	manifest, err := st.LoadManifest(result.Path)
+	if err != nil || manifest.Index != 1 {
+		t.Errorf("Expected the archive manifest to have index 1, got %v, %v", manifest, err)
+	}
+}
+
+func TestImportRejectsDamagedBundle(t *testing.T) {
+	st, _, _ := setupChain(t)
+	ctx := context.Background()
+
+	if err := st.Export(ctx, Ref{Commit: "c1", Index: 0}, "c1.bundle", ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	resetStore(t)
+
+	data, err := os.ReadFile("c1.bundle")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := bytes.Index(data, gzipMagic)
+	if at < 0 {
+		t.Fatal("Archive not found in bundle")
+	}
+	data[at+20] ^= 0xff
+	if err := os.WriteFile("damaged.bundle", data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Import(ctx, "damaged.bundle", ImportOptions{}); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected ErrCorrupt, got %v", err)
+	}
+
+	if _, err := st.Import(ctx, filepath.Join(".ignoregrets", "snapshots"), ImportOptions{}); err == nil {
+		t.Error("Expected an error importing a directory")
+	}
+	if err := os.WriteFile("notes.txt", []byte("not a bundle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Import(ctx, "notes.txt", ImportOptions{}); !errors.Is(err, ErrNotBundle) {
+		t.Errorf("Expected ErrNotBundle, got %v", err)
+	}
+
+	entries, _ := os.ReadDir(filepath.Join(".ignoregrets", "snapshots"))
+	if len(entries) != 0 {
+		t.Errorf("Expected failed imports to leave the store empty, found %d entries", len(entries))
+	}
+}
+
+func TestRejectUnsafePaths(t *testing.T) {
+	st := chdirTemp(t)
+	ctx := context.Background()
+
+	// A snapshot whose archive holds ../escaped.txt, taken from a
+	// subdirectory as if it were the working tree
+	if err := os.WriteFile("escaped.txt", []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join("work", ".ignoregrets", "snapshots"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("work"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.CommitHash = "c1"
+	writeTestSnapshot(t, filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz"), []string{"../escaped.txt"}, manifest)
+	if err := st.Export(ctx, Ref{Commit: "c1", Index: 0}, "c1.bundle", ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	if err := os.Remove(filepath.Join("..", "escaped.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	err := st.RestoreSnapshot(ctx, Ref{Commit: "c1", Index: 0}, RestoreOptions{})
+	if !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected restore to fail with ErrUnsafePath, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join("..", "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing written outside the working tree, got %v", err)
+	}
+
+	resetStore(t)
+	if _, err := st.Import(ctx, "c1.bundle", ImportOptions{}); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected import to fail with ErrUnsafePath, got %v", err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(".ignoregrets", "snapshots")); len(entries) != 0 {
+		t.Errorf("Expected the failed import to leave the store empty, found %d entries", len(entries))
+	}
+
+	// Archive entries are checked again as they are written
+	hdr := &tar.Header{Name: "../escaped.txt", Mode: 0644, Size: 1}
+	if err := restoreFile(bytes.NewReader([]byte("x")), hdr); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected restoreFile to fail with ErrUnsafePath, got %v", err)
+	}
+}
+
+func TestCheckPath(t *testing.T) {
+	for path, safe := range map[string]bool{
+		".env":                       true,
+		"build/out/app.js":           true,
+		"a/../b":                     true,
+		"../escaped.txt":             false,
+		"a/../../escaped.txt":        false,
+		"/etc/passwd":                false,
+		"":                           false,
+		".ignoregrets/config.yaml":   false,
+		"./.ignoregrets/statcache":   false,
+		"x/../.ignoregrets/snapshot": false,
+	} {
+		if err := checkPath(path); (err == nil) != safe {
+			t.Errorf("checkPath(%q) = %v, want safe=%v", path, err, safe)
+		}
+	}
+}
+
+// writeSSHKey writes a new ed25519 SSH key to path and returns its public
+// key in authorized_keys format
+func writeSSHKey(t *testing.T, path string) []byte {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ssh.MarshalAuthorizedKey(sshPub)
+}
+
+// editBundleHeader rewrites the header of the bundle at path with edit
+func editBundleHeader(t *testing.T, path string, edit func([]byte) []byte) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tr := tar.NewReader(bytes.NewReader(data))
+	tw := tar.NewWriter(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name == bundleHeaderEnt {
+			content = edit(content)
+			hdr.Size = int64(len(content))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBundleSignature(t *testing.T) {
+	st, _, _ := setupChain(t)
+	ctx := context.Background()
+	ref := Ref{Commit: "c1", Index: 0}
+
+	trusted := writeSSHKey(t, "id_ed25519")
+	other := writeSSHKey(t, "id_other")
+	if err := os.WriteFile("trusted", append([]byte("# team keys\n"), trusted...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("untrusted", other, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.Export(ctx, ref, "signed.bundle", ExportOptions{SignKey: "id_ed25519"}); err != nil {
+		t.Fatalf("Failed to export signed bundle: %v", err)
+	}
+	if err := st.Export(ctx, ref, "unsigned.bundle", ExportOptions{}); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	resetStore(t)
+
+	if _, err := st.Import(ctx, "unsigned.bundle", ImportOptions{TrustedKeys: "trusted"}); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("Expected ErrUntrusted for an unsigned bundle, got %v", err)
+	}
+	if _, err := st.Import(ctx, "signed.bundle", ImportOptions{TrustedKeys: "untrusted"}); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("Expected ErrUntrusted for another key, got %v", err)
+	}
+
+	// Any change to the signed header breaks the signature
+	if err := os.WriteFile("edited.bundle", mustRead(t, "signed.bundle"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	editBundleHeader(t, "edited.bundle", func(data []byte) []byte {
+		return bytes.Replace(data, []byte(`"Retention": 5`), []byte(`"Retention": 6`), 1)
+	})
+	if _, err := st.Import(ctx, "edited.bundle", ImportOptions{}); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("Expected ErrBadSignature, got %v", err)
+	}
+
+	result, err := st.Import(ctx, "signed.bundle", ImportOptions{TrustedKeys: "trusted"})
+	if err != nil {
+		t.Fatalf("Failed to import signed bundle: %v", err)
+	}
+	pub, _, _, _, _ := ssh.ParseAuthorizedKey(trusted)
+	if !result.Trusted || result.Signer != ssh.FingerprintSHA256(pub) {
+		t.Errorf("Expected a trusted signature by %s, got %+v", ssh.FingerprintSHA256(pub), result)
+	}
+}
+
+// mustRead returns the contents of a file
+func mustRead(t *testing.T, path string) []byte {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestExportSecrets(t *testing.T) {
+	st := chdirTemp(t)
+	ctx := context.Background()
+
+	if err := os.WriteFile(".env", []byte("AWS_ACCESS_KEY_ID="+fakeAWSKey), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.Secrets = map[string][]Finding{".env": {{Rule: "aws-access-key-id", Line: 1, Action: config.SecretRecord}}}
+	writeTestSnapshot(t, filepath.Join(".ignoregrets", "snapshots", snapshotName(manifest)), []string{".env"}, manifest)
+
+	ref := Ref{Commit: manifest.CommitHash, Index: 0}
+	if err := st.Export(ctx, ref, "out.bundle", ExportOptions{}); !errors.Is(err, ErrSecrets) {
+		t.Errorf("Expected ErrSecrets, got %v", err)
+	}
+	if _, err := os.Stat("out.bundle"); !os.IsNotExist(err) {
+		t.Error("Expected no bundle to be written")
+	}
+	if err := st.Export(ctx, ref, "out.bundle", ExportOptions{AllowSecrets: true}); err != nil {
+		t.Errorf("Failed to export with AllowSecrets: %v", err)
+	}
+}
+
+func TestParseRef(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Ref
+		wantErr bool
+	}{
+		{in: "abc123", want: Ref{Commit: "abc123", Index: Latest}},
+		{in: "abc123:2", want: Ref{Commit: "abc123", Index: 2}},
+		{in: "abc123:latest", want: Ref{Commit: "abc123", Index: Latest}},
+		{in: "", want: Ref{Index: Latest}},
+		{in: "abc123:x", wantErr: true},
+		{in: "abc123:-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRef(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParseRef(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
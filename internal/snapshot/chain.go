@@ -0,0 +1,193 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// maxChainDepth bounds how far parent references are followed, guarding
+// against cycles in hand-edited manifests
+const maxChainDepth = 1000
+
+// chainPaths returns the archive paths of the parents a snapshot depends
+// on, nearest first
+func (st *Store) chainPaths(manifest *Manifest) ([]string, error) {
+	var paths []string
+	for parent := manifest.Parent; parent != nil; parent = manifest.Parent {
+		if len(paths) >= maxChainDepth {
+			return nil, fmt.Errorf("snapshot chain too long at %s", parent)
+		}
+		path, err := st.FindSnapshot(*parent)
+		if err != nil {
+			return nil, fmt.Errorf("missing parent of incremental snapshot: %w", err)
+		}
+		manifest, err = st.LoadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// readChain calls fn for each wanted file of the snapshot at path, reading
+// files missing from an incremental snapshot from its parents. The manifest
+// of the snapshot at path must be passed in.
+func (st *Store) readChain(ctx context.Context, path string, manifest *Manifest, want map[string]bool, fn func(*tar.Header, io.Reader) error) error {
+	remaining := make(map[string]bool, len(want))
+	for name := range want {
+		remaining[name] = true
+	}
+
+	archives := []string{path}
+	if manifest.Parent != nil {
+		parents, err := st.chainPaths(manifest)
+		if err != nil {
+			return err
+		}
+		archives = append(archives, parents...)
+	}
+
+	for _, archive := range archives {
+		if len(remaining) == 0 {
+			break
+		}
+		if err := st.readArchive(ctx, archive, remaining, fn); err != nil {
+			return err
+		}
+	}
+
+	if len(remaining) > 0 {
+		missing := make([]string, 0, len(remaining))
+		for name := range remaining {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("files missing from snapshot chain: %v", missing)
+	}
+	return nil
+}
+
+// readArchive calls fn for each file of the archive listed in remaining,
+// and removes it from remaining. Cancellation is checked between files, so
+// fn always sees whole files.
+func (st *Store) readArchive(ctx context.Context, path string, remaining map[string]bool, fn func(*tar.Header, io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	gr, err := newDecompressor(file, st.keys)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+
+	tr := tar.NewReader(gr)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar header: %w", err)
+		}
+		if hdr.Name == "manifest.json" || !remaining[hdr.Name] {
+			continue
+		}
+		if err := fn(hdr, tr); err != nil {
+			return err
+		}
+		delete(remaining, hdr.Name)
+	}
+}
+
+// Compact rewrites an incremental snapshot as a full snapshot holding all
+// of its files, so it no longer depends on its parents
+func (st *Store) Compact(ctx context.Context, ref Ref) error {
+	path, err := st.FindSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	manifest, err := st.LoadManifest(path)
+	if err != nil {
+		return err
+	}
+	if manifest.Parent == nil {
+		return nil
+	}
+
+	tmp, err := createAtomic(path, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer tmp.Abort()
+
+	full, err := st.writeFull(ctx, tmp, path, manifest)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Commit(); err != nil {
+		return fmt.Errorf("failed to replace snapshot: %w", err)
+	}
+	return st.writeSidecar(path, full)
+}
+
+// writeFull writes the snapshot at path to w as a full snapshot, reading
+// inherited files from its parents, and returns the full snapshot's
+// manifest. The archive keeps the snapshot's codec and encryption.
+func (st *Store) writeFull(ctx context.Context, w io.Writer, path string, manifest *Manifest) (*Manifest, error) {
+	want := make(map[string]bool, len(manifest.Files))
+	for name := range manifest.Files {
+		want[name] = true
+	}
+
+	ew, err := newEncryptor(w, manifest, st.keys)
+	if err != nil {
+		return nil, err
+	}
+	gw, err := newCompressor(ew, manifest.Compression, manifest.compressionLevel(), 1)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gw)
+
+	full := *manifest
+	full.Parent = nil
+	full.Inherited = nil
+	if err := writeManifest(tw, &full); err != nil {
+		return nil, err
+	}
+
+	err = st.readChain(ctx, path, manifest, want, func(hdr *tar.Header, r io.Reader) error {
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if _, err := io.Copy(tw, r); err != nil {
+			return fmt.Errorf("failed to copy file: %s: %w", hdr.Name, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	return &full, nil
+}
@@ -0,0 +1,131 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// setupChain writes a full snapshot of a.txt and b.txt for commit c1, and
+// an incremental child for c2 that stores only the changed b.txt
+func setupChain(t *testing.T) (st *Store, parentPath, childPath string) {
+	st = chdirTemp(t)
+
+	writeFile := func(path, content string) {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	dir := st.Dir()
+
+	writeFile("a.txt", "a1")
+	writeFile("b.txt", "b1")
+	parent, _ := createTestManifest()
+	parent.CommitHash = "c1"
+	parentPath = filepath.Join(dir, "c1_20250101T1000_0.tar.gz")
+	writeTestSnapshot(t, parentPath, []string{"a.txt", "b.txt"}, parent)
+
+	writeFile("b.txt", "b2")
+	child, _ := createTestManifest()
+	child.CommitHash = "c2"
+	child.Parent = &Ref{Commit: "c1", Index: 0}
+	child.Inherited = []string{"a.txt"}
+	child.Files["a.txt"] = parent.Files["a.txt"]
+	childPath = filepath.Join(dir, "c2_20250101T1100_0.tar.gz")
+	writeTestSnapshot(t, childPath, []string{"b.txt"}, child)
+
+	os.Remove("a.txt")
+	os.Remove("b.txt")
+	return st, parentPath, childPath
+}
+
+func TestRestoreIncrementalChain(t *testing.T) {
+	st, _, _ := setupChain(t)
+
+	if err := st.RestoreSnapshot(context.Background(), Ref{Commit: "c2", Index: Latest}, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore incremental snapshot: %v", err)
+	}
+
+	for path, expected := range map[string]string{"a.txt": "a1", "b.txt": "b2"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if string(data) != expected {
+			t.Errorf("Expected %s to contain %q, got %q", path, expected, data)
+		}
+	}
+}
+
+func TestRestoreIncrementalMissingParent(t *testing.T) {
+	st, parentPath, _ := setupChain(t)
+
+	if err := os.Remove(parentPath); err != nil {
+		t.Fatalf("Failed to remove parent: %v", err)
+	}
+	if err := st.RestoreSnapshot(context.Background(), Ref{Commit: "c2", Index: Latest}, RestoreOptions{}); err == nil {
+		t.Error("Expected error when the parent snapshot is missing")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	st, parentPath, childPath := setupChain(t)
+
+	if err := st.Compact(context.Background(), Ref{Commit: "c2", Index: 0}); err != nil {
+		t.Fatalf("Failed to compact: %v", err)
+	}
+
+	manifest, err := st.LoadManifest(childPath)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if manifest.Parent != nil || len(manifest.Inherited) != 0 {
+		t.Errorf("Expected a full snapshot, got parent %v inherited %v", manifest.Parent, manifest.Inherited)
+	}
+
+	// The compacted snapshot restores without its parent
+	if err := os.Remove(parentPath); err != nil {
+		t.Fatalf("Failed to remove parent: %v", err)
+	}
+	if err := st.RestoreSnapshot(context.Background(), Ref{Commit: "c2", Index: Latest}, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore compacted snapshot: %v", err)
+	}
+	if data, err := os.ReadFile("a.txt"); err != nil || string(data) != "a1" {
+		t.Errorf("Expected a.txt from compacted snapshot, got %q (%v)", data, err)
+	}
+}
+
+func TestPruneKeepsNeededParents(t *testing.T) {
+	st, parentPath, childPath := setupChain(t)
+
+	// A newer full snapshot for c1 makes the parent a pruning candidate
+	manifest, _ := createTestManifest()
+	manifest.CommitHash = "c1"
+	manifest.Index = 1
+	if err := os.WriteFile("a.txt", []byte("a3"), 0644); err != nil {
+		t.Fatalf("Failed to write a.txt: %v", err)
+	}
+	newerPath := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1200_1.tar.gz")
+	writeTestSnapshot(t, newerPath, []string{"a.txt"}, manifest)
+
+	if err := st.Prune(context.Background(), PruneOptions{Retention: 1}); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+	if _, err := os.Stat(parentPath); err != nil {
+		t.Errorf("Parent of incremental snapshot was pruned: %v", err)
+	}
+
+	if err := st.Prune(context.Background(), PruneOptions{Retention: 1, Compact: true}); err != nil {
+		t.Fatalf("Failed to prune with compaction: %v", err)
+	}
+	if _, err := os.Stat(parentPath); !os.IsNotExist(err) {
+		t.Error("Expected parent to be pruned after compaction")
+	}
+	for _, path := range []string{childPath, newerPath} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected %s to be kept: %v", path, err)
+		}
+	}
+}
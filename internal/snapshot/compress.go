@@ -0,0 +1,137 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// Magic bytes identifying compressed archives
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// gzipBlockSize is the block size for parallel gzip compression
+const gzipBlockSize = 1 << 20
+
+// archiveExtensions maps each codec to its snapshot file extension
+var archiveExtensions = map[string]string{
+	config.CompressionGzip: ".tar.gz",
+	config.CompressionZstd: ".tar.zst",
+	config.CompressionNone: ".tar",
+}
+
+// archiveExtension returns the file extension for a codec
+func archiveExtension(codec string) string {
+	if ext, ok := archiveExtensions[codec]; ok {
+		return ext
+	}
+	return archiveExtensions[config.CompressionGzip]
+}
+
+// trimArchiveExtension strips a snapshot archive extension, including
+// .age for encrypted archives, from name and reports whether it had one
+func trimArchiveExtension(name string) (string, bool) {
+	plain := strings.TrimSuffix(name, encryptedExtension)
+
+	// Check longer extensions first, since .tar is a prefix of the others
+	for _, ext := range []string{".tar.gz", ".tar.zst", ".tar"} {
+		if strings.HasSuffix(plain, ext) {
+			return strings.TrimSuffix(plain, ext), true
+		}
+	}
+	return name, false
+}
+
+// IsArchive reports whether a file name is a snapshot archive
+func IsArchive(name string) bool {
+	_, ok := trimArchiveExtension(name)
+	return ok
+}
+
+// newCompressor wraps w with the codec's compressor, compressing blocks on
+// up to jobs goroutines. A level of 0 selects the codec's default level.
+func newCompressor(w io.Writer, codec string, level, jobs int) (io.WriteCloser, error) {
+	if jobs < 1 {
+		jobs = 1
+	}
+
+	switch codec {
+	case config.CompressionGzip, "":
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		gw, err := pgzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip writer: %w", err)
+		}
+		if err := gw.SetConcurrency(gzipBlockSize, jobs); err != nil {
+			return nil, fmt.Errorf("failed to configure gzip writer: %w", err)
+		}
+		return gw, nil
+	case config.CompressionZstd:
+		opts := []zstd.EOption{zstd.WithEncoderConcurrency(jobs)}
+		if level != 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		zw, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %w", err)
+		}
+		return zw, nil
+	case config.CompressionNone:
+		return nopWriteCloser{w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", codec)
+	}
+}
+
+// newDecompressor detects the archive format from its magic bytes and
+// returns a reader for the uncompressed tar stream. Encrypted archives are
+// decrypted as they are read, using the keys in keys.
+func newDecompressor(r io.Reader, keys *keyring) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(ageMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read archive header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, ageMagic):
+		dr, err := decrypt(br, keys)
+		if err != nil {
+			return nil, err
+		}
+		return newDecompressor(dr, keys)
+	case bytes.HasPrefix(magic, gzipMagic):
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		return gr, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return io.NopCloser(br), nil
+	}
+}
+
+// nopWriteCloser adds a no-op Close to an io.Writer
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
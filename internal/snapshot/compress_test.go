@@ -0,0 +1,72 @@
+package snapshot
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte("ignoregrets "), 1000)
+
+	for _, codec := range []string{config.CompressionGzip, config.CompressionZstd, config.CompressionNone} {
+		for _, level := range []int{0, 1} {
+			if codec == config.CompressionNone && level != 0 {
+				continue
+			}
+			var buf bytes.Buffer
+			w, err := newCompressor(&buf, codec, level, 2)
+			if err != nil {
+				t.Fatalf("%s: failed to create compressor: %v", codec, err)
+			}
+			if _, err := w.Write(payload); err != nil {
+				t.Fatalf("%s: failed to write: %v", codec, err)
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("%s: failed to close: %v", codec, err)
+			}
+
+			// The reader detects the codec on its own
+			r, err := newDecompressor(&buf, nil)
+			if err != nil {
+				t.Fatalf("%s: failed to create decompressor: %v", codec, err)
+			}
+			data, err := io.ReadAll(r)
+			r.Close()
+			if err != nil {
+				t.Fatalf("%s: failed to read: %v", codec, err)
+			}
+			if !bytes.Equal(data, payload) {
+				t.Errorf("%s level %d: round trip mismatch", codec, level)
+			}
+		}
+	}
+}
+
+func TestArchiveExtensions(t *testing.T) {
+	tests := []struct {
+		name    string
+		base    string
+		archive bool
+	}{
+		{"abc_20250101T1000_0.tar.gz", "abc_20250101T1000_0", true},
+		{"abc_20250101T1000_0.tar.zst", "abc_20250101T1000_0", true},
+		{"abc_20250101T1000_0.tar", "abc_20250101T1000_0", true},
+		{"abc_20250101T1000_0.tar.gz.tmp", "abc_20250101T1000_0.tar.gz.tmp", false},
+		{"abc_20250101T1000_0.tar.zst.age", "abc_20250101T1000_0", true},
+		{"abc_20250101T1000_0.age", "abc_20250101T1000_0.age", false},
+	}
+
+	for _, tt := range tests {
+		base, ok := trimArchiveExtension(tt.name)
+		if ok != tt.archive || base != tt.base {
+			t.Errorf("trimArchiveExtension(%q) = %q, %v; want %q, %v", tt.name, base, ok, tt.base, tt.archive)
+		}
+	}
+
+	if ext := archiveExtension(config.CompressionZstd); ext != ".tar.zst" {
+		t.Errorf("Expected .tar.zst for zstd, got %s", ext)
+	}
+}
@@ -0,0 +1,370 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// ageMagic starts every age-encrypted file
+var ageMagic = []byte("age-encryption.org/v1\n")
+
+// encryptedExtension follows the codec's extension on encrypted archives
+const encryptedExtension = ".age"
+
+// Environment variables that supply keys without prompting, e.g. in hooks
+const (
+	PassphraseEnv = "IGNOREGRETS_PASSPHRASE"
+	IdentityEnv   = "IGNOREGRETS_IDENTITY" // path to an age identity file
+)
+
+// ErrNoKey is returned when an encrypted snapshot is read without a key
+// that can decrypt it
+var ErrNoKey = errors.New("no key to decrypt snapshot")
+
+// PassphraseFunc asks the user for the snapshot passphrase. With confirm
+// set, a new passphrase is being chosen and should be asked for twice.
+type PassphraseFunc func(confirm bool) (string, error)
+
+// Keys tells the store where to find the secrets for encrypted snapshots
+type Keys struct {
+	// IdentityFile holds the age identities for recipient-encrypted
+	// snapshots; IdentityEnv overrides it
+	IdentityFile string
+
+	// Passphrase is called when a passphrase is needed and PassphraseEnv is
+	// not set. If it is nil, reading a passphrase-encrypted snapshot fails.
+	Passphrase PassphraseFunc
+}
+
+// keyring holds a store's Keys and the secrets loaded from them, so the
+// user is asked at most once per store
+type keyring struct {
+	sync.Mutex
+	dir        string // the store's snapshots, to check passphrases against
+	keys       Keys
+	passphrase string
+	identities []age.Identity
+
+	// checked is set once passphrase is known to decrypt the store's
+	// passphrase-encrypted snapshots
+	checked bool
+}
+
+// SetKeys configures where the store finds keys for encrypted snapshots
+// and forgets any loaded before
+func (st *Store) SetKeys(keys Keys) {
+	st.keys.Lock()
+	defer st.keys.Unlock()
+	st.keys.keys = keys
+	st.keys.passphrase = ""
+	st.keys.identities = nil
+	st.keys.checked = false
+}
+
+// getPassphrase returns the snapshot passphrase, asking for it if needed
+func (k *keyring) getPassphrase(confirm bool) (string, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.passphrase != "" {
+		return k.passphrase, nil
+	}
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		k.passphrase = p
+		return p, nil
+	}
+	if k.keys.Passphrase == nil {
+		return "", fmt.Errorf("%w: set %s", ErrNoKey, PassphraseEnv)
+	}
+	p, err := k.keys.Passphrase(confirm)
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", fmt.Errorf("passphrase must not be empty")
+	}
+	k.passphrase = p
+	return p, nil
+}
+
+// forgetPassphrase drops a passphrase that failed to decrypt a snapshot
+func (k *keyring) forgetPassphrase() {
+	k.Lock()
+	defer k.Unlock()
+	k.passphrase = ""
+	k.checked = false
+}
+
+// encryptPassphrase returns the passphrase to encrypt a snapshot with. If
+// the store has passphrase-encrypted snapshots, the passphrase must
+// decrypt the newest of them, so one mistyped passphrase can't split the
+// store; otherwise a new passphrase is chosen and confirmed.
+func (k *keyring) encryptPassphrase() (string, error) {
+	k.Lock()
+	p, checked := k.passphrase, k.checked
+	k.Unlock()
+	if p != "" && checked {
+		return p, nil
+	}
+
+	newest := newestPassphraseArchive(k.dir)
+	p, err := k.getPassphrase(newest == "")
+	if err != nil {
+		return "", err
+	}
+	if newest != "" {
+		if err := checkPassphrase(newest, p); err != nil {
+			k.forgetPassphrase()
+			return "", err
+		}
+	}
+	k.setChecked()
+	return p, nil
+}
+
+// setChecked records that the loaded passphrase matches the store
+func (k *keyring) setChecked() {
+	k.Lock()
+	defer k.Unlock()
+	k.checked = k.passphrase != ""
+}
+
+// newestPassphraseArchive returns the path of the newest snapshot archive
+// in dir that is encrypted with a passphrase, or "" if there is none
+func newestPassphraseArchive(dir string) string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+	var snapshots []snapshotFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), encryptedExtension) {
+			continue
+		}
+		if s, ok := parseSnapshotName(filepath.Join(dir, entry.Name())); ok {
+			snapshots = append(snapshots, s)
+		}
+	}
+	sortNewestFirst(snapshots)
+	for _, s := range snapshots {
+		if usesPassphrase(s.path) {
+			return s.path
+		}
+	}
+	return ""
+}
+
+// usesPassphrase reports whether the age-encrypted file at path is
+// encrypted with a passphrase rather than to recipients, from its header
+func usesPassphrase(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+	br := bufio.NewReader(file)
+	if magic, err := br.ReadString('\n'); err != nil || magic != string(ageMagic) {
+		return false
+	}
+	stanza, _ := br.ReadString('\n')
+	return strings.HasPrefix(stanza, "-> scrypt ")
+}
+
+// checkPassphrase checks that passphrase decrypts the archive at path.
+// Only the header is decrypted.
+func checkPassphrase(path, passphrase string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+	identity, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return err
+	}
+	if _, err := age.Decrypt(file, identity); err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return fmt.Errorf("incorrect passphrase: it does not decrypt %s, the newest snapshot encrypted with a passphrase", filepath.Base(path))
+		}
+		return fmt.Errorf("failed to check passphrase against %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+// fileIdentities loads the age identities from the identity file
+func (k *keyring) fileIdentities() ([]age.Identity, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	if k.identities != nil {
+		return k.identities, nil
+	}
+	path := os.Getenv(IdentityEnv)
+	if path == "" {
+		path = k.keys.IdentityFile
+	}
+	if path == "" {
+		return nil, fmt.Errorf("%w: set identity_file or %s", ErrNoKey, IdentityEnv)
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find home directory: %w", err)
+		}
+		path = filepath.Join(home, rest)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open identity file: %w", err)
+	}
+	defer file.Close()
+	identities, err := age.ParseIdentities(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read identity file %s: %w", path, err)
+	}
+	k.identities = identities
+	return identities, nil
+}
+
+// lazyIdentity loads the key for an archive from the keyring when it is
+// first needed, so the user is only asked for a passphrase when one is
+// actually used
+type lazyIdentity struct {
+	keys *keyring
+}
+
+// Unwrap implements age.Identity
+func (l lazyIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	if len(stanzas) == 1 && stanzas[0].Type == "scrypt" {
+		p, err := l.keys.getPassphrase(false)
+		if err != nil {
+			return nil, err
+		}
+		identity, err := age.NewScryptIdentity(p)
+		if err != nil {
+			return nil, err
+		}
+		key, err := identity.Unwrap(stanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			l.keys.forgetPassphrase()
+			return nil, fmt.Errorf("incorrect passphrase")
+		}
+		return key, err
+	}
+
+	identities, err := l.keys.fileIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, identity := range identities {
+		key, err := identity.Unwrap(stanzas)
+		if errors.Is(err, age.ErrIncorrectIdentity) {
+			continue
+		}
+		return key, err
+	}
+	return nil, fmt.Errorf("%w: no identity in the identity file matches", ErrNoKey)
+}
+
+// encrypted reports whether a snapshot's archive is encrypted
+func (m *Manifest) encrypted() bool {
+	return m.Encryption != "" && m.Encryption != config.EncryptionNone
+}
+
+// recipients returns the age recipients to encrypt a snapshot to, taken
+// from the configuration it was created with or, for a passphrase, from
+// keys
+func (m *Manifest) recipients(keys *keyring) ([]age.Recipient, error) {
+	switch m.Encryption {
+	case config.EncryptionPassphrase:
+		p, err := keys.encryptPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		recipient, err := age.NewScryptRecipient(p)
+		if err != nil {
+			return nil, err
+		}
+		return []age.Recipient{recipient}, nil
+	case config.EncryptionAge:
+		if m.Config == nil || len(m.Config.Recipients) == 0 {
+			return nil, fmt.Errorf("no recipients to encrypt the snapshot to")
+		}
+		var recipients []age.Recipient
+		for _, r := range m.Config.Recipients {
+			recipient, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				return nil, fmt.Errorf("invalid recipient %s: %w", r, err)
+			}
+			recipients = append(recipients, recipient)
+		}
+		return recipients, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption: %s", m.Encryption)
+	}
+}
+
+// newEncryptor wraps w to encrypt the snapshot's archive, if it is
+// encrypted. Closing the writer does not close w.
+func newEncryptor(w io.Writer, m *Manifest, keys *keyring) (io.WriteCloser, error) {
+	if !m.encrypted() {
+		return nopWriteCloser{w}, nil
+	}
+	recipients, err := m.recipients(keys)
+	if err != nil {
+		return nil, err
+	}
+	ew, err := age.Encrypt(w, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt snapshot: %w", err)
+	}
+	return ew, nil
+}
+
+// decrypt returns a reader for the plaintext of an age-encrypted stream,
+// using the keys in keys
+func decrypt(r io.Reader, keys *keyring) (io.Reader, error) {
+	dr, err := age.Decrypt(r, lazyIdentity{keys})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot: %w", err)
+	}
+	return dr, nil
+}
+
+// encryptBytes encrypts data as the snapshot's archive is encrypted
+func encryptBytes(data []byte, m *Manifest, keys *keyring) ([]byte, error) {
+	var buf bytes.Buffer
+	ew, err := newEncryptor(&buf, m, keys)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ew.Write(data); err != nil {
+		return nil, err
+	}
+	if err := ew.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decryptBytes decrypts an age-encrypted buffer
+func decryptBytes(data []byte, keys *keyring) ([]byte, error) {
+	dr, err := decrypt(bytes.NewReader(data), keys)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(dr)
+}
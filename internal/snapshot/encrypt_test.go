@@ -0,0 +1,188 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// writeEncryptedSnapshot snapshots a.txt into st with the given encryption
+// and returns the archive path
+func writeEncryptedSnapshot(t *testing.T, st *Store, encryption string, cfg *config.Config) string {
+	t.Helper()
+	if err := os.WriteFile("a.txt", []byte("SECRET=hunter2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	checksums, err := ChecksumFiles(context.Background(), []string{"a.txt"}, 1, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, _ := createTestManifest()
+	manifest.Config = cfg
+	manifest.Files = checksums
+	manifest.Compression = config.CompressionNone
+	manifest.Encryption = encryption
+	path := filepath.Join(".ignoregrets", "snapshots", snapshotName(manifest))
+	if err := st.writeSnapshot(context.Background(), path, manifest, []string{"a.txt"}, 0, 1, nil); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	// Nothing is readable without the key, even uncompressed
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, ageMagic) || bytes.Contains(data, []byte("hunter2")) {
+		t.Errorf("Expected an encrypted archive")
+	}
+	return path
+}
+
+// readSnapshotFile returns the contents of name in the archive at path,
+// read with the keys of st
+func readSnapshotFile(st *Store, path, name string) (string, error) {
+	var content string
+	err := st.readArchive(context.Background(), path, map[string]bool{name: true}, func(hdr *tar.Header, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		content = string(data)
+		return err
+	})
+	return content, err
+}
+
+func TestEncryptedSnapshotRecipients(t *testing.T) {
+	st := chdirTemp(t)
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identityFile := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{
+		Encryption:      config.EncryptionAge,
+		Recipients:      []string{identity.Recipient().String()},
+		EncryptManifest: true,
+	}
+	path := writeEncryptedSnapshot(t, st, config.EncryptionAge, cfg)
+	if !strings.HasSuffix(path, ".tar.age") {
+		t.Errorf("Expected a .tar.age archive, got %s", path)
+	}
+
+	// The sidecar is encrypted too
+	sidecar, err := os.ReadFile(sidecarPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(sidecar, ageMagic) {
+		t.Error("Expected an encrypted manifest sidecar")
+	}
+
+	if _, err := st.LoadManifest(path); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without an identity, got %v", err)
+	}
+
+	st.SetKeys(Keys{IdentityFile: identityFile})
+	manifest, err := st.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if manifest.Encryption != config.EncryptionAge || len(manifest.Files) != 1 {
+		t.Errorf("Unexpected manifest: %+v", manifest)
+	}
+	content, err := readSnapshotFile(st, path, "a.txt")
+	if err != nil || content != "SECRET=hunter2" {
+		t.Errorf("Expected decrypted content, got %q, %v", content, err)
+	}
+}
+
+func TestEncryptedSnapshotPassphrase(t *testing.T) {
+	st := chdirTemp(t)
+
+	asked := 0
+	ask := func(answer string) PassphraseFunc {
+		return func(confirm bool) (string, error) {
+			asked++
+			return answer, nil
+		}
+	}
+
+	cfg := &config.Config{Encryption: config.EncryptionPassphrase}
+	st.SetKeys(Keys{Passphrase: ask("correct horse")})
+	path := writeEncryptedSnapshot(t, st, config.EncryptionPassphrase, cfg)
+
+	// The manifest sidecar is plain unless encrypt_manifest is set
+	if _, err := st.LoadManifest(path); err != nil {
+		t.Errorf("Failed to load manifest from the sidecar: %v", err)
+	}
+
+	st.SetKeys(Keys{Passphrase: ask("battery staple")})
+	if _, err := readSnapshotFile(st, path, "a.txt"); err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("Expected an incorrect passphrase error, got %v", err)
+	}
+
+	st.SetKeys(Keys{})
+	t.Setenv(PassphraseEnv, "correct horse")
+	content, err := readSnapshotFile(st, path, "a.txt")
+	if err != nil || content != "SECRET=hunter2" {
+		t.Errorf("Expected decrypted content, got %q, %v", content, err)
+	}
+	if asked != 2 {
+		t.Errorf("Expected the passphrase to be asked for twice, got %d", asked)
+	}
+}
+
+func TestPassphraseCheckedAgainstSnapshots(t *testing.T) {
+	st := chdirTemp(t)
+
+	var confirms []bool
+	ask := func(answer string) PassphraseFunc {
+		return func(confirm bool) (string, error) {
+			confirms = append(confirms, confirm)
+			return answer, nil
+		}
+	}
+
+	cfg := &config.Config{Encryption: config.EncryptionPassphrase}
+	st.SetKeys(Keys{Passphrase: ask("correct horse")})
+	writeEncryptedSnapshot(t, st, config.EncryptionPassphrase, cfg)
+
+	// A new process must not start a second passphrase in the same store
+	manifest, _ := createTestManifest()
+	manifest.Config = cfg
+	manifest.Index = 1
+	manifest.Files = map[string]string{"a.txt": checksumOf("SECRET=hunter2")}
+	manifest.Compression = config.CompressionNone
+	manifest.Encryption = config.EncryptionPassphrase
+	path := filepath.Join(".ignoregrets", "snapshots", snapshotName(manifest))
+	st.SetKeys(Keys{Passphrase: ask("battery staple")})
+	err := st.writeSnapshot(context.Background(), path, manifest, []string{"a.txt"}, 0, 1, nil)
+	if err == nil || !strings.Contains(err.Error(), "incorrect passphrase") {
+		t.Errorf("Expected an incorrect passphrase error, got %v", err)
+	}
+
+	st.SetKeys(Keys{Passphrase: ask("correct horse")})
+	if err := st.writeSnapshot(context.Background(), path, manifest, []string{"a.txt"}, 0, 1, nil); err != nil {
+		t.Errorf("Failed to write snapshot with the store's passphrase: %v", err)
+	}
+
+	// Only the first passphrase in an empty store is confirmed
+	if want := []bool{true, false, false}; !reflect.DeepEqual(confirms, want) {
+		t.Errorf("Expected confirmations %v, got %v", want, confirms)
+	}
+}
@@ -0,0 +1,34 @@
+package snapshot
+
+// Phases reported in Progress
+const (
+	PhaseHashing   = "hashing"
+	PhaseArchiving = "archiving"
+	PhaseRestoring = "restoring"
+)
+
+// Progress reports how far a snapshot or restore has got
+type Progress struct {
+	// Phase is one of the Phase* values
+	Phase string
+
+	// Files and Total count the files done and the files in this phase
+	Files int
+	Total int
+
+	// Bytes is the size of the files done
+	Bytes int64
+
+	// Path is the file just done
+	Path string
+}
+
+// ProgressFunc receives progress updates. It is never called concurrently.
+type ProgressFunc func(Progress)
+
+// report calls fn, if set
+func (fn ProgressFunc) report(p Progress) {
+	if fn != nil {
+		fn(p)
+	}
+}
@@ -0,0 +1,205 @@
+package snapshot
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PruneOptions controls how Prune deletes snapshots
+type PruneOptions struct {
+	// Retention is the number of snapshots to keep per commit
+	Retention int
+
+	// Profiles holds the retention of the snapshots of named profiles,
+	// which are counted separately from other snapshots of the commit.
+	// Profiles not listed use Retention, and config.KeepAll keeps them all.
+	Profiles map[string]int
+
+	// Compact rewrites kept incremental snapshots as full snapshots when
+	// their parents would otherwise be pruned, so the parents can go
+	Compact bool
+}
+
+// Prune deletes old snapshots, keeping the latest opts.Retention per commit
+// and profile.
+// Snapshots that a kept incremental snapshot still needs as a parent are
+// never deleted unless the incremental snapshot is compacted first.
+func (st *Store) Prune(ctx context.Context, opts PruneOptions) error {
+	if opts.Retention < 1 {
+		return fmt.Errorf("retention must be greater than 0")
+	}
+
+	// Get all snapshots
+	dir := st.dir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+
+	// Group snapshots by commit and profile. Profiles are only read from
+	// the manifests when some are configured.
+	groups := make(map[pruneGroup][]snapshotFile)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsArchive(entry.Name()) {
+			continue
+		}
+		s, ok := parseSnapshotName(filepath.Join(dir, entry.Name()))
+		if !ok {
+			continue
+		}
+		group := pruneGroup{commit: s.commit}
+		if len(opts.Profiles) > 0 {
+			manifest, err := st.LoadManifest(s.path)
+			if err != nil {
+				return fmt.Errorf("failed to read manifest from %s: %w", entry.Name(), err)
+			}
+			group.profile = manifest.Profile
+		}
+		groups[group] = append(groups[group], s)
+	}
+
+	// Split into kept snapshots and pruning candidates
+	var kept []snapshotFile
+	candidates := make(map[string]bool)
+	retention := make(map[pruneGroup]int, len(groups))
+	for group, snapshots := range groups {
+		sortNewestFirst(snapshots)
+		keep := opts.Retention
+		if n, ok := opts.Profiles[group.profile]; ok {
+			keep = n
+		}
+		if keep < 0 {
+			keep = len(snapshots)
+		}
+		retention[group] = keep
+		for i, s := range snapshots {
+			if i < keep {
+				kept = append(kept, s)
+			} else {
+				candidates[s.path] = true
+			}
+		}
+	}
+
+	needed, err := st.neededParents(ctx, kept, candidates, opts.Compact)
+	if err != nil {
+		return err
+	}
+
+	// Delete older snapshots
+	order := make([]pruneGroup, 0, len(groups))
+	for group := range groups {
+		order = append(order, group)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		if order[i].commit != order[j].commit {
+			return order[i].commit < order[j].commit
+		}
+		return order[i].profile < order[j].profile
+	})
+
+	held := 0
+	for _, group := range order {
+		snapshots := groups[group]
+		if len(snapshots) <= retention[group] {
+			continue
+		}
+		if group.profile != "" {
+			fmt.Printf("Pruning %s snapshots for commit %s:\n", group.profile, group.commit)
+		} else {
+			fmt.Printf("Pruning snapshots for commit %s:\n", group.commit)
+		}
+		for _, s := range snapshots[retention[group]:] {
+			name := filepath.Base(s.path)
+			if needed[s.path] {
+				fmt.Printf("  Keeping %s (parent of an incremental snapshot)\n", name)
+				held++
+				continue
+			}
+			fmt.Printf("  Deleting %s\n", name)
+			if err := os.Remove(s.path); err != nil {
+				return fmt.Errorf("failed to delete snapshot %s: %w", name, err)
+			}
+			if err := os.Remove(sidecarPath(s.path)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete manifest sidecar of %s: %w", name, err)
+			}
+		}
+	}
+
+	removeOrphanSidecars(dir, entries)
+	removeStaleTemps(dir, entries)
+
+	if held > 0 {
+		fmt.Printf("\n%d snapshot(s) kept for incremental snapshots. Run 'ignoregrets prune --compact'\n", held)
+		fmt.Println("to merge those chains into full snapshots so the parents can be pruned.")
+	}
+
+	return nil
+}
+
+// pruneGroup is a set of snapshots that share a retention count
+type pruneGroup struct {
+	commit, profile string
+}
+
+// neededParents returns the archive paths that kept incremental snapshots
+// depend on. With compact, kept snapshots that depend on a pruning candidate
+// are compacted instead.
+func (st *Store) neededParents(ctx context.Context, kept []snapshotFile, candidates map[string]bool, compact bool) (map[string]bool, error) {
+	needed := make(map[string]bool)
+	for _, s := range kept {
+		manifest, err := st.LoadManifest(s.path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read manifest from %s: %w", filepath.Base(s.path), err)
+		}
+		if manifest.Parent == nil {
+			continue
+		}
+
+		chain, err := st.chainPaths(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(s.path), err)
+		}
+
+		if compact && dependsOnAny(chain, candidates) {
+			fmt.Printf("Compacting %s into a full snapshot\n", filepath.Base(s.path))
+			if err := st.Compact(ctx, Ref{Commit: s.commit, Index: s.index}); err != nil {
+				return nil, fmt.Errorf("failed to compact %s: %w", filepath.Base(s.path), err)
+			}
+			continue
+		}
+
+		for _, path := range chain {
+			needed[path] = true
+		}
+	}
+	return needed, nil
+}
+
+// dependsOnAny reports whether any path of chain is in set
+func dependsOnAny(chain []string, set map[string]bool) bool {
+	for _, path := range chain {
+		if set[path] {
+			return true
+		}
+	}
+	return false
+}
+
+// removeOrphanSidecars deletes manifest sidecars whose archive is gone
+func removeOrphanSidecars(dir string, entries []os.DirEntry) {
+	for _, entry := range entries {
+		if entry.IsDir() || !isSidecar(entry.Name()) {
+			continue
+		}
+		base := strings.TrimSuffix(entry.Name(), sidecarExtension)
+		matches, _ := filepath.Glob(filepath.Join(dir, base+".tar*"))
+		if len(matches) == 0 {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
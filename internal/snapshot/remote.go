@@ -0,0 +1,277 @@
+package snapshot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+)
+
+// SyncResult reports what Push or Pull did
+type SyncResult struct {
+	// Transferred lists the objects copied, archives and sidecars
+	Transferred []string
+	Bytes       int64
+
+	// Conflicts lists archives not copied because the destination has a
+	// different snapshot with the same commit and index
+	Conflicts []string
+
+	// Present counts the snapshots the destination already had
+	Present int
+}
+
+// SyncFunc receives each object after it is copied
+type SyncFunc func(obj backend.Object)
+
+// LocalBackend returns the store as a backend
+func (st *Store) LocalBackend() *backend.Local {
+	return backend.NewLocal(st.dir)
+}
+
+// Push copies the snapshots missing from remote to it
+func (st *Store) Push(ctx context.Context, remote backend.Backend, fn SyncFunc) (*SyncResult, error) {
+	return syncSnapshots(ctx, st.LocalBackend(), remote, fn, nil)
+}
+
+// Pull copies the snapshots missing from the local store from remote.
+// The remote is not trusted: each pulled snapshot is checked with
+// checkPulled, and what was pulled is removed again if it fails. A local
+// archive that only got its sidecar from remote keeps the archive.
+func (st *Store) Pull(ctx context.Context, remote backend.Backend, fn SyncFunc) (*SyncResult, error) {
+	local := st.LocalBackend()
+	return syncSnapshots(ctx, remote, local, fn, func(name string, copied bool) error {
+		path := filepath.Join(local.Dir(), name)
+		if err := st.checkPulled(path); err != nil {
+			if copied {
+				os.Remove(path)
+			}
+			os.Remove(sidecarPath(path))
+			return fmt.Errorf("pulled snapshot %s: %w", name, err)
+		}
+		return nil
+	})
+}
+
+// checkPulled checks a snapshot archive copied from a remote, and its
+// sidecar if one was copied: the archive's manifest must match its name
+// and have only safe file paths, and the sidecar must match the archive's
+// manifest, since LoadManifest trusts it. Without the key to an encrypted
+// archive, the sidecar can't be checked and is removed instead, so it is
+// rebuilt from the archive when the snapshot is next read.
+func (st *Store) checkPulled(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+	archived, err := st.ReadManifest(file)
+	file.Close()
+	if errors.Is(err, ErrNoKey) {
+		return removeSidecar(path)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	named, _ := parseSnapshotName(path)
+	if archived.CommitHash != named.commit || archived.Index != named.index {
+		return fmt.Errorf("%w: archive manifest does not match its name", ErrCorrupt)
+	}
+	if err := checkPaths(archived); err != nil {
+		return err
+	}
+
+	if _, err := os.Stat(sidecarPath(path)); os.IsNotExist(err) {
+		return nil
+	}
+	sidecar, err := st.readSidecar(path, info)
+	if errors.Is(err, ErrNoKey) {
+		return removeSidecar(path)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: manifest sidecar: %v", ErrCorrupt, err)
+	}
+	want, err := json.Marshal(archived)
+	if err != nil {
+		return err
+	}
+	got, err := json.Marshal(sidecar)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("%w: manifest sidecar does not match the archive", ErrCorrupt)
+	}
+	return nil
+}
+
+// removeSidecar removes the sidecar of a snapshot archive, if any
+func removeSidecar(archive string) error {
+	if err := os.Remove(sidecarPath(archive)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove manifest sidecar: %w", err)
+	}
+	return nil
+}
+
+// syncSnapshots copies the archives missing from dst, each followed by its
+// manifest sidecar, so a sidecar is never older than its archive. Objects
+// are compared by name only; archives are never changed once written.
+// Objects with a path in their name are skipped. If check is set, it is
+// called with the name of each archive once it and its sidecar are in dst,
+// and whether the archive itself was copied or only its sidecar.
+func syncSnapshots(ctx context.Context, src, dst backend.Backend, fn SyncFunc, check func(name string, copied bool) error) (*SyncResult, error) {
+	srcObjects, err := src.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dstObjects, err := dst.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	have := make(map[string]bool, len(dstObjects))
+	haveRefs := make(map[Ref]string)
+	for _, obj := range dstObjects {
+		have[obj.Name] = true
+		if s, ok := parseSnapshotName(obj.Name); ok {
+			haveRefs[Ref{Commit: s.commit, Index: s.index}] = obj.Name
+		}
+	}
+	sidecars := make(map[string]backend.Object)
+	var objects []backend.Object
+	for _, obj := range srcObjects {
+		if strings.ContainsAny(obj.Name, `/\`) {
+			continue
+		}
+		objects = append(objects, obj)
+		if isSidecar(obj.Name) {
+			sidecars[obj.Name] = obj
+		}
+	}
+
+	result := &SyncResult{}
+	var missing []backend.Object
+	refs := make(map[string]Ref)
+	blocked := make(map[Ref]bool)
+	for _, obj := range objects {
+		s, ok := parseSnapshotName(obj.Name)
+		if !ok {
+			continue
+		}
+		ref := Ref{Commit: s.commit, Index: s.index}
+		switch name, taken := haveRefs[ref]; {
+		case have[obj.Name]:
+			result.Present++
+			// Fill in a sidecar the destination lacks
+			if sidecar, ok := sidecars[sidecarPath(obj.Name)]; ok && !have[sidecar.Name] {
+				if err := copyObject(ctx, src, dst, sidecar, result, fn); err != nil {
+					return result, err
+				}
+				if check != nil {
+					if err := check(obj.Name, false); err != nil {
+						return result, err
+					}
+				}
+			}
+		case taken:
+			result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s (destination has %s)", obj.Name, name))
+			blocked[ref] = true
+		default:
+			missing = append(missing, obj)
+			refs[obj.Name] = ref
+		}
+	}
+
+	// An incremental snapshot whose parent conflicts would be restored
+	// from the wrong parent, so it is not copied either
+	parents := remoteParents(ctx, src, missing, sidecars)
+	for changed := true; changed; {
+		changed = false
+		for _, obj := range missing {
+			ref := refs[obj.Name]
+			if parent, ok := parents[obj.Name]; ok && blocked[parent] && !blocked[ref] {
+				result.Conflicts = append(result.Conflicts, fmt.Sprintf("%s (its parent %s conflicts)", obj.Name, parent))
+				blocked[ref] = true
+				changed = true
+			}
+		}
+	}
+
+	for _, obj := range missing {
+		if blocked[refs[obj.Name]] {
+			continue
+		}
+		if err := copyObject(ctx, src, dst, obj, result, fn); err != nil {
+			return result, err
+		}
+		if sidecar, ok := sidecars[sidecarPath(obj.Name)]; ok {
+			if err := copyObject(ctx, src, dst, sidecar, result, fn); err != nil {
+				return result, err
+			}
+		}
+		if check != nil {
+			if err := check(obj.Name, true); err != nil {
+				return result, err
+			}
+		}
+	}
+	return result, nil
+}
+
+// remoteParents returns the parents of the incremental snapshots among
+// archives, read from their sidecars. Archives without a readable sidecar,
+// e.g. an encrypted one, are left out.
+func remoteParents(ctx context.Context, src backend.Backend, archives []backend.Object, sidecars map[string]backend.Object) map[string]Ref {
+	parents := make(map[string]Ref)
+	for _, obj := range archives {
+		sidecar, ok := sidecars[sidecarPath(obj.Name)]
+		if !ok {
+			continue
+		}
+		r, err := src.Get(ctx, sidecar.Name)
+		if err != nil {
+			continue
+		}
+		data, err := io.ReadAll(io.LimitReader(r, 64<<20))
+		r.Close()
+		if err != nil || bytes.HasPrefix(data, ageMagic) {
+			continue
+		}
+		manifest, err := decodeManifest(data)
+		if err == nil && manifest.Parent != nil {
+			parents[obj.Name] = *manifest.Parent
+		}
+	}
+	return parents
+}
+
+// copyObject copies one object from src to dst
+func copyObject(ctx context.Context, src, dst backend.Backend, obj backend.Object, result *SyncResult, fn SyncFunc) error {
+	r, err := src.Get(ctx, obj.Name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := dst.Put(ctx, obj.Name, r, obj.Size); err != nil {
+		return err
+	}
+
+	result.Transferred = append(result.Transferred, obj.Name)
+	result.Bytes += obj.Size
+	if fn != nil {
+		fn(obj)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+)
+
+func TestPushPull(t *testing.T) {
+	st, parentPath, _ := setupChain(t)
+	ctx := context.Background()
+
+	// Give the parent a sidecar, as LoadManifest would
+	if _, err := st.LoadManifest(parentPath); err != nil {
+		t.Fatal(err)
+	}
+	remote := backend.NewLocal(filepath.Join(t.TempDir(), "remote"))
+
+	var copied []string
+	result, err := st.Push(ctx, remote, func(obj backend.Object) { copied = append(copied, obj.Name) })
+	if err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	want := []string{"c1_20250101T1000_0.tar.gz", "c1_20250101T1000_0.manifest.json", "c2_20250101T1100_0.tar.gz"}
+	if len(copied) != len(want) {
+		t.Fatalf("Expected %v to be pushed, got %v", want, copied)
+	}
+	for i := range want {
+		if copied[i] != want[i] {
+			t.Errorf("Expected %v to be pushed in order, got %v", want, copied)
+			break
+		}
+	}
+	if len(result.Transferred) != 3 || result.Bytes == 0 {
+		t.Errorf("Unexpected push result %+v", result)
+	}
+
+	// Only the missing sidecar is pushed the second time
+	if _, err := st.LoadManifest(filepath.Join(st.Dir(), "c2_20250101T1100_0.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	result, err = st.Push(ctx, remote, nil)
+	if err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+	if len(result.Transferred) != 1 || result.Transferred[0] != "c2_20250101T1100_0.manifest.json" || result.Present != 2 {
+		t.Errorf("Expected only the new sidecar to be pushed, got %+v", result)
+	}
+
+	// Pull into an empty store and restore from the pulled chain
+	resetStore(t)
+	if _, err := st.Pull(ctx, remote, nil); err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+	if err := st.Verify(ctx, Ref{Commit: "c2", Index: 0}); err != nil {
+		t.Errorf("Failed to verify pulled snapshot: %v", err)
+	}
+	sidecar := mustStat(t, filepath.Join(st.Dir(), "c1_20250101T1000_0.manifest.json"))
+	if sidecar.ModTime().Before(mustStat(t, filepath.Join(st.Dir(), "c1_20250101T1000_0.tar.gz")).ModTime()) {
+		t.Error("Expected the pulled sidecar to be newer than its archive")
+	}
+}
+
+func TestPullConflict(t *testing.T) {
+	st, parentPath, childPath := setupChain(t)
+	ctx := context.Background()
+	for _, path := range []string{parentPath, childPath} {
+		if _, err := st.LoadManifest(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote := backend.NewLocal(filepath.Join(t.TempDir(), "remote"))
+	if _, err := st.Push(ctx, remote, nil); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	// Another clone took index 0 of c1 for a different snapshot
+	resetStore(t)
+	if err := os.WriteFile("a.txt", []byte("local"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	local, _ := createTestManifest()
+	local.CommitHash = "c1"
+	writeTestSnapshot(t, filepath.Join(st.Dir(), "c1_20250102T0900_0.tar.gz"), []string{"a.txt"}, local)
+
+	result, err := st.Pull(ctx, remote, nil)
+	if err != nil {
+		t.Fatalf("Failed to pull: %v", err)
+	}
+	// c2 is incremental on the remote's c1:0, so it would be restored from
+	// the wrong parent
+	if len(result.Conflicts) != 2 || len(result.Transferred) != 0 {
+		t.Errorf("Expected c1 and its child c2 to conflict, got %+v", result)
+	}
+	if _, err := os.Stat(filepath.Join(st.Dir(), "c1_20250102T0900_0.tar.gz")); err != nil {
+		t.Errorf("Expected the local snapshot to be kept: %v", err)
+	}
+}
+
+func TestPullChecksSnapshots(t *testing.T) {
+	st, parentPath, _ := setupChain(t)
+	ctx := context.Background()
+
+	// A sidecar that lists a file the archive doesn't hold
+	tampered, err := st.LoadManifest(parentPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered.Files["evil.txt"] = "0000"
+	if err := st.writeSidecar(parentPath, tampered); err != nil {
+		t.Fatal(err)
+	}
+	remote := backend.NewLocal(filepath.Join(t.TempDir(), "remote"))
+	if _, err := st.Push(ctx, remote, nil); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	// Only the sidecar is pulled for a local archive, and failing the
+	// check leaves the local archive alone
+	if err := os.Remove(sidecarPath(parentPath)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Pull(ctx, remote, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected a mismatched sidecar to fail the pull with ErrCorrupt, got %v", err)
+	}
+	if _, err := os.Stat(parentPath); err != nil {
+		t.Errorf("Expected the local archive to be kept: %v", err)
+	}
+	if _, err := os.Stat(sidecarPath(parentPath)); !os.IsNotExist(err) {
+		t.Errorf("Expected the mismatched sidecar to be removed, got %v", err)
+	}
+
+	resetStore(t)
+	if _, err := st.Pull(ctx, remote, nil); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("Expected a tampered sidecar to fail the pull with ErrCorrupt, got %v", err)
+	}
+	for _, name := range []string{"c1_20250101T1000_0.tar.gz", "c1_20250101T1000_0.manifest.json"} {
+		if _, err := os.Stat(filepath.Join(st.Dir(), name)); !os.IsNotExist(err) {
+			t.Errorf("Expected the rejected %s to be removed, got %v", name, err)
+		}
+	}
+
+	// An archive holding a path outside the working tree, taken from a
+	// subdirectory as if it were the working tree
+	if err := os.WriteFile("escaped.txt", []byte("outside"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join("work", ".ignoregrets", "snapshots"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir("work"); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.CommitHash = "c3"
+	writeTestSnapshot(t, filepath.Join(st.Dir(), "c3_20250101T1200_0.tar.gz"), []string{"../escaped.txt"}, manifest)
+	remote = backend.NewLocal(filepath.Join(t.TempDir(), "remote"))
+	if _, err := st.Push(ctx, remote, nil); err != nil {
+		t.Fatalf("Failed to push: %v", err)
+	}
+
+	resetStore(t)
+	if _, err := st.Pull(ctx, remote, nil); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Expected an unsafe path to fail the pull with ErrUnsafePath, got %v", err)
+	}
+	if entries, _ := os.ReadDir(st.Dir()); len(entries) != 0 {
+		t.Errorf("Expected the failed pull to leave the store empty, found %d entries", len(entries))
+	}
+
+	// Object names with a path in them are skipped
+	if err := remote.Delete(ctx, "c3_20250101T1200_0.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := st.Pull(ctx, nestedBackend{remote}, nil)
+	if err != nil || len(result.Transferred) != 0 {
+		t.Errorf("Expected nothing to be pulled, got %+v, %v", result, err)
+	}
+}
+
+// nestedBackend lists an extra object whose name holds a path
+type nestedBackend struct {
+	*backend.Local
+}
+
+func (b nestedBackend) List(ctx context.Context) ([]backend.Object, error) {
+	objects, err := b.Local.List(ctx)
+	return append(objects, backend.Object{Name: "../c4_20250101T1300_0.tar.gz", Size: 1}), err
+}
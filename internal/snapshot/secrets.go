@@ -0,0 +1,248 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// scanLimit is the largest file the secret scanner reads; secrets live in
+// small files, and skipping large ones keeps build output cheap to scan
+const scanLimit = 1 << 20
+
+// binarySniffLen is how much of a file is checked for NUL bytes to skip
+// binary files
+const binarySniffLen = 8000
+
+// BuiltinSecretRules are checked unless a configured rule of the same name
+// replaces them
+var BuiltinSecretRules = []config.SecretRule{
+	{Name: "aws-access-key-id", Pattern: `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`, Action: config.SecretRecord},
+	{Name: "aws-secret-access-key", Pattern: `(?i)aws_?secret_?access_?key\s*[:=]\s*["']?[A-Za-z0-9/+=]{40}\b`, Action: config.SecretRecord},
+	{Name: "private-key", Pattern: `-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`, Action: config.SecretRecord},
+	{Name: "age-secret-key", Pattern: `AGE-SECRET-KEY-1[0-9A-Z]{58}`, Action: config.SecretRecord},
+	{Name: "github-token", Pattern: `\b(?:gh[pousr]_[A-Za-z0-9]{36}|github_pat_[A-Za-z0-9_]{82})\b`, Action: config.SecretRecord},
+	{Name: "slack-token", Pattern: `\bxox[abposr]-[A-Za-z0-9-]{10,}`, Action: config.SecretRecord},
+	{Name: "stripe-live-key", Pattern: `\b[rs]k_live_[A-Za-z0-9]{24,}\b`, Action: config.SecretRecord},
+	{Name: "google-api-key", Pattern: `\bAIza[0-9A-Za-z_\-]{35}\b`, Action: config.SecretRecord},
+}
+
+// Finding is a match of a secret rule in a snapshot file. The matched text
+// itself is never stored.
+type Finding struct {
+	Rule   string `json:"rule"`
+	Line   int    `json:"line"`
+	Action string `json:"action"`
+}
+
+// SecretFunc receives each secret found in a snapshot being created
+type SecretFunc func(path string, finding Finding)
+
+// secretRule is a compiled secret rule
+type secretRule struct {
+	config.SecretRule
+	re *regexp.Regexp
+}
+
+// secretRules returns the rules to scan with and a fingerprint of them,
+// or nil if scanning is turned off
+func secretRules(cfg *config.Config) ([]secretRule, string, error) {
+	if cfg.SkipSecretScan {
+		return nil, "", nil
+	}
+
+	merged := append([]config.SecretRule(nil), BuiltinSecretRules...)
+	for _, rule := range cfg.SecretRules {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == rule.Name {
+				merged[i] = rule
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, rule)
+		}
+	}
+
+	var rules []secretRule
+	var active []config.SecretRule
+	for _, rule := range merged {
+		if rule.Action == config.SecretOff {
+			continue
+		}
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid pattern for secret rule %s: %w", rule.Name, err)
+		}
+		rules = append(rules, secretRule{SecretRule: rule, re: re})
+		active = append(active, rule)
+	}
+
+	data, err := json.Marshal(active)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := sha256.Sum256(data)
+	return rules, hex.EncodeToString(sum[:8]), nil
+}
+
+// scanSecrets scans files for secrets using up to jobs workers. Files
+// whose checksum matches the previous snapshot, scanned with the same
+// rules, reuse its findings instead of being read again.
+func scanSecrets(ctx context.Context, rules []secretRule, fingerprint string, files []string, checksums map[string]string, previous *Manifest, jobs int) (map[string][]Finding, error) {
+	findings := make(map[string][]Finding)
+	if len(rules) == 0 {
+		return findings, nil
+	}
+
+	var toScan []string
+	for _, path := range files {
+		if previous != nil && previous.SecretRules == fingerprint && previous.Files[path] == checksums[path] {
+			if found := previous.Secrets[path]; len(found) > 0 {
+				findings[path] = found
+			}
+			continue
+		}
+		toScan = append(toScan, path)
+	}
+
+	if jobs < 1 {
+		jobs = 1
+	}
+	paths := make(chan string)
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				found, err := scanFile(path, rules)
+				mu.Lock()
+				if err != nil && firstErr == nil {
+					firstErr = fmt.Errorf("failed to scan %s for secrets: %w", path, err)
+				}
+				if len(found) > 0 {
+					findings[path] = found
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, path := range toScan {
+		select {
+		case paths <- path:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(paths)
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return findings, nil
+}
+
+// scanFile returns the secrets found in a file, one finding per rule and
+// line. Binary files and files larger than scanLimit are skipped.
+func scanFile(path string, rules []secretRule) ([]Finding, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > scanLimit {
+		return nil, nil
+	}
+
+	br := bufio.NewReader(io.LimitReader(file, scanLimit))
+	head, _ := br.Peek(binarySniffLen)
+	if bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var findings []Finding
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 0, 64*1024), scanLimit)
+	for line := 1; scanner.Scan(); line++ {
+		for _, rule := range rules {
+			if rule.re.Match(scanner.Bytes()) {
+				findings = append(findings, Finding{Rule: rule.Name, Line: line, Action: rule.Action})
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return findings, nil
+}
+
+// excludedBySecrets returns the files a finding excludes from the snapshot
+func excludedBySecrets(findings map[string][]Finding) map[string]bool {
+	excluded := make(map[string]bool)
+	for path, found := range findings {
+		for _, f := range found {
+			if f.Action == config.SecretExclude {
+				excluded[path] = true
+			}
+		}
+	}
+	return excluded
+}
+
+// reportSecrets passes the findings to fn in file and line order
+func reportSecrets(findings map[string][]Finding, fn SecretFunc) {
+	if fn == nil {
+		return
+	}
+	paths := make([]string, 0, len(findings))
+	for path := range findings {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for _, f := range findings[path] {
+			fn(path, f)
+		}
+	}
+}
+
+// SecretFiles returns the files recorded as secret, sorted
+func (m *Manifest) SecretFiles() []string {
+	var files []string
+	for path, found := range m.Secrets {
+		for _, f := range found {
+			if f.Action == config.SecretRecord {
+				files = append(files, path)
+				break
+			}
+		}
+	}
+	sort.Strings(files)
+	return files
+}
@@ -0,0 +1,96 @@
+package snapshot
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sidecarExtension is appended to a snapshot's base name for its manifest
+// sidecar, so metadata can be read without decompressing the archive
+const sidecarExtension = ".manifest.json"
+
+// sidecarPath returns the manifest sidecar path for a snapshot archive
+func sidecarPath(archive string) string {
+	dir, name := filepath.Split(archive)
+	base, _ := trimArchiveExtension(name)
+	return filepath.Join(dir, base+sidecarExtension)
+}
+
+// isSidecar reports whether a file name is a manifest sidecar
+func isSidecar(name string) bool {
+	return strings.HasSuffix(name, sidecarExtension)
+}
+
+// writeSidecar writes the manifest sidecar for a snapshot archive,
+// encrypted like the archive if the snapshot's configuration asks for it
+func (st *Store) writeSidecar(archive string, manifest *Manifest) error {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if manifest.encrypted() && manifest.Config != nil && manifest.Config.EncryptManifest {
+		if data, err = encryptBytes(data, manifest, st.keys); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(sidecarPath(archive), data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest sidecar: %w", err)
+	}
+	return nil
+}
+
+// readSidecar reads the manifest sidecar of a snapshot archive. It fails
+// if the sidecar is missing, unreadable, or older than the archive.
+func (st *Store) readSidecar(archive string, info os.FileInfo) (*Manifest, error) {
+	path := sidecarPath(archive)
+	sidecar, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if sidecar.ModTime().Before(info.ModTime()) {
+		return nil, fmt.Errorf("manifest sidecar is stale")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.HasPrefix(data, ageMagic) {
+		if data, err = decryptBytes(data, st.keys); err != nil {
+			return nil, err
+		}
+	}
+	return decodeManifest(data)
+}
+
+// LoadManifest returns the manifest of the snapshot archive at path. It is
+// read from the sidecar when that is up to date; otherwise it is read from
+// the archive and the sidecar is rebuilt.
+func (st *Store) LoadManifest(path string) (*Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat snapshot: %w", err)
+	}
+	if manifest, err := st.readSidecar(path, info); err == nil {
+		return manifest, nil
+	}
+
+	manifest, err := st.ReadManifest(file)
+	if err != nil {
+		return nil, err
+	}
+
+	// A sidecar that cannot be written only costs speed
+	st.writeSidecar(path, manifest)
+	return manifest, nil
+}
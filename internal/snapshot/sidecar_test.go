@@ -0,0 +1,163 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWriteSnapshotManifestFirst(t *testing.T) {
+	st := chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.Files["a.txt"] = checksumOf("alpha")
+	path := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz")
+	if err := st.writeSnapshot(context.Background(), path, manifest, []string{"a.txt"}, 0, 1, nil); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	// The manifest is the first entry
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gr, err := newDecompressor(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gr.Close()
+	hdr, err := tar.NewReader(gr).Next()
+	if err != nil {
+		t.Fatalf("Failed to read first entry: %v", err)
+	}
+	if hdr.Name != "manifest.json" {
+		t.Errorf("Expected manifest.json first, got %s", hdr.Name)
+	}
+
+	// The sidecar matches the archive
+	sidecar, err := st.readSidecar(path, mustStat(t, path))
+	if err != nil {
+		t.Fatalf("Failed to read sidecar: %v", err)
+	}
+	verifyManifest(t, sidecar, manifest)
+}
+
+func TestWriteSnapshotDetectsChangedFile(t *testing.T) {
+	st := chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	manifest.Files["a.txt"] = checksumOf("alpha")
+	path := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz")
+	if err := st.writeSnapshot(context.Background(), path, manifest, []string{"a.txt"}, 0, 1, nil); err == nil {
+		t.Error("Expected error for a file that no longer matches the manifest")
+	}
+
+	// A failed snapshot leaves neither the archive nor its temporary file
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		t.Errorf("Unexpected file left behind: %s", entry.Name())
+	}
+}
+
+func TestLoadManifestRebuildsSidecar(t *testing.T) {
+	st := chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	manifest, _ := createTestManifest()
+	path := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz")
+	writeTestSnapshot(t, path, []string{"a.txt"}, manifest)
+
+	// Missing sidecars are rebuilt from the archive
+	if _, err := os.Stat(sidecarPath(path)); !os.IsNotExist(err) {
+		t.Fatalf("Expected no sidecar yet, got %v", err)
+	}
+	loaded, err := st.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	verifyManifest(t, loaded, manifest)
+	if _, err := st.readSidecar(path, mustStat(t, path)); err != nil {
+		t.Fatalf("Expected sidecar to be rebuilt: %v", err)
+	}
+
+	// Sidecars older than their archive are stale
+	stale := &Manifest{CommitHash: "stale"}
+	if err := st.writeSidecar(path, stale); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(sidecarPath(path), past, past); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err = st.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if loaded.CommitHash != manifest.CommitHash {
+		t.Errorf("Expected stale sidecar to be ignored, got commit %s", loaded.CommitHash)
+	}
+}
+
+func TestPruneRemovesSidecars(t *testing.T) {
+	st := chdirTemp(t)
+
+	if err := os.WriteFile("a.txt", []byte("alpha"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Join(".ignoregrets", "snapshots")
+	var paths []string
+	for i, name := range []string{"c1_20250101T1000_0.tar.gz", "c1_20250101T1100_1.tar.gz"} {
+		manifest, _ := createTestManifest()
+		manifest.CommitHash = "c1"
+		manifest.Index = i
+		path := filepath.Join(dir, name)
+		writeTestSnapshot(t, path, []string{"a.txt"}, manifest)
+		if _, err := st.LoadManifest(path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	orphan := sidecarPath(filepath.Join(dir, "gone_20250101T1000_0.tar.gz"))
+	if err := os.WriteFile(orphan, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := st.Prune(context.Background(), PruneOptions{Retention: 1}); err != nil {
+		t.Fatalf("Failed to prune: %v", err)
+	}
+
+	for path, exists := range map[string]bool{
+		sidecarPath(paths[0]): false,
+		sidecarPath(paths[1]): true,
+		orphan:                false,
+	} {
+		if _, err := os.Stat(path); (err == nil) != exists {
+			t.Errorf("Expected %s to exist: %v", path, exists)
+		}
+	}
+}
+
+// mustStat returns the file info of path
+func mustStat(t *testing.T, path string) os.FileInfo {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return info
+}
@@ -0,0 +1,149 @@
+package snapshot
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// signNamespace is prepended to the signed data, so a bundle signature
+// can't be passed off as a signature of anything else
+const signNamespace = "ignoregrets-bundle-v1\n"
+
+// Errors returned when importing signed bundles
+var (
+	ErrBadSignature = errors.New("bundle signature is not valid")
+	ErrUntrusted    = errors.New("bundle is not signed by a trusted key")
+)
+
+// bundleSignature is an SSH signature of a bundle's header
+type bundleSignature struct {
+	PublicKey string `json:"public_key"` // authorized_keys format
+	Format    string `json:"format"`
+	Blob      []byte `json:"blob"`
+}
+
+// signBundle signs data with the SSH private key at keyPath. Keys
+// protected by a passphrase are used through ssh-agent.
+func signBundle(keyPath string, data []byte) (*bundleSignature, error) {
+	pem, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		var conn net.Conn
+		conn, signer, err = agentSigner(keyPath, missing.PublicKey)
+		if conn != nil {
+			defer conn.Close()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load signing key %s: %w", keyPath, err)
+	}
+
+	data = append([]byte(signNamespace), data...)
+	var sig *ssh.Signature
+	if as, ok := signer.(ssh.AlgorithmSigner); ok && signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		sig, err = as.SignWithAlgorithm(rand.Reader, data, ssh.KeyAlgoRSASHA512)
+	} else {
+		sig, err = signer.Sign(rand.Reader, data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign bundle: %w", err)
+	}
+
+	return &bundleSignature{
+		PublicKey: string(bytes.TrimSpace(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Format:    sig.Format,
+		Blob:      sig.Blob,
+	}, nil
+}
+
+// agentSigner finds the signer for a passphrase-protected key in
+// ssh-agent. The public key is read from keyPath.pub if the private key
+// file doesn't carry it. The returned connection must be closed.
+func agentSigner(keyPath string, pub ssh.PublicKey) (net.Conn, ssh.Signer, error) {
+	if pub == nil {
+		data, err := os.ReadFile(keyPath + ".pub")
+		if err != nil {
+			return nil, nil, fmt.Errorf("key is protected by a passphrase and its public key is missing: %w", err)
+		}
+		if pub, _, _, _, err = ssh.ParseAuthorizedKey(data); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse %s.pub: %w", keyPath, err)
+		}
+	}
+
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, nil, fmt.Errorf("key is protected by a passphrase; add it to ssh-agent")
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to ssh-agent: %w", err)
+	}
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("failed to list ssh-agent keys: %w", err)
+	}
+	for _, signer := range signers {
+		if bytes.Equal(signer.PublicKey().Marshal(), pub.Marshal()) {
+			return conn, signer, nil
+		}
+	}
+	conn.Close()
+	return nil, nil, fmt.Errorf("key is protected by a passphrase and not in ssh-agent; add it with ssh-add")
+}
+
+// verifyBundle checks the signature of data and returns the key that made
+// it
+func verifyBundle(data []byte, sig *bundleSignature) (ssh.PublicKey, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(sig.PublicKey))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	data = append([]byte(signNamespace), data...)
+	if err := pub.Verify(data, &ssh.Signature{Format: sig.Format, Blob: sig.Blob}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrBadSignature, err)
+	}
+	return pub, nil
+}
+
+// trustedKey reports whether pub is listed in the authorized_keys style
+// file at path
+func trustedKey(path string, pub ssh.PublicKey) (bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to open trusted keys: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		key, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return false, fmt.Errorf("failed to parse trusted keys %s: %w", path, err)
+		}
+		if bytes.Equal(key.Marshal(), pub.Marshal()) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+	return false, nil
+}
@@ -2,34 +2,159 @@ package snapshot
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
 	"github.com/Cod-e-Codes/ignoregrets/internal/config"
 	"github.com/Cod-e-Codes/ignoregrets/internal/git"
 )
 
 // Manifest represents the metadata for a snapshot
 type Manifest struct {
+	// Version is the format of the manifest; see ManifestVersion
+	Version int `json:"version,omitempty"`
+
 	CommitHash string            `json:"commit"`
 	Timestamp  time.Time         `json:"timestamp"`
 	Index      int               `json:"index"`
 	Files      map[string]string `json:"files"` // path -> sha256
 	Config     *config.Config    `json:"config"`
+
+	// Reason records why the snapshot was taken, e.g. ReasonPreClean
+	Reason string `json:"reason,omitempty"`
+
+	// Profile is the config profile the snapshot was taken with; empty
+	// for the top-level settings
+	Profile string `json:"profile,omitempty"`
+
+	// Branch is the branch checked out when the snapshot was taken; empty
+	// on a detached HEAD or for a commit given explicitly
+	Branch string `json:"branch,omitempty"`
+
+	// Message is a note given when the snapshot was taken
+	Message string `json:"message,omitempty"`
+
+	// DerivedFrom is the previous snapshot when nearly all files are
+	// unchanged from it
+	DerivedFrom *Ref `json:"derived_from,omitempty"`
+
+	// RewrittenFrom is the original commit of a snapshot carried over by
+	// the post-rewrite hook (amend or rebase)
+	RewrittenFrom string `json:"rewritten_from,omitempty"`
+
+	// Compression is the codec of the archive; archives without it are gzip
+	Compression string `json:"compression,omitempty"`
+
+	// Encryption is how the archive is encrypted, if at all; recipients
+	// are taken from Config
+	Encryption string `json:"encryption,omitempty"`
+
+	// Secrets holds what the secret scanner found, by file, and
+	// SecretRules fingerprints the rules it used
+	Secrets     map[string][]Finding `json:"secrets,omitempty"`
+	SecretRules string               `json:"secret_rules,omitempty"`
+
+	// Parent is the snapshot an incremental snapshot builds on. Files
+	// listed in Inherited are not in this archive but in the parent chain.
+	Parent    *Ref     `json:"parent,omitempty"`
+	Inherited []string `json:"inherited,omitempty"`
+}
+
+// compressionLevel returns the level the snapshot was compressed with
+func (m *Manifest) compressionLevel() int {
+	if m.Config == nil {
+		return 0
+	}
+	return m.Config.CompressionLevel
+}
+
+// ReasonPreClean marks a safety snapshot taken before 'git clean'
+const ReasonPreClean = "pre-clean"
+
+// ErrNoSnapshots is returned when a commit has no snapshots
+var ErrNoSnapshots = errors.New("no snapshots found")
+
+// ErrNotFound is returned when a commit has snapshots, but none with the
+// requested index
+var ErrNotFound = errors.New("snapshot not found")
+
+// ErrNoFiles is returned when there are no ignored files to snapshot
+var ErrNoFiles = errors.New("no files to snapshot")
+
+// ErrUnchanged is returned when the files match the previous snapshot and
+// Options.Always is not set
+var ErrUnchanged = errors.New("nothing changed since the previous snapshot")
+
+// ErrUnsafePath is returned for a snapshot file path that would be written
+// outside the working tree or into the store
+var ErrUnsafePath = errors.New("unsafe file path in snapshot")
+
+// Options controls how CreateSnapshot creates a snapshot
+type Options struct {
+	// Reason is recorded in the manifest
+	Reason string
+
+	// Commit is the commit the snapshot is keyed to; defaults to HEAD
+	Commit string
+
+	// Profile is recorded in the manifest, and the previous snapshot is
+	// the latest one of the same profile. The caller applies the profile's
+	// settings to the config.
+	Profile string
+
+	// Message is recorded in the manifest
+	Message string
+
+	// Always creates the snapshot even if nothing changed
+	Always bool
+
+	// Incremental stores only files that differ from the previous snapshot,
+	// referencing it as the parent for the rest
+	Incremental bool
+
+	// Progress, if set, receives updates while files are hashed and
+	// archived
+	Progress ProgressFunc
+
+	// OnSecret, if set, receives each secret found in the snapshot's files,
+	// including those in files a rule excludes
+	OnSecret SecretFunc
+}
+
+// Latest is the Ref index that selects the newest snapshot of a commit
+const Latest = -1
+
+// Ref identifies a snapshot by commit and manifest index
+type Ref struct {
+	Commit string `json:"commit"`
+	Index  int    `json:"index"`
+}
+
+// String formats the reference as <commit>:<index>, or <commit>:latest
+func (r Ref) String() string {
+	if r.Index == Latest {
+		return r.Commit + ":latest"
+	}
+	return fmt.Sprintf("%s:%d", r.Commit, r.Index)
 }
 
-// ReadManifest reads the manifest from a snapshot file
-func ReadManifest(file *os.File) (*Manifest, error) {
-	gr, err := gzip.NewReader(file)
+// ReadManifest reads the manifest from a snapshot archive
+func (st *Store) ReadManifest(r io.Reader) (*Manifest, error) {
+	gr, err := newDecompressor(r, st.keys)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, err
 	}
 	defer gr.Close()
 
@@ -50,8 +175,8 @@ func ReadManifest(file *os.File) (*Manifest, error) {
 				return nil, fmt.Errorf("failed to read manifest: %w", err)
 			}
 
-			manifest := &Manifest{}
-			if err := json.Unmarshal(data, manifest); err != nil {
+			manifest, err := decodeManifest(data)
+			if err != nil {
 				return nil, fmt.Errorf("failed to parse manifest: %w", err)
 			}
 			return manifest, nil
@@ -61,59 +186,197 @@ func ReadManifest(file *os.File) (*Manifest, error) {
 	return nil, fmt.Errorf("manifest.json not found in snapshot")
 }
 
-// CreateSnapshot creates a new snapshot of ignored files
-func CreateSnapshot(cfg *config.Config) error {
+// CreateSnapshot creates a new snapshot of ignored files and returns its
+// manifest. Unless opts.Always is set, it compares the files with the
+// previous snapshot (see previousSnapshot) and returns that snapshot's
+// manifest with ErrUnchanged if they are identical.
+func (st *Store) CreateSnapshot(ctx context.Context, cfg *config.Config, opts Options) (*Manifest, error) {
 	// Get current commit hash
-	commit, err := git.GetCurrentCommit()
-	if err != nil {
-		return err
+	commit := opts.Commit
+	branch := ""
+	if commit == "" {
+		var err error
+		commit, err = git.GetCurrentCommit(ctx)
+		if err != nil {
+			return nil, err
+		}
+		// The branch is only informational, so failing to read it isn't
+		// worth failing the snapshot
+		branch, _ = git.CurrentBranch(ctx)
 	}
 
 	// Get ignored files
-	files, err := git.GetIgnoredFiles()
+	files, err := git.GetIgnoredFiles(ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Filter files based on config
 	files = filterFiles(files, cfg)
 	if len(files) == 0 {
-		return fmt.Errorf("no files to snapshot")
+		return nil, ErrNoFiles
+	}
+	sort.Strings(files)
+
+	// Compare with the previous snapshot
+	cache := LoadStatCache()
+	checksums, err := ChecksumFiles(ctx, files, cfg.Parallelism(), cache, opts.Progress)
+	if err != nil {
+		return nil, err
+	}
+	// The cache only saves work next time, so failing to write it is no
+	// reason to fail now
+	if err := cache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+	previous, _ := st.previousSnapshot(ctx, commit, opts.Profile)
+
+	// Scan for secrets and leave out files a rule excludes
+	rules, fingerprint, err := secretRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	findings, err := scanSecrets(ctx, rules, fingerprint, files, checksums, previous, cfg.Parallelism())
+	if err != nil {
+		return nil, err
+	}
+	if excluded := excludedBySecrets(findings); len(excluded) > 0 {
+		kept := make([]string, 0, len(files))
+		for _, path := range files {
+			if excluded[path] {
+				delete(checksums, path)
+			} else {
+				kept = append(kept, path)
+			}
+		}
+		files = kept
+		if len(files) == 0 {
+			reportSecrets(findings, opts.OnSecret)
+			return nil, ErrNoFiles
+		}
 	}
 
+	unchanged := 0
+	if previous != nil {
+		unchanged = countUnchanged(checksums, previous)
+		if unchanged == len(files) && len(files) == len(previous.Files) && !opts.Always {
+			return previous, ErrUnchanged
+		}
+	}
+	reportSecrets(findings, opts.OnSecret)
+
 	// Create manifest
 	manifest := &Manifest{
+		Version:    ManifestVersion,
 		CommitHash: commit,
 		Timestamp:  time.Now().UTC(),
-		Index:      getNextIndex(commit),
-		Files:      make(map[string]string),
+		Index:      st.getNextIndex(commit),
+		Files:      checksums,
 		Config:     cfg,
+		Reason:     opts.Reason,
+		Profile:    opts.Profile,
+		Branch:     branch,
+		Message:    opts.Message,
+
+		Compression: cfg.Compression,
+	}
+	if manifest.Compression == "" {
+		manifest.Compression = config.CompressionGzip
+	}
+	if cfg.Encrypted() {
+		manifest.Encryption = cfg.Encryption
+	}
+	manifest.SecretRules = fingerprint
+	for path, found := range findings {
+		if _, ok := checksums[path]; ok {
+			if manifest.Secrets == nil {
+				manifest.Secrets = make(map[string][]Finding)
+			}
+			manifest.Secrets[path] = found
+		}
+	}
+	if previous != nil && nearlyIdentical(unchanged, max(len(files), len(previous.Files))) {
+		manifest.DerivedFrom = &Ref{Commit: previous.CommitHash, Index: previous.Index}
+	}
+
+	// Incremental snapshots leave unchanged files in the parent
+	if opts.Incremental && previous != nil {
+		manifest.Parent = &Ref{Commit: previous.CommitHash, Index: previous.Index}
+		stored := make([]string, 0, len(files))
+		for _, path := range files {
+			if previous.Files[path] == checksums[path] {
+				manifest.Inherited = append(manifest.Inherited, path)
+			} else {
+				stored = append(stored, path)
+			}
+		}
+		sort.Strings(manifest.Inherited)
+		files = stored
+	}
+
+	snapshotPath := filepath.Join(st.dir, snapshotName(manifest))
+	if err := st.writeSnapshot(ctx, snapshotPath, manifest, files, cfg.CompressionLevel, cfg.Parallelism(), opts.Progress); err != nil {
+		return nil, err
 	}
 
-	// Create snapshot file
-	snapshotPath := filepath.Join(".ignoregrets", "snapshots",
-		fmt.Sprintf("%s_%s_%d.tar.gz", commit, manifest.Timestamp.Format("20060102T1504"), manifest.Index))
+	return manifest, nil
+}
 
-	file, err := os.Create(snapshotPath)
+// writeSnapshot writes a snapshot archive holding the manifest followed by
+// files, then its manifest sidecar. It fails if a file no longer matches
+// the checksum recorded in the manifest. On failure or cancellation the
+// partial archive is removed.
+func (st *Store) writeSnapshot(ctx context.Context, path string, manifest *Manifest, files []string, level, jobs int, progress ProgressFunc) error {
+	// Write to a temporary file so a failure never leaves a partial archive
+	file, err := createAtomic(path, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create snapshot file: %w", err)
 	}
-	defer file.Close()
-
-	gw := gzip.NewWriter(file)
-	defer gw.Close()
+	defer file.Abort()
 
+	ew, err := newEncryptor(file, manifest, st.keys)
+	if err != nil {
+		return err
+	}
+	gw, err := newCompressor(ew, manifest.Compression, level, jobs)
+	if err != nil {
+		return err
+	}
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
 
-	// Add files to archive and calculate checksums
-	for _, path := range files {
-		if err := addFileToArchive(tw, path, manifest); err != nil {
-			return fmt.Errorf("failed to add file to archive: %s: %w", path, err)
+	if err := writeManifest(tw, manifest); err != nil {
+		return err
+	}
+
+	// Add files to archive and check them against the manifest
+	written := make(map[string]string, len(files))
+	if err := archiveFiles(ctx, tw, files, jobs, written, progress); err != nil {
+		return err
+	}
+	for _, name := range files {
+		if written[name] != manifest.Files[name] {
+			return fmt.Errorf("file changed while creating snapshot: %s", name)
 		}
 	}
 
-	// Write manifest
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	if err := file.Commit(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	return st.writeSidecar(path, manifest)
+}
+
+// writeManifest writes the manifest as manifest.json into the archive
+func writeManifest(tw *tar.Writer, manifest *Manifest) error {
 	manifestData, err := json.Marshal(manifest)
 	if err != nil {
 		return fmt.Errorf("failed to marshal manifest: %w", err)
@@ -134,62 +397,139 @@ func CreateSnapshot(cfg *config.Config) error {
 	return nil
 }
 
-// readManifestFromSnapshot reads the manifest from a snapshot file
-func readManifestFromSnapshot(file *os.File) (*Manifest, error) {
-	gr, err := gzip.NewReader(file)
+// CarrySnapshot copies the latest snapshot of profile for oldCommit to
+// newCommit, so ignored files stay restorable after an amend or rebase
+// rewrites history. It does nothing if oldCommit has no such snapshot.
+func (st *Store) CarrySnapshot(ctx context.Context, oldCommit, newCommit, profile string) error {
+	path, _, err := st.latestSnapshot(oldCommit, profile)
+	if errors.Is(err, ErrNoSnapshots) {
+		return nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	manifest, err := st.ReadManifest(src)
+	src.Close()
+	if err != nil {
+		return err
+	}
+
+	manifest.CommitHash = newCommit
+	manifest.Index = st.getNextIndex(newCommit)
+	manifest.RewrittenFrom = oldCommit
+	return st.copyArchive(ctx, path, manifest)
+}
+
+// copyArchive copies the files of the archive at srcPath into a new
+// snapshot in the store described by manifest, keeping the source's codec
+// and encryption
+func (st *Store) copyArchive(ctx context.Context, srcPath string, manifest *Manifest) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer src.Close()
+
+	dstPath := filepath.Join(st.dir, snapshotName(manifest))
+	dst, err := createAtomic(dstPath, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer dst.Abort()
+
+	gr, err := newDecompressor(src, st.keys)
+	if err != nil {
+		return err
 	}
 	defer gr.Close()
 
-	tr := tar.NewReader(gr)
+	ew, err := newEncryptor(dst, manifest, st.keys)
+	if err != nil {
+		return err
+	}
+	gw, err := newCompressor(ew, manifest.Compression, manifest.compressionLevel(), 1)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(gw)
 
+	if err := writeManifest(tw, manifest); err != nil {
+		return err
+	}
+
+	// Copy file entries, replacing the manifest
+	tr := tar.NewReader(gr)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to read tar header: %w", err)
+			return fmt.Errorf("failed to read tar header: %w", err)
 		}
-
 		if hdr.Name == "manifest.json" {
-			data, err := io.ReadAll(tr)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read manifest: %w", err)
-			}
-
-			manifest := &Manifest{}
-			if err := json.Unmarshal(data, manifest); err != nil {
-				return nil, fmt.Errorf("failed to parse manifest: %w", err)
-			}
-			return manifest, nil
+			continue
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		if _, err := io.Copy(tw, backend.ContextReader(ctx, tr)); err != nil {
+			return fmt.Errorf("failed to copy file: %s: %w", hdr.Name, err)
 		}
 	}
 
-	return nil, fmt.Errorf("manifest.json not found in snapshot")
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gw.Close(); err != nil {
+		return fmt.Errorf("failed to finish compression: %w", err)
+	}
+	if err := ew.Close(); err != nil {
+		return fmt.Errorf("failed to finish encryption: %w", err)
+	}
+	if err := dst.Commit(); err != nil {
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	return st.writeSidecar(dstPath, manifest)
 }
 
-// restoreFile restores a single file from the tar reader
-func restoreFile(tr *tar.Reader, hdr *tar.Header, dryRun bool, force bool) error {
-	// Skip manifest file
-	if hdr.Name == "manifest.json" {
-		return nil
-	}
+// RestoreOptions controls how RestoreSnapshot treats the working tree
+type RestoreOptions struct {
+	// Policy decides what happens to files that exist with different
+	// content; one of the config.Conflict* values
+	Policy string
 
-	// Check if file exists
-	if _, err := os.Stat(hdr.Name); err == nil && !force {
-		if dryRun {
-			fmt.Printf("Would skip existing file: %s\n", hdr.Name)
-		} else {
-			fmt.Printf("Skipping existing file: %s\n", hdr.Name)
-		}
-		return nil
-	}
+	// DryRun reports what would be restored without writing files
+	DryRun bool
 
-	if dryRun {
-		fmt.Printf("Would restore: %s\n", hdr.Name)
-		return nil
+	// Paths, if set, limits the restore to these files of the snapshot
+	Paths []string
+
+	// Jobs bounds the workers that hash the working files to compare them
+	// with the snapshot
+	Jobs int
+
+	// Baseline holds the checksums of the last snapshot of the files
+	// currently on disk, used by config.ConflictOverwriteUnchanged
+	Baseline map[string]string
+
+	// Prompt asks whether to overwrite a file, used by config.ConflictPrompt
+	Prompt func(path string) bool
+
+	// Progress, if set, receives updates as files are restored
+	Progress ProgressFunc
+}
+
+// restoreFile restores a single file from the tar reader
+func restoreFile(tr io.Reader, hdr *tar.Header) error {
+	if err := checkPath(hdr.Name); err != nil {
+		return err
 	}
 
 	// Create directory if needed
@@ -212,56 +552,216 @@ func restoreFile(tr *tar.Reader, hdr *tar.Header, dryRun bool, force bool) error
 	return nil
 }
 
-// RestoreSnapshot restores files from a snapshot
-func RestoreSnapshot(commit string, index int, force, dryRun bool) error {
-	snapshot, err := findSnapshot(commit, index)
+// planRestore compares the manifest with the working tree and returns the
+// files to restore. Files identical to the snapshot are left alone, and
+// files with different content are resolved with the conflict policy.
+// Files are hashed in parallel through the stat cache, so an up-to-date
+// working tree is checked without reading it.
+func planRestore(ctx context.Context, manifest *Manifest, opts RestoreOptions) (map[string]bool, error) {
+	if err := checkPaths(manifest); err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(manifest.Files))
+	if len(opts.Paths) > 0 {
+		for _, path := range opts.Paths {
+			if _, ok := manifest.Files[path]; !ok {
+				return nil, fmt.Errorf("%s is not in the snapshot", path)
+			}
+			paths = append(paths, path)
+		}
+	} else {
+		for path := range manifest.Files {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	restore := make(map[string]bool)
+	existing := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			restore[path] = true
+		} else {
+			existing = append(existing, path)
+		}
+	}
+	cache := LoadStatCache()
+	checksums, err := ChecksumFiles(ctx, existing, opts.Jobs, cache, opts.Progress)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	// The cache only saves work next time, so failing to write it is no
+	// reason to fail now
+	if err := cache.Save(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 	}
 
-	file, err := os.Open(snapshot)
+	for _, path := range existing {
+		current := checksums[path]
+		if current == manifest.Files[path] {
+			continue
+		}
+
+		overwrite := false
+		switch opts.Policy {
+		case config.ConflictOverwrite:
+			overwrite = true
+		case config.ConflictOverwriteUnchanged:
+			overwrite = opts.Baseline[path] == current
+		case config.ConflictPrompt:
+			if opts.DryRun {
+				fmt.Printf("Would ask before overwriting: %s\n", path)
+				continue
+			}
+			overwrite = opts.Prompt != nil && opts.Prompt(path)
+		}
+
+		if overwrite {
+			restore[path] = true
+		} else if opts.DryRun {
+			fmt.Printf("Would skip existing file: %s\n", path)
+		} else {
+			fmt.Printf("Skipping existing file: %s\n", path)
+		}
+	}
+
+	return restore, nil
+}
+
+// RestoreSnapshot restores files from a snapshot
+func (st *Store) RestoreSnapshot(ctx context.Context, ref Ref, opts RestoreOptions) error {
+	snapshot, err := st.FindSnapshot(ref)
 	if err != nil {
-		return fmt.Errorf("failed to open snapshot: %w", err)
+		return err
 	}
-	defer file.Close()
 
-	// Read manifest first
-	manifest, err := readManifestFromSnapshot(file)
+	manifest, err := st.LoadManifest(snapshot)
 	if err != nil {
 		return err
 	}
 
 	// Validate manifest
-	if manifest.CommitHash != commit {
-		return fmt.Errorf("snapshot commit hash mismatch: expected %s, got %s", commit, manifest.CommitHash)
+	if manifest.CommitHash != ref.Commit {
+		return fmt.Errorf("snapshot commit hash mismatch: expected %s, got %s", ref.Commit, manifest.CommitHash)
 	}
 
-	// Reset reader for files
-	file.Seek(0, 0)
-	gr, err := gzip.NewReader(file)
+	// Decide what to restore before touching the archive again, so an
+	// up-to-date working tree costs no decompression
+	restore, err := planRestore(ctx, manifest, opts)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return err
+	}
+	if len(restore) == 0 {
+		fmt.Println("Nothing to restore")
+		return nil
 	}
-	defer gr.Close()
 
-	tr := tar.NewReader(gr)
+	if opts.DryRun {
+		paths := make([]string, 0, len(restore))
+		for path := range restore {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		for _, path := range paths {
+			fmt.Printf("Would restore: %s\n", path)
+		}
+		return nil
+	}
 
-	// Restore files
-	for {
-		hdr, err := tr.Next()
-		if err == io.EOF {
-			break
+	// Restore files, following the parent chain of incremental snapshots
+	done := 0
+	var bytes int64
+	return st.readChain(ctx, snapshot, manifest, restore, func(hdr *tar.Header, r io.Reader) error {
+		if err := restoreFile(r, hdr); err != nil {
+			return err
 		}
+		fmt.Printf("Restored: %s\n", hdr.Name)
+		done++
+		bytes += hdr.Size
+		opts.Progress.report(Progress{Phase: PhaseRestoring, Files: done, Total: len(restore), Bytes: bytes, Path: hdr.Name})
+		return nil
+	})
+}
+
+// LatestManifest returns the manifest of the latest snapshot of profile
+// for a commit, where the empty profile selects snapshots taken without one
+func (st *Store) LatestManifest(commit, profile string) (*Manifest, error) {
+	_, manifest, err := st.latestSnapshot(commit, profile)
+	return manifest, err
+}
+
+// latestSnapshot returns the path and manifest of the latest snapshot of
+// profile for a commit
+func (st *Store) latestSnapshot(commit, profile string) (string, *Manifest, error) {
+	snapshots, err := st.listSnapshots(commit)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, s := range snapshots {
+		manifest, err := st.LoadManifest(s.path)
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
+			return "", nil, fmt.Errorf("failed to read manifest from %s: %w", filepath.Base(s.path), err)
+		}
+		if manifest.Profile == profile {
+			return s.path, manifest, nil
 		}
+	}
+	if profile != "" && len(snapshots) > 0 {
+		return "", nil, fmt.Errorf("%w of profile %s for commit %s", ErrNoSnapshots, profile, commit)
+	}
+	return "", nil, fmt.Errorf("%w for commit %s", ErrNoSnapshots, commit)
+}
 
-		if err := restoreFile(tr, hdr, dryRun, force); err != nil {
-			return err
+// previousSnapshot returns the manifest of the latest snapshot of profile
+// for commit, or for its parent if commit has none
+func (st *Store) previousSnapshot(ctx context.Context, commit, profile string) (*Manifest, error) {
+	manifest, err := st.LatestManifest(commit, profile)
+	if !errors.Is(err, ErrNoSnapshots) {
+		return manifest, err
+	}
+
+	parent, err := git.ResolveCommit(ctx, commit+"^")
+	if err != nil {
+		return nil, err
+	}
+	return st.LatestManifest(parent, profile)
+}
+
+// derivedPercent is the share of files, in percent, that must be unchanged
+// from the previous snapshot for a snapshot to record it in derived_from
+const derivedPercent = 90
+
+// nearlyIdentical reports whether unchanged files make up at least
+// derivedPercent of total
+func nearlyIdentical(unchanged, total int) bool {
+	return total > 0 && unchanged*100 >= total*derivedPercent
+}
+
+// countUnchanged counts the files whose checksum matches the manifest
+func countUnchanged(checksums map[string]string, manifest *Manifest) int {
+	unchanged := 0
+	for path, checksum := range checksums {
+		if manifest.Files[path] == checksum {
+			unchanged++
 		}
 	}
+	return unchanged
+}
 
-	return nil
+// fileChecksum calculates the SHA256 checksum of a file
+func fileChecksum(ctx context.Context, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, backend.ContextReader(ctx, file)); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
 }
 
 // filterFiles applies exclude/include patterns from config
@@ -271,6 +771,10 @@ func filterFiles(files []string, cfg *config.Config) []string {
 
 	// First, add all files that don't match exclude patterns
 	for _, file := range files {
+		// Never snapshot the store itself
+		if IsStorePath(file) {
+			continue
+		}
 		excluded := false
 		for _, pattern := range cfg.Exclude {
 			matched, err := filepath.Match(pattern, filepath.Base(file))
@@ -287,6 +791,9 @@ func filterFiles(files []string, cfg *config.Config) []string {
 	// Then, add files that match include patterns, even if they were excluded
 	for _, pattern := range cfg.Include {
 		for _, file := range files {
+			if IsStorePath(file) {
+				continue
+			}
 			matched, err := filepath.Match(pattern, filepath.Base(file))
 			if err == nil && matched {
 				included[file] = true
@@ -303,63 +810,130 @@ func filterFiles(files []string, cfg *config.Config) []string {
 	return result
 }
 
-// getNextIndex returns the next available index for a commit
-func getNextIndex(commit string) int {
-	dir := filepath.Join(".ignoregrets", "snapshots")
-	pattern := fmt.Sprintf("%s_*.tar.gz", commit)
-	matches, _ := filepath.Glob(filepath.Join(dir, pattern))
-	return len(matches)
+// snapshotName returns the archive file name for a snapshot,
+// <commit>_<timestamp>_<index> plus the codec's extension and .age if it
+// is encrypted
+func snapshotName(m *Manifest) string {
+	name := fmt.Sprintf("%s_%s_%d%s", m.CommitHash, m.Timestamp.Format("20060102T1504"), m.Index, archiveExtension(m.Compression))
+	if m.encrypted() {
+		name += encryptedExtension
+	}
+	return name
 }
 
-// findSnapshot finds the snapshot file for a commit and index
-func findSnapshot(commit string, index int) (string, error) {
-	dir := filepath.Join(".ignoregrets", "snapshots")
-	pattern := fmt.Sprintf("%s_*.tar.gz", commit)
-	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+// snapshotFile describes a snapshot archive by its file name,
+// <commit>_<timestamp>_<index>.tar[.gz|.zst][.age]
+type snapshotFile struct {
+	path      string
+	commit    string
+	timestamp string
+	index     int
+}
+
+// parseSnapshotName parses a snapshot archive path
+func parseSnapshotName(path string) (snapshotFile, bool) {
+	name, ok := trimArchiveExtension(filepath.Base(path))
+	if !ok {
+		return snapshotFile{}, false
+	}
+	parts := strings.Split(name, "_")
+	if len(parts) != 3 {
+		return snapshotFile{}, false
+	}
+	index, err := strconv.Atoi(parts[2])
 	if err != nil {
-		return "", fmt.Errorf("failed to list snapshots: %w", err)
+		return snapshotFile{}, false
 	}
+	return snapshotFile{path: path, commit: parts[0], timestamp: parts[1], index: index}, true
+}
 
-	if len(matches) == 0 {
-		return "", fmt.Errorf("no snapshots found for commit %s", commit)
+// sortNewestFirst sorts snapshots by timestamp and index, newest first
+func sortNewestFirst(snapshots []snapshotFile) {
+	sort.Slice(snapshots, func(i, j int) bool {
+		if snapshots[i].timestamp != snapshots[j].timestamp {
+			return snapshots[i].timestamp > snapshots[j].timestamp
+		}
+		return snapshots[i].index > snapshots[j].index
+	})
+}
+
+// listSnapshots returns the snapshots for a commit, newest first
+func (st *Store) listSnapshots(commit string) ([]snapshotFile, error) {
+	dir := st.dir
+	pattern := fmt.Sprintf("%s_*.tar*", commit)
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list snapshots: %w", err)
 	}
 
-	if index >= len(matches) {
-		return "", fmt.Errorf("snapshot index %d not found for commit %s", index, commit)
+	snapshots := make([]snapshotFile, 0, len(matches))
+	for _, path := range matches {
+		if s, ok := parseSnapshotName(path); ok && s.commit == commit {
+			snapshots = append(snapshots, s)
+		}
 	}
+	sortNewestFirst(snapshots)
 
-	return matches[index], nil
+	return snapshots, nil
 }
 
-// addFileToArchive adds a file to the tar archive and updates the manifest
-func addFileToArchive(tw *tar.Writer, path string, manifest *Manifest) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
+// IsStorePath reports whether path lies inside the .ignoregrets directory
+func IsStorePath(path string) bool {
+	path = filepath.ToSlash(path)
+	return path == ".ignoregrets" || strings.HasPrefix(path, ".ignoregrets/")
+}
+
+// checkPath returns ErrUnsafePath unless path is a relative path within
+// the working tree and outside the .ignoregrets directory. Snapshots from
+// bundles and remotes are untrusted, so every path is checked before it
+// is imported or written.
+func checkPath(path string) error {
+	if !filepath.IsLocal(path) || IsStorePath(filepath.Clean(path)) {
+		return fmt.Errorf("%w: %q", ErrUnsafePath, path)
 	}
-	defer file.Close()
+	return nil
+}
 
-	info, err := file.Stat()
-	if err != nil {
-		return err
+// checkPaths checks the path of every file of a manifest with checkPath
+func checkPaths(manifest *Manifest) error {
+	for path := range manifest.Files {
+		if err := checkPath(path); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	hdr := &tar.Header{
-		Name: path,
-		Mode: int64(info.Mode()),
-		Size: info.Size(),
+// getNextIndex returns the next available index for a commit
+func (st *Store) getNextIndex(commit string) int {
+	snapshots, _ := st.listSnapshots(commit)
+	next := 0
+	for _, s := range snapshots {
+		if s.index >= next {
+			next = s.index + 1
+		}
 	}
+	return next
+}
 
-	if err := tw.WriteHeader(hdr); err != nil {
-		return err
+// FindSnapshot finds the snapshot file for a reference
+func (st *Store) FindSnapshot(ref Ref) (string, error) {
+	snapshots, err := st.listSnapshots(ref.Commit)
+	if err != nil {
+		return "", err
 	}
 
-	// Calculate SHA256 while copying
-	h := sha256.New()
-	if _, err := io.Copy(io.MultiWriter(tw, h), file); err != nil {
-		return err
+	if len(snapshots) == 0 {
+		return "", fmt.Errorf("%w for commit %s", ErrNoSnapshots, ref.Commit)
 	}
 
-	manifest.Files[path] = hex.EncodeToString(h.Sum(nil))
-	return nil
+	if ref.Index == Latest {
+		return snapshots[0].path, nil
+	}
+	for _, s := range snapshots {
+		if s.index == ref.Index {
+			return s.path, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %s", ErrNotFound, ref)
 }
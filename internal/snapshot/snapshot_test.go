@@ -3,7 +3,10 @@ package snapshot
 import (
 	"archive/tar"
 	"compress/gzip"
-	"encoding/json"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -62,6 +65,12 @@ func createTestManifest() (*Manifest, *config.Config) {
 // createTestSnapshot creates a test snapshot file
 func createTestSnapshot(t *testing.T, testFiles []string, manifest *Manifest) string {
 	snapshotPath := filepath.Join("testdata", ".ignoregrets", "snapshots", "test_snapshot.tar.gz")
+	writeTestSnapshot(t, snapshotPath, testFiles, manifest)
+	return snapshotPath
+}
+
+// writeTestSnapshot writes a snapshot archive of testFiles to snapshotPath
+func writeTestSnapshot(t *testing.T, snapshotPath string, testFiles []string, manifest *Manifest) {
 	file, err := os.Create(snapshotPath)
 	if err != nil {
 		t.Fatalf("Failed to create snapshot file: %v", err)
@@ -74,32 +83,38 @@ func createTestSnapshot(t *testing.T, testFiles []string, manifest *Manifest) st
 	tw := tar.NewWriter(gw)
 	defer tw.Close()
 
-	// Add test files to archive
-	for _, path := range testFiles {
-		if err := addFileToArchive(tw, path, manifest); err != nil {
-			t.Fatalf("Failed to add file to archive: %v", err)
-		}
-	}
-
-	// Write manifest
-	manifestData, err := json.Marshal(manifest)
+	// Write the manifest first, then the files it lists
+	checksums, err := ChecksumFiles(context.Background(), testFiles, 1, nil, nil)
 	if err != nil {
-		t.Fatalf("Failed to marshal manifest: %v", err)
-	}
-
-	hdr := &tar.Header{
-		Name: "manifest.json",
-		Mode: 0644,
-		Size: int64(len(manifestData)),
+		t.Fatalf("Failed to checksum files: %v", err)
 	}
-	if err := tw.WriteHeader(hdr); err != nil {
-		t.Fatalf("Failed to write manifest header: %v", err)
+	for path, checksum := range checksums {
+		manifest.Files[path] = checksum
 	}
-	if _, err := tw.Write(manifestData); err != nil {
+	if err := writeManifest(tw, manifest); err != nil {
 		t.Fatalf("Failed to write manifest: %v", err)
 	}
+	if err := archiveFiles(context.Background(), tw, testFiles, 1, make(map[string]string), nil); err != nil {
+		t.Fatalf("Failed to add file to archive: %v", err)
+	}
+}
 
-	return snapshotPath
+// chdirTemp switches to a fresh directory holding an empty snapshot store
+// and returns the store
+func chdirTemp(t testing.TB) *Store {
+	oldDir, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Failed to get current directory: %v", err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatalf("Failed to change directory: %v", err)
+	}
+	t.Cleanup(func() { os.Chdir(oldDir) })
+
+	if err := os.MkdirAll(filepath.Join(".ignoregrets", "snapshots"), 0755); err != nil {
+		t.Fatalf("Failed to create snapshots directory: %v", err)
+	}
+	return NewStore(filepath.Join(".ignoregrets", "snapshots"), Keys{})
 }
 
 // verifyManifest verifies the manifest contents
@@ -132,13 +147,14 @@ func TestCreateAndReadManifest(t *testing.T) {
 	snapshotPath := createTestSnapshot(t, testFiles, manifest)
 
 	// Read manifest back
+	st := NewStore(filepath.Dir(snapshotPath), Keys{})
 	file, err := os.Open(snapshotPath)
 	if err != nil {
 		t.Fatalf("Failed to open snapshot: %v", err)
 	}
 	defer file.Close()
 
-	readManifest, err := ReadManifest(file)
+	readManifest, err := st.ReadManifest(file)
 	if err != nil {
 		t.Fatalf("Failed to read manifest: %v", err)
 	}
@@ -153,6 +169,8 @@ func TestFilterFiles(t *testing.T) {
 		"file2.log",
 		".env",
 		"build/output.js",
+		".ignoregrets/config.yaml",
+		".ignoregrets/snapshots/abc_20250101T1000_0.tar.gz",
 	}
 
 	cfg := &config.Config{
@@ -180,4 +198,246 @@ func TestFilterFiles(t *testing.T) {
 	if !found {
 		t.Error("Expected .env to be included")
 	}
+
+	// Verify the store itself is never included
+	for _, file := range filtered {
+		if IsStorePath(file) {
+			t.Errorf("Expected store file to be excluded: %s", file)
+		}
+	}
+}
+
+func TestFindSnapshotNewestFirst(t *testing.T) {
+	st := chdirTemp(t)
+
+	dir := filepath.Join(".ignoregrets", "snapshots")
+	for _, name := range []string{
+		"abc123_20250101T1000_0.tar.gz",
+		"abc123_20250101T1200_2.tar.gz",
+		"abc123_20250101T1100_1.tar.gz",
+		"def456_20250101T1300_0.tar.gz",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatalf("Failed to create snapshot file: %v", err)
+		}
+	}
+
+	path, err := st.FindSnapshot(Ref{Commit: "abc123", Index: Latest})
+	if err != nil {
+		t.Fatalf("Failed to find snapshot: %v", err)
+	}
+	if filepath.Base(path) != "abc123_20250101T1200_2.tar.gz" {
+		t.Errorf("Expected latest snapshot, got %s", path)
+	}
+
+	path, err = st.FindSnapshot(Ref{Commit: "abc123", Index: 1})
+	if err != nil {
+		t.Fatalf("Failed to find snapshot: %v", err)
+	}
+	if filepath.Base(path) != "abc123_20250101T1100_1.tar.gz" {
+		t.Errorf("Expected snapshot with index 1, got %s", path)
+	}
+
+	if next := st.getNextIndex("abc123"); next != 3 {
+		t.Errorf("Expected next index 3, got %d", next)
+	}
+
+	if _, err := st.FindSnapshot(Ref{Commit: "abc123", Index: 3}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for unknown index, got %v", err)
+	}
+	if _, err := st.FindSnapshot(Ref{Commit: "fff999", Index: Latest}); !errors.Is(err, ErrNoSnapshots) {
+		t.Errorf("Expected ErrNoSnapshots for unknown commit, got %v", err)
+	}
+}
+
+func TestCarrySnapshot(t *testing.T) {
+	st := chdirTemp(t)
+
+	if err := os.WriteFile(".env", []byte("SECRET=1"), 0644); err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+
+	manifest, _ := createTestManifest()
+	manifest.CommitHash = "oldcommit"
+	writeTestSnapshot(t, filepath.Join(".ignoregrets", "snapshots", "oldcommit_20250101T1000_0.tar.gz"),
+		[]string{".env"}, manifest)
+
+	if err := st.CarrySnapshot(context.Background(), "oldcommit", "newcommit", ""); err != nil {
+		t.Fatalf("Failed to carry snapshot: %v", err)
+	}
+
+	path, err := st.FindSnapshot(Ref{Commit: "newcommit", Index: Latest})
+	if err != nil {
+		t.Fatalf("Carried snapshot not found: %v", err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+
+	carried, err := st.ReadManifest(file)
+	if err != nil {
+		t.Fatalf("Failed to read manifest: %v", err)
+	}
+	if carried.CommitHash != "newcommit" || carried.RewrittenFrom != "oldcommit" {
+		t.Errorf("Expected commit newcommit rewritten from oldcommit, got %s from %s",
+			carried.CommitHash, carried.RewrittenFrom)
+	}
+	if carried.Files[".env"] != manifest.Files[".env"] {
+		t.Error("Carried snapshot lost file checksums")
+	}
+
+	// The carried snapshot restores for the new commit
+	if err := os.Remove(".env"); err != nil {
+		t.Fatalf("Failed to remove test file: %v", err)
+	}
+	if err := st.RestoreSnapshot(context.Background(), Ref{Commit: "newcommit", Index: Latest}, RestoreOptions{}); err != nil {
+		t.Fatalf("Failed to restore carried snapshot: %v", err)
+	}
+	if data, err := os.ReadFile(".env"); err != nil || string(data) != "SECRET=1" {
+		t.Errorf("Expected restored .env, got %q (%v)", data, err)
+	}
+
+	// Commits without snapshots are skipped
+	if err := st.CarrySnapshot(context.Background(), "missing", "other", ""); err != nil {
+		t.Errorf("Expected no error for commit without snapshots, got %v", err)
+	}
+}
+
+func TestRestoreConflictPolicies(t *testing.T) {
+	st := chdirTemp(t)
+
+	files := map[string]string{
+		"edited.txt":  "snapshot",
+		"synced.txt":  "snapshot",
+		"missing.txt": "snapshot",
+	}
+	for path, content := range files {
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to create test file: %v", err)
+		}
+	}
+
+	manifest, _ := createTestManifest()
+	writeTestSnapshot(t, filepath.Join(".ignoregrets", "snapshots", "abc123_20250101T1000_0.tar.gz"),
+		[]string{"edited.txt", "synced.txt", "missing.txt"}, manifest)
+
+	// Working tree drifts: synced.txt matches its baseline snapshot, edited.txt doesn't
+	baseline := map[string]string{"synced.txt": checksumOf("working")}
+	writeFiles := func() {
+		for path, content := range map[string]string{"edited.txt": "local edit", "synced.txt": "working"} {
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatalf("Failed to write test file: %v", err)
+			}
+		}
+		os.Remove("missing.txt")
+	}
+
+	tests := []struct {
+		policy   string
+		prompt   func(string) bool
+		restored map[string]bool
+	}{
+		{config.ConflictSkip, nil, map[string]bool{"missing.txt": true}},
+		{config.ConflictOverwriteUnchanged, nil, map[string]bool{"missing.txt": true, "synced.txt": true}},
+		{config.ConflictOverwrite, nil, map[string]bool{"missing.txt": true, "synced.txt": true, "edited.txt": true}},
+		{config.ConflictPrompt, func(path string) bool { return path == "edited.txt" },
+			map[string]bool{"missing.txt": true, "edited.txt": true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			writeFiles()
+			err := st.RestoreSnapshot(context.Background(), Ref{Commit: "abc123", Index: Latest}, RestoreOptions{Policy: tt.policy, Baseline: baseline, Prompt: tt.prompt})
+			if err != nil {
+				t.Fatalf("Failed to restore: %v", err)
+			}
+			for path := range files {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					t.Fatalf("Failed to read %s: %v", path, err)
+				}
+				if restored := string(data) == "snapshot"; restored != tt.restored[path] {
+					t.Errorf("%s: expected restored=%v, got content %q", path, tt.restored[path], data)
+				}
+			}
+		})
+	}
+
+	// An up-to-date working tree restores nothing
+	if err := st.RestoreSnapshot(context.Background(), Ref{Commit: "abc123", Index: Latest}, RestoreOptions{Policy: config.ConflictOverwrite}); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	restore, err := planRestore(context.Background(), manifest, RestoreOptions{Policy: config.ConflictOverwrite})
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if len(restore) != 0 {
+		t.Errorf("Expected nothing to restore, got %v", restore)
+	}
+
+	// Paths limits the restore to the chosen files
+	writeFiles()
+	err = st.RestoreSnapshot(context.Background(), Ref{Commit: "abc123", Index: Latest}, RestoreOptions{Policy: config.ConflictOverwrite, Paths: []string{"edited.txt"}})
+	if err != nil {
+		t.Fatalf("Failed to restore chosen files: %v", err)
+	}
+	if data, _ := os.ReadFile("edited.txt"); string(data) != "snapshot" {
+		t.Errorf("Expected edited.txt to be restored, got %q", data)
+	}
+	if _, err := os.Stat("missing.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected missing.txt to be left missing, got %v", err)
+	}
+	if _, err := planRestore(context.Background(), manifest, RestoreOptions{Paths: []string{"other.txt"}}); err == nil {
+		t.Error("Expected an error for a path not in the snapshot")
+	}
+}
+
+// checksumOf returns the hex SHA256 of content
+func checksumOf(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestCountUnchanged(t *testing.T) {
+	manifest := &Manifest{Files: map[string]string{
+		".env":      checksumOf("A=1"),
+		"build/out": checksumOf("old"),
+	}}
+
+	checksums := map[string]string{
+		".env":      checksumOf("A=1"),
+		"build/out": checksumOf("new"),
+		"added":     checksumOf("x"),
+	}
+	if n := countUnchanged(checksums, manifest); n != 1 {
+		t.Errorf("Expected 1 unchanged file, got %d", n)
+	}
+
+	checksums["build/out"] = checksumOf("old")
+	delete(checksums, "added")
+	if n := countUnchanged(checksums, manifest); n != 2 {
+		t.Errorf("Expected 2 unchanged files, got %d", n)
+	}
+}
+
+func TestNearlyIdentical(t *testing.T) {
+	tests := []struct {
+		unchanged, total int
+		want             bool
+	}{
+		{9, 10, true},
+		{8, 10, false},
+		{18, 20, true},
+		{17, 20, false},
+		{1, 1, true},
+		{1, 2, false},
+		{0, 0, false},
+	}
+	for _, tt := range tests {
+		if got := nearlyIdentical(tt.unchanged, tt.total); got != tt.want {
+			t.Errorf("nearlyIdentical(%d, %d) = %v, want %v", tt.unchanged, tt.total, got, tt.want)
+		}
+	}
 }
@@ -0,0 +1,15 @@
+package snapshot
+
+import (
+	"os"
+	"syscall"
+)
+
+// statExtra returns the change time and inode number of a file
+func statExtra(info os.FileInfo) (int64, uint64) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return st.Ctim.Nano(), uint64(st.Ino)
+}
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package snapshot
+
+import "os"
+
+// statExtra returns the change time and inode number of a file. They are
+// not available on this platform, so only size and mtime are compared.
+func statExtra(info os.FileInfo) (int64, uint64) {
+	return 0, 0
+}
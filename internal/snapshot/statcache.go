@@ -0,0 +1,156 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// statCachePath is where the stat cache is kept inside the store
+var statCachePath = filepath.Join(".ignoregrets", "statcache.json")
+
+// racyWindow is how recently a file may have been modified and still be
+// cached. Filesystems with coarse timestamps can change a file again within
+// the same tick, so such entries are hashed again on the next run.
+const racyWindow = time.Second
+
+// statEntry is the stat information a checksum was computed from
+type statEntry struct {
+	Size     int64  `json:"size"`
+	ModTime  int64  `json:"mtime"`
+	ChangeAt int64  `json:"ctime"`
+	Inode    uint64 `json:"inode"`
+	Checksum string `json:"checksum"`
+}
+
+// StatCache maps paths to the checksum of their contents as of their last
+// known stat information, so unchanged files need not be read again. Like
+// git's index, an entry modified no earlier than the cache file itself is
+// racily clean and is not trusted.
+type StatCache struct {
+	mu      sync.Mutex
+	entries map[string]statEntry
+	seen    map[string]bool
+	written int64 // modification time of the cache file when loaded
+	dirty   bool
+}
+
+// newStatEntry builds a cache entry from file info
+func newStatEntry(info os.FileInfo, checksum string) statEntry {
+	ctime, inode := statExtra(info)
+	return statEntry{
+		Size:     info.Size(),
+		ModTime:  info.ModTime().UnixNano(),
+		ChangeAt: ctime,
+		Inode:    inode,
+		Checksum: checksum,
+	}
+}
+
+// LoadStatCache reads the stat cache from the store. A missing or
+// unreadable cache is treated as empty.
+func LoadStatCache() *StatCache {
+	cache := &StatCache{
+		entries: make(map[string]statEntry),
+		seen:    make(map[string]bool),
+	}
+
+	info, err := os.Stat(statCachePath)
+	if err != nil {
+		return cache
+	}
+	data, err := os.ReadFile(statCachePath)
+	if err != nil {
+		return cache
+	}
+	if err := json.Unmarshal(data, &cache.entries); err != nil {
+		cache.entries = make(map[string]statEntry)
+		return cache
+	}
+	cache.written = info.ModTime().UnixNano()
+	return cache
+}
+
+// Lookup returns the cached checksum for path if its stat information
+// matches info and the entry is not racily clean
+func (c *StatCache) Lookup(path string, info os.FileInfo) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[path] = true
+	entry, ok := c.entries[path]
+	if !ok || entry.ModTime >= c.written {
+		return "", false
+	}
+	current := newStatEntry(info, entry.Checksum)
+	if current != entry {
+		return "", false
+	}
+	return entry.Checksum, true
+}
+
+// Update records the checksum of path as computed from a file with the
+// given stat information
+func (c *StatCache) Update(path string, info os.FileInfo, checksum string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seen[path] = true
+	entry := newStatEntry(info, checksum)
+	if old, ok := c.entries[path]; !ok || old != entry {
+		c.entries[path] = entry
+		c.dirty = true
+	}
+}
+
+// Save writes the cache back to the store if it changed. Entries for files
+// that no longer exist are dropped, and entries modified within racyWindow
+// are not written so they are hashed again next time.
+func (c *StatCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	racy := time.Now().Add(-racyWindow).UnixNano()
+	entries := make(map[string]statEntry, len(c.entries))
+	for path, entry := range c.entries {
+		if entry.ModTime >= racy {
+			c.dirty = true
+			continue
+		}
+		if !c.seen[path] {
+			if _, err := os.Lstat(path); err != nil {
+				c.dirty = true
+				continue
+			}
+		}
+		entries[path] = entry
+	}
+	if !c.dirty {
+		return nil
+	}
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stat cache: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(statCachePath), 0755); err != nil {
+		return fmt.Errorf("failed to create store directory: %w", err)
+	}
+	if err := writeFileAtomic(statCachePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write stat cache: %w", err)
+	}
+	c.dirty = false
+	return nil
+}
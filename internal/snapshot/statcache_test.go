@@ -0,0 +1,168 @@
+package snapshot
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// writeAged writes a file with a modification time in the past, so cache
+// entries for it are not racily clean
+func writeAged(t *testing.T, path, content string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+	past := time.Now().Add(-age)
+	if err := os.Chtimes(path, past, past); err != nil {
+		t.Fatalf("Failed to set times on %s: %v", path, err)
+	}
+}
+
+// lookupCached loads the cache and looks up path with its current stat
+func lookupCached(t *testing.T, path string) (string, bool) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	return LoadStatCache().Lookup(path, info)
+}
+
+func TestStatCache(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll(".ignoregrets", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAged(t, "a.txt", "alpha", time.Hour)
+	writeAged(t, "b.txt", "beta", time.Hour)
+	writeAged(t, "fresh.txt", "fresh", 0)
+
+	cache := LoadStatCache()
+	files := []string{"a.txt", "b.txt", "fresh.txt"}
+	if _, err := ChecksumFiles(context.Background(), files, 2, cache, nil); err != nil {
+		t.Fatalf("Failed to checksum files: %v", err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+
+	// Unchanged files are served from the cache
+	if checksum, ok := lookupCached(t, "a.txt"); !ok || checksum != checksumOf("alpha") {
+		t.Errorf("Expected cached checksum for a.txt, got %q, %v", checksum, ok)
+	}
+
+	// Recently modified files are not cached
+	if _, ok := lookupCached(t, "fresh.txt"); ok {
+		t.Error("Expected racily clean fresh.txt not to be cached")
+	}
+
+	// Changed files miss the cache and are hashed again
+	writeAged(t, "b.txt", "beta, changed", time.Hour)
+	if _, ok := lookupCached(t, "b.txt"); ok {
+		t.Error("Expected cache miss for modified b.txt")
+	}
+	cache = LoadStatCache()
+	checksums, err := ChecksumFiles(context.Background(), []string{"b.txt"}, 1, cache, nil)
+	if err != nil {
+		t.Fatalf("Failed to checksum files: %v", err)
+	}
+	if checksums["b.txt"] != checksumOf("beta, changed") {
+		t.Error("Expected new checksum for modified b.txt")
+	}
+
+	// Deleted files are dropped on save
+	if err := os.Remove("a.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+	if _, ok := LoadStatCache().entries["a.txt"]; ok {
+		t.Error("Expected entry for deleted a.txt to be dropped")
+	}
+	if checksum, ok := lookupCached(t, "b.txt"); !ok || checksum != checksumOf("beta, changed") {
+		t.Errorf("Expected cached checksum for b.txt, got %q, %v", checksum, ok)
+	}
+}
+
+func TestStatCacheRacyClean(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll(".ignoregrets", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAged(t, "a.txt", "alpha", time.Hour)
+	cache := LoadStatCache()
+	if _, err := ChecksumFiles(context.Background(), []string{"a.txt"}, 1, cache, nil); err != nil {
+		t.Fatalf("Failed to checksum files: %v", err)
+	}
+	if err := cache.Save(); err != nil {
+		t.Fatalf("Failed to save cache: %v", err)
+	}
+
+	// An entry no older than the cache file itself is not trusted
+	older := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(statCachePath, older, older); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lookupCached(t, "a.txt"); ok {
+		t.Error("Expected racily clean entry not to be trusted")
+	}
+}
+
+func TestPlanRestoreUsesStatCache(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll(".ignoregrets", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	writeAged(t, "a.txt", "alpha", time.Hour)
+	writeAged(t, "b.txt", "beta", time.Hour)
+	manifest, _ := createTestManifest()
+	manifest.Files = map[string]string{
+		"a.txt": checksumOf("alpha"),
+		"b.txt": checksumOf("beta"),
+		"c.txt": checksumOf("gone"),
+	}
+
+	restore, err := planRestore(context.Background(), manifest, RestoreOptions{Jobs: 2})
+	if err != nil {
+		t.Fatalf("Failed to plan restore: %v", err)
+	}
+	if len(restore) != 1 || !restore["c.txt"] {
+		t.Errorf("Expected only the missing c.txt to be restored, got %v", restore)
+	}
+
+	// The next check of an unchanged tree reads no files
+	for _, path := range []string{"a.txt", "b.txt"} {
+		if checksum, ok := lookupCached(t, path); !ok || checksum != manifest.Files[path] {
+			t.Errorf("Expected a cached checksum for %s, got %q, %v", path, checksum, ok)
+		}
+	}
+}
+
+func TestPlanRestoreIgnoresStatCacheErrors(t *testing.T) {
+	chdirTemp(t)
+
+	// A directory where the cache file belongs makes saving it fail
+	if err := os.MkdirAll(statCachePath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeAged(t, "a.txt", "alpha", time.Hour)
+	manifest, _ := createTestManifest()
+	manifest.Files = map[string]string{
+		"a.txt": checksumOf("alpha"),
+		"b.txt": checksumOf("gone"),
+	}
+
+	restore, err := planRestore(context.Background(), manifest, RestoreOptions{Jobs: 1})
+	if err != nil {
+		t.Fatalf("Expected the restore plan despite the unwritable cache, got %v", err)
+	}
+	if len(restore) != 1 || !restore["b.txt"] {
+		t.Errorf("Expected only the missing b.txt to be restored, got %v", restore)
+	}
+}
@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+)
+
+// Store is a directory of snapshot archives and their manifest sidecars,
+// with the keys to its encrypted snapshots. Functions that find or read
+// snapshots are its methods, so stores in different directories and with
+// different keys can be used at once.
+type Store struct {
+	dir  string
+	keys *keyring
+}
+
+// NewStore returns the store in dir, finding keys for encrypted snapshots
+// through keys. The directory is created when the first snapshot is
+// written.
+func NewStore(dir string, keys Keys) *Store {
+	return &Store{dir: dir, keys: &keyring{dir: dir, keys: keys}}
+}
+
+// Dir returns the directory holding the store's snapshots
+func (st *Store) Dir() string {
+	return st.dir
+}
+
+// ErrCorrupt is returned by Verify when a snapshot's files don't match its
+// manifest
+var ErrCorrupt = errors.New("snapshot is corrupt")
+
+// Entry is a snapshot archive in the store
+type Entry struct {
+	Ref  Ref
+	Path string
+}
+
+// List returns the snapshots of commit, or of every commit if commit is
+// empty, ordered by commit and newest first
+func (st *Store) List(commit string) ([]Entry, error) {
+	dir := st.dir
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshots directory: %w", err)
+	}
+
+	byCommit := make(map[string][]snapshotFile)
+	for _, entry := range entries {
+		if entry.IsDir() || !IsArchive(entry.Name()) {
+			continue
+		}
+		s, ok := parseSnapshotName(filepath.Join(dir, entry.Name()))
+		if ok && (commit == "" || s.commit == commit) {
+			byCommit[s.commit] = append(byCommit[s.commit], s)
+		}
+	}
+
+	commits := make([]string, 0, len(byCommit))
+	for c := range byCommit {
+		commits = append(commits, c)
+	}
+	sort.Strings(commits)
+
+	var result []Entry
+	for _, c := range commits {
+		snapshots := byCommit[c]
+		sortNewestFirst(snapshots)
+		for _, s := range snapshots {
+			result = append(result, Entry{Ref: Ref{Commit: s.commit, Index: s.index}, Path: s.path})
+		}
+	}
+	return result, nil
+}
+
+// Delete removes a snapshot and its manifest sidecar. It refuses to delete
+// a snapshot that an incremental snapshot still uses as its parent.
+func (st *Store) Delete(ref Ref) error {
+	path, err := st.FindSnapshot(ref)
+	if err != nil {
+		return err
+	}
+	target, _ := parseSnapshotName(path)
+	ref = Ref{Commit: target.commit, Index: target.index}
+
+	all, err := st.List("")
+	if err != nil {
+		return err
+	}
+	for _, e := range all {
+		if e.Path == path {
+			continue
+		}
+		manifest, err := st.LoadManifest(e.Path)
+		if err != nil {
+			continue
+		}
+		if manifest.Parent != nil && *manifest.Parent == ref {
+			return fmt.Errorf("snapshot %s is the parent of incremental snapshot %s; compact that first", ref, e.Ref)
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to delete snapshot %s: %w", ref, err)
+	}
+	if err := os.Remove(sidecarPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete manifest sidecar of %s: %w", ref, err)
+	}
+	return nil
+}
+
+// Verify checks that a snapshot's archive can be read and that every file,
+// including those inherited from parent snapshots, matches the checksum in
+// the archive's manifest
+func (st *Store) Verify(ctx context.Context, ref Ref) error {
+	path, err := st.FindSnapshot(ref)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	manifest, err := st.ReadManifest(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+
+	return st.verifyFiles(ctx, path, manifest)
+}
+
+// verifyFiles checks every file of the snapshot archive at path against
+// the checksums in its manifest
+func (st *Store) verifyFiles(ctx context.Context, path string, manifest *Manifest) error {
+	want := make(map[string]bool, len(manifest.Files))
+	for name := range manifest.Files {
+		want[name] = true
+	}
+
+	var mismatched []string
+	err := st.readChain(ctx, path, manifest, want, func(hdr *tar.Header, r io.Reader) error {
+		h := sha256.New()
+		if _, err := io.Copy(h, backend.ContextReader(ctx, r)); err != nil {
+			return fmt.Errorf("failed to read %s: %w", hdr.Name, err)
+		}
+		if hex.EncodeToString(h.Sum(nil)) != manifest.Files[hdr.Name] {
+			mismatched = append(mismatched, hdr.Name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupt, err)
+	}
+	if len(mismatched) > 0 {
+		sort.Strings(mismatched)
+		return fmt.Errorf("%w: checksum mismatch for %v", ErrCorrupt, mismatched)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package snapshot
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+)
+
+// ManifestVersion is the manifest format this version of ignoregrets
+// reads and writes. Manifests without a version are version 0.
+const ManifestVersion = 1
+
+// ErrNewerVersion is returned for manifests and config files written by
+// a newer version of ignoregrets
+var ErrNewerVersion = config.ErrNewerVersion
+
+// manifestMigrations holds the step from each manifest version to the one
+// after it. A change to the format adds a step here and bumps
+// ManifestVersion.
+var manifestMigrations = map[int]func(m *Manifest) error{
+	// Version 0 only lacks the version field
+	0: func(m *Manifest) error { return nil },
+}
+
+// decodeManifest parses a manifest and upgrades it to ManifestVersion
+func decodeManifest(data []byte) (*Manifest, error) {
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if err := upgradeManifest(manifest); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// upgradeManifest upgrades a manifest to ManifestVersion in memory. It
+// fails with ErrNewerVersion for a manifest of a newer format, which this
+// version of ignoregrets could misread.
+func upgradeManifest(m *Manifest) error {
+	if m.Version > ManifestVersion {
+		return fmt.Errorf("manifest version %d is %w", m.Version, ErrNewerVersion)
+	}
+	for v := m.Version; v < ManifestVersion; v++ {
+		migrate, ok := manifestMigrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from manifest version %d", v)
+		}
+		if err := migrate(m); err != nil {
+			return fmt.Errorf("failed to upgrade manifest from version %d: %w", v, err)
+		}
+	}
+	m.Version = ManifestVersion
+	return nil
+}
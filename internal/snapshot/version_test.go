@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestVersion(t *testing.T) {
+	st := chdirTemp(t)
+
+	// Manifests from before versioning are upgraded
+	manifest, _ := createTestManifest()
+	manifest.Version = 0
+	path := filepath.Join(".ignoregrets", "snapshots", "c1_20250101T1000_0.tar.gz")
+	if err := st.writeSnapshot(context.Background(), path, manifest, nil, 0, 1, nil); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	loaded, err := st.LoadManifest(path)
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if loaded.Version != ManifestVersion {
+		t.Errorf("Expected manifest version %d, got %d", ManifestVersion, loaded.Version)
+	}
+
+	// Newer manifests are rejected, from the sidecar and from the archive
+	manifest.Version = ManifestVersion + 1
+	path = filepath.Join(".ignoregrets", "snapshots", "c2_20250101T1000_0.tar.gz")
+	if err := st.writeSnapshot(context.Background(), path, manifest, nil, 0, 1, nil); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+	if _, err := st.LoadManifest(path); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Expected ErrNewerVersion, got %v", err)
+	}
+	if err := os.Remove(sidecarPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.LoadManifest(path); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Expected ErrNewerVersion without a sidecar, got %v", err)
+	}
+}
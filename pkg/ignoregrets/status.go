@@ -0,0 +1,82 @@
+package ignoregrets
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/internal/lock"
+	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
+)
+
+// Status compares the working files with a snapshot. Each list is sorted.
+type Status struct {
+	Snapshot  *Snapshot
+	Unchanged []string
+	Modified  []string
+	Added     []string
+	Deleted   []string
+
+	// Checksums holds the current checksum of each working file
+	Checksums map[string]string
+}
+
+// Status compares the current ignored files with the snapshot for ref.
+// Files whose stat information is unchanged since they were last hashed
+// are not read again.
+func (s *Store) Status(ctx context.Context, ref SnapshotRef) (*Status, error) {
+	l, err := s.acquire(ctx, lock.Shared)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	snap, err := s.get(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	currentFiles, err := git.GetIgnoredFiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+	files := make([]string, 0, len(currentFiles))
+	for _, file := range currentFiles {
+		if !snapshot.IsStorePath(file) {
+			files = append(files, file)
+		}
+	}
+
+	cache := snapshot.LoadStatCache()
+	checksums, err := snapshot.ChecksumFiles(ctx, files, s.cfg.Parallelism(), cache, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Save(); err != nil {
+		return nil, err
+	}
+
+	status := &Status{Snapshot: snap, Checksums: checksums}
+	for file, checksum := range snap.Manifest.Files {
+		current, exists := checksums[file]
+		switch {
+		case !exists:
+			status.Deleted = append(status.Deleted, file)
+		case current != checksum:
+			status.Modified = append(status.Modified, file)
+		default:
+			status.Unchanged = append(status.Unchanged, file)
+		}
+	}
+	for file := range checksums {
+		if _, ok := snap.Manifest.Files[file]; !ok {
+			status.Added = append(status.Added, file)
+		}
+	}
+
+	sort.Strings(status.Unchanged)
+	sort.Strings(status.Modified)
+	sort.Strings(status.Added)
+	sort.Strings(status.Deleted)
+	return status, nil
+}
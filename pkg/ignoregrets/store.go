@@ -0,0 +1,546 @@
+// Package ignoregrets provides access to the snapshot store of a Git
+// repository, as used by the ignoregrets command. Paths are relative to
+// the working directory, which must be the root of the repository.
+package ignoregrets
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Cod-e-Codes/ignoregrets/internal/backend"
+	"github.com/Cod-e-Codes/ignoregrets/internal/config"
+	"github.com/Cod-e-Codes/ignoregrets/internal/git"
+	"github.com/Cod-e-Codes/ignoregrets/internal/lock"
+	"github.com/Cod-e-Codes/ignoregrets/internal/snapshot"
+)
+
+// Config is the repository configuration from .ignoregrets/config.yaml
+type Config = config.Config
+
+// Manifest is the metadata stored with a snapshot
+type Manifest = snapshot.Manifest
+
+// SnapshotRef identifies a snapshot by commit and index, as shown by
+// 'ignoregrets list'. An empty commit means HEAD, and the Latest index
+// selects the newest snapshot of the commit.
+type SnapshotRef = snapshot.Ref
+
+// ReasonPreClean marks a safety snapshot taken before 'git clean'
+const ReasonPreClean = snapshot.ReasonPreClean
+
+// Latest is the SnapshotRef index that selects the newest snapshot
+const Latest = snapshot.Latest
+
+// CreateOptions controls how Create creates a snapshot. Create sets its
+// Profile to the store's; see WithProfile.
+type CreateOptions = snapshot.Options
+
+// RestoreOptions controls how Restore treats existing files
+type RestoreOptions = snapshot.RestoreOptions
+
+// PruneOptions controls how Prune deletes snapshots
+type PruneOptions = snapshot.PruneOptions
+
+// ExportOptions controls how Export writes a bundle
+type ExportOptions = snapshot.ExportOptions
+
+// ImportOptions controls how Import checks a bundle
+type ImportOptions = snapshot.ImportOptions
+
+// ImportResult describes a snapshot added by Import
+type ImportResult = snapshot.ImportResult
+
+// Backend stores snapshot archives and manifest sidecars by file name.
+// Push and Pull sync the store with any Backend.
+type Backend = backend.Backend
+
+// Object describes an object in a Backend
+type Object = backend.Object
+
+// SyncResult reports what Push or Pull copied
+type SyncResult = snapshot.SyncResult
+
+// SyncFunc receives each object Push or Pull copies
+type SyncFunc = snapshot.SyncFunc
+
+// Progress reports how far Create or Restore has got
+type Progress = snapshot.Progress
+
+// ProgressFunc receives progress updates, set in CreateOptions.Progress or
+// RestoreOptions.Progress. It is never called concurrently.
+type ProgressFunc = snapshot.ProgressFunc
+
+// PassphraseFunc asks for the passphrase of passphrase-encrypted
+// snapshots, twice if confirm is set because a new one is being chosen
+type PassphraseFunc = snapshot.PassphraseFunc
+
+// Environment variables that supply keys for encrypted snapshots without
+// asking, e.g. in hooks
+const (
+	PassphraseEnv = snapshot.PassphraseEnv
+	IdentityEnv   = snapshot.IdentityEnv
+)
+
+// Finding is a secret found in a snapshot file, as recorded in
+// Manifest.Secrets. It never holds the secret itself.
+type Finding = snapshot.Finding
+
+// SecretFunc receives each secret found by Create, set in
+// CreateOptions.OnSecret
+type SecretFunc = snapshot.SecretFunc
+
+// Secret rule actions, as in Finding.Action
+const (
+	SecretWarn    = config.SecretWarn
+	SecretExclude = config.SecretExclude
+	SecretRecord  = config.SecretRecord
+)
+
+// Phases reported in Progress
+const (
+	PhaseHashing   = snapshot.PhaseHashing
+	PhaseArchiving = snapshot.PhaseArchiving
+	PhaseRestoring = snapshot.PhaseRestoring
+)
+
+// DefaultProfile names the top-level settings in WithProfile
+const DefaultProfile = config.DefaultProfile
+
+// KeepAll is the retention of profiles whose snapshots are never pruned
+const KeepAll = config.KeepAll
+
+// Conflict policies for RestoreOptions.Policy
+const (
+	ConflictSkip               = config.ConflictSkip
+	ConflictOverwriteUnchanged = config.ConflictOverwriteUnchanged
+	ConflictOverwrite          = config.ConflictOverwrite
+	ConflictPrompt             = config.ConflictPrompt
+)
+
+// Errors returned by Store methods, for use with errors.Is
+var (
+	ErrNoSnapshots  = snapshot.ErrNoSnapshots
+	ErrNotFound     = snapshot.ErrNotFound
+	ErrNoFiles      = snapshot.ErrNoFiles
+	ErrUnchanged    = snapshot.ErrUnchanged
+	ErrCorrupt      = snapshot.ErrCorrupt
+	ErrLocked       = lock.ErrLocked
+	ErrNoKey        = snapshot.ErrNoKey
+	ErrNewerVersion = snapshot.ErrNewerVersion
+	ErrUnsafePath   = snapshot.ErrUnsafePath
+
+	ErrNotBundle       = snapshot.ErrNotBundle
+	ErrSecrets         = snapshot.ErrSecrets
+	ErrAlreadyImported = snapshot.ErrAlreadyImported
+	ErrBadSignature    = snapshot.ErrBadSignature
+	ErrUntrusted       = snapshot.ErrUntrusted
+	ErrNotExist        = backend.ErrNotExist
+)
+
+// StoreEnv names a directory that overrides store_path
+const StoreEnv = "IGNOREGRETS_STORE"
+
+// defaultDir holds the store when no store path is set
+const defaultDir = ".ignoregrets"
+
+// Snapshot is a snapshot archive in the store
+type Snapshot struct {
+	Ref      SnapshotRef
+	Path     string
+	Manifest *Manifest
+
+	// Err is set by List instead of Manifest when the manifest can't be
+	// read, e.g. for a truncated archive
+	Err error
+}
+
+// Store is the snapshot store of the repository in the working directory.
+// Methods that read the store take a shared lock and methods that change
+// it take an exclusive lock, so other processes can use the store safely.
+type Store struct {
+	cfg      *Config
+	lockWait time.Duration
+
+	// base is the configuration without a profile applied, and profile
+	// the name of the profile cfg has applied
+	base    *Config
+	profile string
+
+	// dir is the store directory, resolved by New, and snapshots the
+	// snapshot store inside it
+	dir       string
+	snapshots *snapshot.Store
+}
+
+// Open loads and validates the repository configuration, merged from the
+// global, project and local config files, the environment and overrides,
+// key=value settings that take precedence over them all, and returns its
+// store
+func Open(overrides ...string) (*Store, error) {
+	cfg, origins, err := config.Load(overrides...)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.Validate(cfg, origins); err != nil {
+		return nil, err
+	}
+	return New(cfg)
+}
+
+// New returns the store of the repository in the working directory using
+// cfg instead of the configuration file. The store directory is resolved
+// once, here; see Dir.
+func New(cfg *Config) (*Store, error) {
+	dir, err := storeDir(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	keys := snapshot.Keys{IdentityFile: cfg.IdentityFile}
+	return &Store{
+		cfg:       cfg,
+		base:      cfg,
+		dir:       dir,
+		snapshots: snapshot.NewStore(filepath.Join(dir, "snapshots"), keys),
+	}, nil
+}
+
+// WithProfile returns a store that uses the settings of the named profile
+// from the configuration. Its snapshots record the profile, and Latest
+// selects the newest snapshot taken with it. The empty name and
+// DefaultProfile select the top-level settings and snapshots taken
+// without a profile.
+func (s *Store) WithProfile(name string) (*Store, error) {
+	cfg, err := s.base.Profile(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == DefaultProfile {
+		name = ""
+	}
+	profiled := *s
+	profiled.cfg = cfg
+	profiled.profile = name
+	return &profiled, nil
+}
+
+// Profile returns the name of the store's profile, or an empty string for
+// the top-level settings
+func (s *Store) Profile() string {
+	return s.profile
+}
+
+// Profiles returns the names of the configured profiles in order
+func (s *Store) Profiles() []string {
+	return s.base.ProfileNames()
+}
+
+// Config returns the configuration the store was opened with, with its
+// profile applied
+func (s *Store) Config() *Config {
+	return s.cfg
+}
+
+// SetLockWait sets how long methods wait for another process to release
+// the store lock before failing with ErrLocked. The default is not to wait.
+func (s *Store) SetLockWait(d time.Duration) {
+	s.lockWait = d
+}
+
+// SetPassphraseFunc sets how the passphrase of encrypted snapshots is
+// asked for when PassphraseEnv is not set. Without one, reading or
+// creating passphrase-encrypted snapshots fails with ErrNoKey. Stores
+// returned by WithProfile share the passphrase with the store they came
+// from.
+func (s *Store) SetPassphraseFunc(fn PassphraseFunc) {
+	s.snapshots.SetKeys(snapshot.Keys{IdentityFile: s.cfg.IdentityFile, Passphrase: fn})
+}
+
+// Dir returns the directory holding the snapshots and lock files:
+// .ignoregrets, or with StoreEnv or store_path set, the directory named by
+// the repository id under it. A relative store path is relative to the
+// repository root.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// storeDir resolves the store directory for cfg, as returned by Dir
+func storeDir(ctx context.Context, cfg *Config) (string, error) {
+	root := os.Getenv(StoreEnv)
+	if root == "" {
+		root = cfg.StorePath
+	}
+	if root == "" {
+		return defaultDir, nil
+	}
+
+	if rest, ok := strings.CutPrefix(root, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to find home directory: %w", err)
+		}
+		root = filepath.Join(home, rest)
+	}
+	id, err := git.RepoID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, id), nil
+}
+
+// acquire creates the store directory if needed and takes the store lock
+// in mode
+func (s *Store) acquire(ctx context.Context, mode lock.Mode) (*lock.Lock, error) {
+	if err := os.MkdirAll(s.snapshots.Dir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create snapshots directory: %w", err)
+	}
+	return lock.Acquire(ctx, filepath.Join(s.dir, "locks"), mode, s.lockWait)
+}
+
+// List returns the snapshots of commit, or of every commit if commit is
+// empty, ordered by commit and newest first
+func (s *Store) List(commit string) ([]Snapshot, error) {
+	l, err := s.acquire(context.Background(), lock.Shared)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	entries, err := s.snapshots.List(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]Snapshot, 0, len(entries))
+	for _, e := range entries {
+		manifest, err := s.snapshots.LoadManifest(e.Path)
+		snapshots = append(snapshots, Snapshot{Ref: e.Ref, Path: e.Path, Manifest: manifest, Err: err})
+	}
+	return snapshots, nil
+}
+
+// Get returns the snapshot for ref
+func (s *Store) Get(ref SnapshotRef) (*Snapshot, error) {
+	l, err := s.acquire(context.Background(), lock.Shared)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.get(ref)
+}
+
+// get returns the snapshot for ref without taking the lock
+func (s *Store) get(ref SnapshotRef) (*Snapshot, error) {
+	ref, err := s.resolve(context.Background(), ref)
+	if err != nil {
+		return nil, err
+	}
+	path, err := s.snapshots.FindSnapshot(ref)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := s.snapshots.LoadManifest(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Ref:      SnapshotRef{Commit: manifest.CommitHash, Index: manifest.Index},
+		Path:     path,
+		Manifest: manifest,
+	}, nil
+}
+
+// Create snapshots the ignored files. If nothing changed since the
+// previous snapshot and opts.Always is not set, it returns that snapshot
+// with ErrUnchanged. If ctx is cancelled, the partial archive is removed.
+func (s *Store) Create(ctx context.Context, opts CreateOptions) (*Snapshot, error) {
+	l, err := s.acquire(ctx, lock.Exclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+
+	opts.Profile = s.profile
+	manifest, err := s.snapshots.CreateSnapshot(ctx, s.cfg, opts)
+	if manifest == nil {
+		return nil, err
+	}
+	created, getErr := s.get(SnapshotRef{Commit: manifest.CommitHash, Index: manifest.Index})
+	if getErr != nil {
+		return nil, getErr
+	}
+	return created, err
+}
+
+// Restore restores files from the snapshot for ref
+func (s *Store) Restore(ctx context.Context, ref SnapshotRef, opts RestoreOptions) error {
+	l, err := s.acquire(ctx, lock.Shared)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	ref, err = s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	if opts.Jobs == 0 {
+		opts.Jobs = s.cfg.Parallelism()
+	}
+	return s.snapshots.RestoreSnapshot(ctx, ref, opts)
+}
+
+// Delete removes the snapshot for ref. Snapshots that an incremental
+// snapshot depends on cannot be deleted.
+func (s *Store) Delete(ref SnapshotRef) error {
+	l, err := s.acquire(context.Background(), lock.Exclusive)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	ref, err = s.resolve(context.Background(), ref)
+	if err != nil {
+		return err
+	}
+	return s.snapshots.Delete(ref)
+}
+
+// Verify checks the snapshot for ref against its manifest checksums,
+// returning an error wrapping ErrCorrupt if they don't match
+func (s *Store) Verify(ctx context.Context, ref SnapshotRef) error {
+	l, err := s.acquire(ctx, lock.Shared)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	ref, err = s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.snapshots.Verify(ctx, ref)
+}
+
+// Carry copies the newest snapshot of the store's profile for oldCommit to
+// newCommit after history is rewritten. It does nothing if oldCommit has
+// no such snapshot.
+func (s *Store) Carry(ctx context.Context, oldCommit, newCommit string) error {
+	l, err := s.acquire(ctx, lock.Exclusive)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return s.snapshots.CarrySnapshot(ctx, oldCommit, newCommit, s.profile)
+}
+
+// Prune deletes old snapshots, keeping the newest opts.Retention per
+// commit, and counting the snapshots of each profile separately. A zero
+// retention uses the configured ones.
+func (s *Store) Prune(ctx context.Context, opts PruneOptions) error {
+	if opts.Profiles == nil && len(s.base.Profiles) > 0 {
+		opts.Profiles = make(map[string]int, len(s.base.Profiles))
+		for _, name := range s.base.ProfileNames() {
+			cfg, err := s.base.Profile(name)
+			if err != nil {
+				return err
+			}
+			opts.Profiles[name] = cfg.Retention
+			if opts.Retention != 0 {
+				opts.Profiles[name] = opts.Retention
+			}
+		}
+	}
+	if opts.Retention == 0 {
+		opts.Retention = s.base.Retention
+	}
+	l, err := s.acquire(ctx, lock.Exclusive)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	return s.snapshots.Prune(ctx, opts)
+}
+
+// Export writes the snapshot for ref to a bundle file at out, which
+// Import adds to the store of another clone. Unencrypted snapshots with
+// files recorded as secret fail with ErrSecrets unless opts.AllowSecrets
+// is set.
+func (s *Store) Export(ctx context.Context, ref SnapshotRef, out string, opts ExportOptions) error {
+	l, err := s.acquire(ctx, lock.Shared)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+	ref, err = s.resolve(ctx, ref)
+	if err != nil {
+		return err
+	}
+	return s.snapshots.Export(ctx, ref, out, opts)
+}
+
+// Import checks the bundle at path and adds its snapshot to the store. If
+// the store already has the snapshot, it returns that with
+// ErrAlreadyImported.
+func (s *Store) Import(ctx context.Context, path string, opts ImportOptions) (*ImportResult, error) {
+	l, err := s.acquire(ctx, lock.Exclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.snapshots.Import(ctx, path, opts)
+}
+
+// Remote returns the backend for the configured remote
+func (s *Store) Remote() (Backend, error) {
+	return backend.Open(s.cfg.Remote)
+}
+
+// Push copies the snapshots remote lacks to it. Snapshots whose commit
+// and index are taken by a different snapshot on remote are left out and
+// listed in the result's Conflicts.
+func (s *Store) Push(ctx context.Context, remote Backend, fn SyncFunc) (*SyncResult, error) {
+	l, err := s.acquire(ctx, lock.Shared)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.snapshots.Push(ctx, remote, fn)
+}
+
+// Pull copies the snapshots the store lacks from remote, leaving out
+// conflicting ones like Push
+func (s *Store) Pull(ctx context.Context, remote Backend, fn SyncFunc) (*SyncResult, error) {
+	l, err := s.acquire(ctx, lock.Exclusive)
+	if err != nil {
+		return nil, err
+	}
+	defer l.Release()
+	return s.snapshots.Pull(ctx, remote, fn)
+}
+
+// ParseRef parses a snapshot reference written as <commit>[:<index>]
+func ParseRef(s string) (SnapshotRef, error) {
+	return snapshot.ParseRef(s)
+}
+
+// resolve fills in HEAD for a reference without a commit, and the index of
+// the newest snapshot of the store's profile for the Latest index. The
+// store must be acquired.
+func (s *Store) resolve(ctx context.Context, ref SnapshotRef) (SnapshotRef, error) {
+	if ref.Commit == "" {
+		head, err := git.GetCurrentCommit(ctx)
+		if err != nil {
+			return ref, err
+		}
+		ref.Commit = head
+	}
+	if ref.Index == Latest {
+		manifest, err := s.snapshots.LatestManifest(ref.Commit, s.profile)
+		if err != nil {
+			return ref, err
+		}
+		ref.Index = manifest.Index
+	}
+	return ref, nil
+}